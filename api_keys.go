@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+/*
+API keys
+
+Integrations that can't keep refreshing a JWT authenticate with a long-lived API
+key instead, sent as
+
+	X-API-Key: tubely_<random>
+
+Keys are created and revoked by their user under /api/v1/api_keys, and like
+share link tokens only their SHA-256 is stored. apiKeyMiddleware swaps a valid
+key for a short-lived access token for its user before the request reaches the
+handler, so every endpoint accepts keys with the same ownership checks it
+applies to JWTs. Keys can't be used to manage keys.
+*/
+
+const (
+	apiKeyHeader = "X-API-Key"
+	apiKeyPrefix = "tubely_"
+	// Lifetime of the access token a key is swapped for; it's only used for
+	// the one request
+	apiKeyTokenExpiry = 5 * time.Minute
+)
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Lets API requests authenticate with X-API-Key in place of a bearer JWT
+func (cfg *apiConfig) apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		if key == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, err := cfg.db.GetAPIKeyByHash(hashAPIKey(key))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check API key", err)
+			return
+		}
+		if apiKey.ID == uuid.Nil || apiKey.RevokedAt != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+			return
+		}
+
+		token, err := auth.MakeJWT(apiKey.UserID, cfg.jwtKeys, apiKeyTokenExpiry)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't authenticate API key", err)
+			return
+		}
+		err = cfg.db.RecordAPIKeyUse(apiKey.ID)
+		if err != nil {
+			log.Printf("Couldn't record use of API key %s: %v", apiKey.ID, err)
+		}
+
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+		next.ServeHTTP(w, r)
+	})
+}
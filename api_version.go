@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Current API version; routes are mounted under /api/<apiVersion>/
+const apiVersion = "v1"
+
+// Set at build time, e.g.
+// go build -ldflags "-X main.buildVersion=1.4.0 -X main.buildCommit=$(git rev-parse HEAD)"
+var (
+	buildVersion = "dev"
+	buildCommit  = ""
+)
+
+// Registers an API route under the versioned prefix, plus the old unversioned
+// path as a deprecated alias. pattern is "METHOD /path", e.g. "GET /videos".
+func handleAPI(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	mux.HandleFunc(method+" /api/"+apiVersion+path, handler)
+	mux.Handle(method+" /api"+path, deprecatedRouteMiddleware(handler))
+}
+
+// Marks responses from unversioned routes as deprecated and points clients at the
+// versioned equivalent
+func deprecatedRouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := "/api/" + apiVersion + strings.TrimPrefix(r.URL.Path, "/api")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) handlerVersion(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Version    string `json:"version"`
+		Commit     string `json:"commit"`
+		APIVersion string `json:"api_version"`
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Version:    buildVersion,
+		Commit:     getBuildCommit(),
+		APIVersion: apiVersion,
+	})
+}
+
+// Falls back to the VCS revision Go embeds in binaries built from a git checkout
+func getBuildCommit() string {
+	if buildCommit != "" {
+		return buildCommit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
@@ -26,7 +26,7 @@ async function createVideoDraft() {
   const description = document.getElementById('video-description').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await fetch('/api/v1/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -54,7 +54,7 @@ async function login() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/login', {
+    const res = await fetch('/api/v1/login', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -84,7 +84,7 @@ async function signup() {
   const password = document.getElementById('password').value;
 
   try {
-    const res = await fetch('/api/users', {
+    const res = await fetch('/api/v1/users', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
@@ -130,7 +130,7 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await fetch(`/api/v1/thumbnail_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -163,7 +163,7 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await fetch(`/api/v1/video_upload/${videoID}`, {
       method: 'POST',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -186,9 +186,12 @@ async function uploadVideoFile(videoID) {
 
 const videoStateHandler = createVideoStateHandler();
 
-async function getVideos() {
+const videosPageSize = 50;
+let videosOffset = 0;
+
+async function getVideos(offset = videosOffset) {
   try {
-    const res = await fetch('/api/videos', {
+    const res = await fetch(`/api/v1/videos?limit=${videosPageSize}&offset=${offset}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -199,20 +202,57 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const page = await res.json();
+    // The last page can empty out when its only video is deleted
+    if (page.videos.length === 0 && offset > 0) {
+      await getVideos(Math.max(0, offset - videosPageSize));
+      return;
+    }
+    videosOffset = offset;
+
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
-    for (const video of videos) {
+    for (const video of page.videos) {
       const listItem = document.createElement('li');
-      listItem.textContent = video.title;
+      listItem.textContent = video.duration_seconds
+        ? `${video.title} (${formatDuration(video.duration_seconds)})`
+        : video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+    renderVideoPagination(page.total_count);
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
 }
 
+// Formats seconds as m:ss, or h:mm:ss for an hour or more
+function formatDuration(seconds) {
+  const total = Math.round(seconds);
+  const h = Math.floor(total / 3600);
+  const m = Math.floor((total % 3600) / 60);
+  const s = String(total % 60).padStart(2, '0');
+  return h > 0 ? `${h}:${String(m).padStart(2, '0')}:${s}` : `${m}:${s}`;
+}
+
+function renderVideoPagination(totalCount) {
+  const pagination = document.getElementById('video-pagination');
+  const pageCount = Math.ceil(totalCount / videosPageSize);
+  pagination.style.display = pageCount > 1 ? 'flex' : 'none';
+  document.getElementById('video-page-info').textContent =
+    `Page ${Math.floor(videosOffset / videosPageSize) + 1} of ${pageCount}`;
+  document.getElementById('video-page-prev').disabled = videosOffset === 0;
+  document.getElementById('video-page-next').disabled = videosOffset + videosPageSize >= totalCount;
+}
+
+function previousVideosPage() {
+  getVideos(Math.max(0, videosOffset - videosPageSize));
+}
+
+function nextVideosPage() {
+  getVideos(videosOffset + videosPageSize);
+}
+
 function createVideoStateHandler() {
   let currentVideoID = null;
 
@@ -231,7 +271,7 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
+    const res = await fetch(`/api/v1/videos/${videoID}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -261,7 +301,23 @@ function viewVideo(video) {
     thumbnailImg.style.display = 'none';
   } else {
     thumbnailImg.style.display = 'block';
-    thumbnailImg.src = video.thumbnail_url;
+    // Fills the thumbnail's box with its dominant color while nothing has loaded
+    thumbnailImg.style.backgroundColor = video.thumbnail_color || '';
+    if (!video.thumbnail_placeholder) {
+      thumbnailImg.classList.remove('placeholder');
+      thumbnailImg.src = video.thumbnail_url;
+    } else {
+      // Show the blurred placeholder until the real thumbnail has loaded
+      thumbnailImg.classList.add('placeholder');
+      thumbnailImg.src = video.thumbnail_placeholder;
+      const fullImg = new Image();
+      fullImg.onload = () => {
+        if (currentVideo !== video) return;
+        thumbnailImg.classList.remove('placeholder');
+        thumbnailImg.src = video.thumbnail_url;
+      };
+      fullImg.src = video.thumbnail_url;
+    }
   }
 
   const videoPlayer = document.getElementById('video-player');
@@ -283,7 +339,7 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await fetch(`/api/v1/videos/${currentVideo.id}`, {
       method: 'DELETE',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
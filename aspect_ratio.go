@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Videos within aspectRatioTolerance of 16:9 are landscape, within it of 9:16
+// portrait, and everything else is other
+const (
+	landscapeAspectRatio = 16.0 / 9.0
+	portraitAspectRatio  = 9.0 / 16.0
+	aspectRatioTolerance = 0.1
+	// Ratios this close to the edge of a category are reported as borderline
+	aspectRatioBorderlineMargin = 0.02
+)
+
+// Common display ratios, for telling the user what their video is closest to
+var standardAspectRatios = []struct {
+	name  string
+	ratio float64
+}{
+	{"21:9", 21.0 / 9.0},
+	{"16:9", 16.0 / 9.0},
+	{"3:2", 3.0 / 2.0},
+	{"4:3", 4.0 / 3.0},
+	{"1:1", 1},
+	{"4:5", 4.0 / 5.0},
+	{"3:4", 3.0 / 4.0},
+	{"2:3", 2.0 / 3.0},
+	{"9:16", 9.0 / 16.0},
+}
+
+// How categorizeAspectRatio sees a video's dimensions
+type aspectRatioDetails struct {
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Category string  `json:"category"`
+	Ratio    float64 `json:"ratio"`
+	// The standard ratio closest to Ratio
+	NearestStandard      string  `json:"nearest_standard"`
+	NearestStandardRatio float64 `json:"nearest_standard_ratio"`
+	Tolerance            float64 `json:"tolerance"`
+	// How far Ratio is from the edge of its category, in either direction
+	Margin float64 `json:"margin"`
+	// Set when Margin is small enough that a slightly different crop would land
+	// the video in BorderlineWith instead
+	Borderline     bool   `json:"borderline"`
+	BorderlineWith string `json:"borderline_with,omitempty"`
+}
+
+func (d aspectRatioDetails) String() string {
+	return fmt.Sprintf("%s (%.4f, nearest %s, margin %.4f)", d.Category, d.Ratio, d.NearestStandard, d.Margin)
+}
+
+// Works out the category of a width x height video along with the numbers
+// behind it
+func analyzeAspectRatio(width, height int) aspectRatioDetails {
+	details := aspectRatioDetails{
+		Width:     width,
+		Height:    height,
+		Category:  "other",
+		Tolerance: aspectRatioTolerance,
+	}
+	if width <= 0 || height <= 0 {
+		return details
+	}
+	details.Ratio = float64(width) / float64(height)
+
+	nearest := math.Inf(1)
+	for _, standard := range standardAspectRatios {
+		if distance := math.Abs(details.Ratio - standard.ratio); distance < nearest {
+			nearest = distance
+			details.NearestStandard = standard.name
+			details.NearestStandardRatio = standard.ratio
+		}
+	}
+
+	landscapeDistance := math.Abs(details.Ratio - landscapeAspectRatio)
+	portraitDistance := math.Abs(details.Ratio - portraitAspectRatio)
+	switch {
+	case landscapeDistance <= aspectRatioTolerance:
+		details.Category = "landscape"
+		details.Margin = aspectRatioTolerance - landscapeDistance
+		details.BorderlineWith = "other"
+	case portraitDistance <= aspectRatioTolerance:
+		details.Category = "portrait"
+		details.Margin = aspectRatioTolerance - portraitDistance
+		details.BorderlineWith = "other"
+	default:
+		details.Margin = landscapeDistance - aspectRatioTolerance
+		details.BorderlineWith = "landscape"
+		if portraitDistance < landscapeDistance {
+			details.Margin = portraitDistance - aspectRatioTolerance
+			details.BorderlineWith = "portrait"
+		}
+	}
+	details.Borderline = details.Margin < aspectRatioBorderlineMargin
+	if !details.Borderline {
+		details.BorderlineWith = ""
+	}
+	return details
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+/*
+Aspect-based storage
+
+Video keys start with the video's aspect ratio ("landscape", "portrait" or
+"other"). To give each kind of content its own lifecycle, an aspect ratio can be
+mapped to a different key prefix (ASPECT_KEY_PREFIXES, e.g. "portrait=shorts"),
+a different bucket of ours (ASPECT_BUCKETS) and an S3 storage class
+(ASPECT_STORAGE_CLASSES, e.g. "portrait=STANDARD_IA").
+
+The bucket override only applies to videos headed for our own bucket; users
+with storage of their own keep it. Other buckets of ours are reached with our
+credentials, but only the main bucket is served through CloudFront.
+*/
+
+var videoAspectRatios = []string{"landscape", "portrait", "other"}
+
+// Where objects of one aspect ratio or asset kind are stored. Empty fields keep
+// the default.
+type storageRule struct {
+	prefix       string
+	bucket       string
+	storageClass types.StorageClass
+}
+
+// Builds the per-aspect rules from the prefix, bucket and storage class maps,
+// each keyed by aspect ratio
+func parseAspectStorage(prefixes, buckets, storageClasses map[string]string) (map[string]storageRule, error) {
+	return parseStorageRules("aspect ratio", videoAspectRatios, prefixes, buckets, storageClasses)
+}
+
+// Builds rules from prefix, bucket and storage class maps keyed by one of
+// names, each a what (an aspect ratio, an asset kind)
+func parseStorageRules(what string, names []string, prefixes, buckets, storageClasses map[string]string) (map[string]storageRule, error) {
+	rules := make(map[string]storageRule)
+	for setting, values := range map[string]map[string]string{"prefix": prefixes, "bucket": buckets, "storage class": storageClasses} {
+		for name, value := range values {
+			if !slices.Contains(names, name) {
+				return nil, fmt.Errorf("%s given for unknown %s %q", setting, what, name)
+			}
+			rule := rules[name]
+			switch setting {
+			case "prefix":
+				if !isValidKeyPrefix(value) || value == draftKeyPrefix {
+					return nil, fmt.Errorf("invalid key prefix %q for %s", value, name)
+				}
+				rule.prefix = value
+			case "bucket":
+				rule.bucket = value
+			case "storage class":
+				class, err := parseStorageClass(value)
+				if err != nil {
+					return nil, fmt.Errorf("%w for %s", err, name)
+				}
+				rule.storageClass = class
+			}
+			rules[name] = rule
+		}
+	}
+	return rules, nil
+}
+
+// Checks value is an S3 storage class; "" is the bucket's default
+func parseStorageClass(value string) (types.StorageClass, error) {
+	class := types.StorageClass(value)
+	if value != "" && !slices.Contains(class.Values(), class) {
+		return "", fmt.Errorf("unknown storage class %q", value)
+	}
+	return class, nil
+}
+
+// Prefixes are a single path segment of letters, digits, dashes and underscores
+func isValidKeyPrefix(prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for _, c := range prefix {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Applies the rule for aspectRatio to where a new video was going to be stored.
+// Returns the target to store it in and the prefix for its key.
+func (cfg *apiConfig) routeByAspect(storage storageTarget, aspectRatio string) (storageTarget, string) {
+	rule, ok := cfg.aspectStorage[aspectRatio]
+	if !ok {
+		return storage, aspectRatio
+	}
+	prefix := aspectRatio
+	if rule.prefix != "" {
+		prefix = rule.prefix
+	}
+	if storage.bucket == cfg.s3Bucket && rule.bucket != "" {
+		storage.bucket = rule.bucket
+	}
+	storage.storageClass = rule.storageClass
+	return storage, prefix
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+/*
+Asset storage routing
+
+Files derived from a video (renditions, scrub preview sprites and their
+thumbnail track, contact sheets, preview loops and chapter files) are stored in
+our bucket under the video's asset prefix. Like videos by aspect ratio, each
+kind of asset can be routed elsewhere to give it its own lifecycle and caching:
+a bucket of ours (ASSET_BUCKETS, e.g. "rendition=tubely-renditions"), a key
+prefix in front of the asset prefix (ASSET_KEY_PREFIXES, e.g. "contact_sheet=sheets")
+and an S3 storage class (ASSET_STORAGE_CLASSES, e.g. "contact_sheet=STANDARD_IA").
+
+Assets are recorded with the bucket they were written to, so their URLs are
+signed for wherever they are and changing the routing only affects assets stored
+afterwards. Only assets in S3_BUCKET are served through CloudFront, and only
+those left under the video's asset prefix are covered by the HLS prefix
+credentials: giving a kind a key prefix or a bucket of its own takes it out of
+their reach. Thumbnails are kept on the server's disk and aren't routed.
+
+The thumbnail track refers to its sprite sheets by file name, so the two must be
+stored side by side; "sprite" and "thumbnail_track" can only be routed together,
+with the same rules.
+*/
+
+// Asset kinds that can be routed
+var routableAssetKinds = append(slices.Clone(derivedAssetKinds), assetKindChapters)
+
+// Builds the per asset kind rules from the prefix, bucket and storage class
+// maps, each keyed by asset kind
+func parseAssetStorage(prefixes, buckets, storageClasses map[string]string) (map[string]storageRule, error) {
+	rules, err := parseStorageRules("asset kind", routableAssetKinds, prefixes, buckets, storageClasses)
+	if err != nil {
+		return nil, err
+	}
+	if rules["sprite"] != rules["thumbnail_track"] {
+		return nil, fmt.Errorf("sprite and thumbnail_track must be routed the same way, the thumbnail track refers to the sprites next to it")
+	}
+	return rules, nil
+}
+
+// Where an asset of kind named name goes, for a video whose assets are under
+// keyPrefix. Returns the target to store it in and its key.
+func (cfg *apiConfig) assetLocation(kind, keyPrefix, name string) (storageTarget, string, error) {
+	storage := cfg.defaultStorage()
+	rule := cfg.assetStorage[kind]
+	if rule.prefix != "" {
+		keyPrefix = rule.prefix + "/" + keyPrefix
+	}
+	if rule.bucket != "" {
+		storage.bucket = rule.bucket
+	}
+	storage.storageClass = rule.storageClass
+	key, err := buildObjectKey(keyPrefix, name)
+	return storage, key, err
+}
+
+// Uploads an asset to where its kind is stored. Returns the "bucket,key"
+// reference to record it with. Like videos, the upload gives up after
+// S3_UPLOAD_TIMEOUT.
+func (cfg *apiConfig) uploadAsset(ctx context.Context, kind, keyPrefix, name string, body io.ReadSeeker, contentType string) (string, error) {
+	storage, key, err := cfg.assetLocation(kind, keyPrefix, name)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.s3UploadTimeout)
+	defer cancel()
+	_, err = cfg.uploadToS3(ctx, storage, key, body, contentType, objectMetadata{})
+	if err != nil {
+		return "", err
+	}
+	return storage.bucket + "," + key, nil
+}
+
+// Opens a local file and uploads it with uploadAsset
+func (cfg *apiConfig) uploadAssetFile(ctx context.Context, kind, keyPrefix, name, filePath, contentType string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return cfg.uploadAsset(ctx, kind, keyPrefix, name, file, contentType)
+}
+
+// Buckets of ours that assets are routed to, besides S3_BUCKET
+func (cfg *apiConfig) isAssetBucket(bucket string) bool {
+	for _, rule := range cfg.assetStorage {
+		if rule.bucket == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// A bucket and key prefix that may hold files derived from a video
+type assetLocation struct {
+	bucket string
+	prefix string
+}
+
+// Everywhere files derived from the video at videoKey can be: its asset prefix
+// in our bucket, and wherever the asset rules send them
+func (cfg *apiConfig) assetLocations(videoKey string) []assetLocation {
+	prefix := assetPrefix(videoKey) + "/"
+	locations := []assetLocation{{cfg.s3Bucket, prefix}}
+	for _, rule := range cfg.assetStorage {
+		location := assetLocation{cfg.s3Bucket, prefix}
+		if rule.bucket != "" {
+			location.bucket = rule.bucket
+		}
+		if rule.prefix != "" {
+			location.prefix = rule.prefix + "/" + prefix
+		}
+		if !slices.Contains(locations, location) {
+			locations = append(locations, location)
+		}
+	}
+	return locations
+}
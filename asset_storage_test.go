@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+// The thumbnail track names its sprites relative to itself, so the two can
+// only be routed together
+func TestParseAssetStorageKeepsSpritesWithTrack(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes map[string]string
+		buckets  map[string]string
+		wantErr  bool
+	}{
+		{"no overrides", nil, nil, false},
+		{"other kind routed", map[string]string{"contact_sheet": "sheets"}, nil, false},
+		{"both prefixed", map[string]string{"sprite": "previews", "thumbnail_track": "previews"}, nil, false},
+		{"only sprites prefixed", map[string]string{"sprite": "previews"}, nil, true},
+		{"different prefixes", map[string]string{"sprite": "previews", "thumbnail_track": "tracks"}, nil, true},
+		{"only track in another bucket", nil, map[string]string{"thumbnail_track": "tubely-previews"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAssetStorage(tt.prefixes, tt.buckets, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +13,17 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// Returns the path on disk of a thumbnail served from the local assets directory,
+// or false if the URL points elsewhere
+func (cfg apiConfig) localAssetPath(assetURL string) (string, bool) {
+	prefix := fmt.Sprintf("http://localhost:%s/assets/", cfg.port)
+	if !strings.HasPrefix(assetURL, prefix) {
+		return "", false
+	}
+	filename := strings.TrimPrefix(assetURL, prefix)
+	if filename == "" || filename != filepath.Base(filename) {
+		return "", false
+	}
+	return filepath.Join(cfg.assetsRoot, filename), true
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+Chapters
+
+A video's chapters are stored as rows in video_chapters and, for players that
+read chapter tracks natively (<track kind="chapters">), as a WebVTT file next to
+the video's other derived files, recorded as its "chapters" asset. Both are
+always written together from the same list: an uploaded VTT file is validated
+and parsed into chapters first, and the stored file is regenerated from them,
+so the two can't drift apart.
+*/
+
+const (
+	assetKindChapters    = "chapters"
+	chaptersFileName     = "chapters.vtt"
+	maxChapters          = 500
+	maxChapterTitleLen   = 200
+	maxChaptersFileBytes = 1 << 20
+)
+
+// A WebVTT timestamp: optional hours, then minutes, seconds and milliseconds
+var vttTimestampPattern = regexp.MustCompile(`^(?:(\d{2,}):)?([0-5]\d):([0-5]\d)\.(\d{3})$`)
+
+func parseVTTTimestamp(s string) (float64, error) {
+	m := vttTimestampPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var hours int64
+	if m[1] != "" {
+		hours, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	minutes, _ := strconv.ParseInt(m[2], 10, 64)
+	seconds, _ := strconv.ParseInt(m[3], 10, 64)
+	millis, _ := strconv.ParseInt(m[4], 10, 64)
+	return float64(((hours*60+minutes)*60+seconds)*1000+millis) / 1000, nil
+}
+
+// Parses a WebVTT chapters file into chapters, one per cue, with the cue text as
+// the title. NOTE, STYLE and REGION blocks are ignored.
+func parseChaptersVTT(data []byte) ([]database.VideoChapter, error) {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	blocks := strings.Split(text, "\n\n")
+	header := blocks[0]
+	if header != "WEBVTT" && !strings.HasPrefix(header, "WEBVTT ") && !strings.HasPrefix(header, "WEBVTT\t") && !strings.HasPrefix(header, "WEBVTT\n") {
+		return nil, errors.New("file must start with a WEBVTT line")
+	}
+
+	chapters := []database.VideoChapter{}
+	for _, block := range blocks[1:] {
+		block = strings.Trim(block, "\n")
+		if block == "" {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		keyword, _, _ := strings.Cut(lines[0], " ")
+		if keyword == "NOTE" || keyword == "STYLE" || keyword == "REGION" {
+			continue
+		}
+
+		// An optional cue identifier comes before the timings
+		if !strings.Contains(lines[0], "-->") {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 || !strings.Contains(lines[0], "-->") {
+			return nil, fmt.Errorf("cue %d has no timings", len(chapters)+1)
+		}
+
+		startString, rest, _ := strings.Cut(lines[0], "-->")
+		// Cue settings may follow the end timestamp
+		endFields := strings.Fields(rest)
+		if len(endFields) == 0 {
+			return nil, fmt.Errorf("cue %d has no end time", len(chapters)+1)
+		}
+		start, err := parseVTTTimestamp(strings.TrimSpace(startString))
+		if err != nil {
+			return nil, fmt.Errorf("cue %d: %w", len(chapters)+1, err)
+		}
+		end, err := parseVTTTimestamp(endFields[0])
+		if err != nil {
+			return nil, fmt.Errorf("cue %d: %w", len(chapters)+1, err)
+		}
+
+		for _, line := range lines[1:] {
+			if strings.Contains(line, "-->") {
+				return nil, fmt.Errorf("cue %d has more than one timing line", len(chapters)+1)
+			}
+		}
+		chapters = append(chapters, database.VideoChapter{
+			Start: start,
+			End:   end,
+			Title: strings.Join(lines[1:], " "),
+		})
+	}
+
+	return chapters, validateChapters(chapters)
+}
+
+// Checks chapters are titled, in order and don't overlap
+func validateChapters(chapters []database.VideoChapter) error {
+	if len(chapters) == 0 {
+		return errors.New("at least one chapter is required")
+	}
+	if len(chapters) > maxChapters {
+		return fmt.Errorf("at most %d chapters are allowed", maxChapters)
+	}
+	previousEnd := 0.0
+	for i, chapter := range chapters {
+		title := strings.TrimSpace(chapter.Title)
+		if title == "" {
+			return fmt.Errorf("chapter %d has no title", i+1)
+		}
+		if len(title) > maxChapterTitleLen {
+			return fmt.Errorf("chapter %d's title is longer than %d characters", i+1, maxChapterTitleLen)
+		}
+		if strings.Contains(title, "-->") || strings.ContainsAny(title, "\r\n") {
+			return fmt.Errorf("chapter %d's title can't contain line breaks or \"-->\"", i+1)
+		}
+		if chapter.Start < 0 || chapter.End <= chapter.Start {
+			return fmt.Errorf("chapter %d must end after it starts", i+1)
+		}
+		if chapter.Start < previousEnd {
+			return fmt.Errorf("chapter %d starts before chapter %d ends", i+1, i)
+		}
+		previousEnd = chapter.End
+	}
+	return nil
+}
+
+// Writes chapters as a WebVTT chapters file, one numbered cue each
+func chaptersVTT(chapters []database.VideoChapter) []byte {
+	var vtt bytes.Buffer
+	vtt.WriteString("WEBVTT\n")
+	for i, chapter := range chapters {
+		fmt.Fprintf(&vtt, "\n%d\n%s --> %s\n%s\n",
+			i+1,
+			formatVTTTimestamp(secondsToDuration(chapter.Start)),
+			formatVTTTimestamp(secondsToDuration(chapter.End)),
+			strings.TrimSpace(chapter.Title),
+		)
+	}
+	return vtt.Bytes()
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds*1000+0.5) * time.Millisecond
+}
+
+// Replaces a video's chapters, uploading the matching VTT file next to its other
+// derived files. Returns the stored "bucket,key" of the file.
+func (cfg *apiConfig) saveChapters(ctx context.Context, video database.Video, chapters []database.VideoChapter) (string, error) {
+	for i := range chapters {
+		chapters[i].Title = strings.TrimSpace(chapters[i].Title)
+	}
+
+	_, key, err := parseStoredURL(*video.VideoURL)
+	if err != nil {
+		return "", err
+	}
+	stored, err := cfg.uploadAsset(ctx, assetKindChapters, assetPrefix(key), chaptersFileName, bytes.NewReader(chaptersVTT(chapters)), "text/vtt")
+	if err != nil {
+		return "", fmt.Errorf("failed to upload chapters: %w", err)
+	}
+
+	err = cfg.db.ReplaceVideoChapters(video.ID, chapters)
+	if err != nil {
+		return "", fmt.Errorf("failed to save chapters: %w", err)
+	}
+	err = cfg.db.UpsertVideoAsset(database.VideoAsset{
+		VideoID: video.ID,
+		Kind:    assetKindChapters,
+		Name:    chaptersFileName,
+		URL:     stored,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save chapters: %w", err)
+	}
+	return stored, nil
+}
+
+// Removes a video's chapters and their VTT file
+func (cfg *apiConfig) deleteChapters(ctx context.Context, videoID uuid.UUID) error {
+	err := cfg.db.ReplaceVideoChapters(videoID, nil)
+	if err != nil {
+		return err
+	}
+
+	assets, err := cfg.db.GetVideoAssets(videoID)
+	if err != nil {
+		return err
+	}
+	for _, asset := range assets {
+		if asset.Kind != assetKindChapters {
+			continue
+		}
+		bucket, key, err := parseStoredURL(asset.URL)
+		if err != nil {
+			return err
+		}
+		_, err = cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		err = cfg.db.DeleteVideoAsset(videoID, asset.Kind, asset.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+CloudFront signed URLs
+
+S3 presigned URLs can't carry conditions beyond an expiry, so anyone holding
+one can use it from anywhere. CloudFront signed URLs with a custom policy can
+also restrict the viewer's IP address, which lets us bind a URL to the client
+that asked for it.
+
+The policy is a JSON document signed with the private key of a CloudFront key
+pair. Policy and signature travel in the query string using CloudFront's URL-safe
+base64 variant (+ becomes -, = becomes _, / becomes ~).
+
+A policy's resource may end in a wildcard, which makes one signature good for
+every object under a prefix (e.g. all the segments of an HLS stream). Such
+credentials are handed to the client as query parameters to append to each URL
+or as signed cookies; see handler_video_hls_auth.go.
+
+Referer restrictions can't be expressed in a signed URL policy; use an AWS WAF
+rule on the distribution for those.
+*/
+
+type cloudFrontSigner struct {
+	keyPairID  string
+	privateKey *rsa.PrivateKey
+}
+
+// Loads an RSA private key in PEM format (PKCS#1 or PKCS#8)
+func newCloudFrontSigner(keyPairID, privateKeyPath string) (*cloudFrontSigner, error) {
+	dat, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("no PEM data found in private key file")
+	}
+
+	var privateKey *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var key interface{}
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			privateKey, ok = key.(*rsa.PrivateKey)
+			if !ok {
+				err = errors.New("CloudFront private key must be RSA")
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return &cloudFrontSigner{
+		keyPairID:  keyPairID,
+		privateKey: privateKey,
+	}, nil
+}
+
+type cloudFrontPolicy struct {
+	Statement []cloudFrontStatement `json:"Statement"`
+}
+
+type cloudFrontStatement struct {
+	Resource  string              `json:"Resource"`
+	Condition cloudFrontCondition `json:"Condition"`
+}
+
+type cloudFrontCondition struct {
+	DateLessThan struct {
+		EpochTime int64 `json:"AWS:EpochTime"`
+	} `json:"DateLessThan"`
+	IPAddress *struct {
+		SourceIP string `json:"AWS:SourceIp"`
+	} `json:"IpAddress,omitempty"`
+}
+
+// Signs resourceURL with a custom policy that expires after expireTime and,
+// if sourceIP is set, only works from that IP address
+func (s *cloudFrontSigner) signURL(resourceURL string, expireTime time.Duration, sourceIP string) (string, error) {
+	query, err := s.signPolicy(resourceURL, expireTime, sourceIP)
+	if err != nil {
+		return "", err
+	}
+
+	separator := "?"
+	if strings.Contains(resourceURL, "?") {
+		separator = "&"
+	}
+	return resourceURL + separator + query.Encode(), nil
+}
+
+// Signs a custom policy for resource, which may end in "*" to cover every URL
+// starting with the rest of it. Returns the Policy, Signature and Key-Pair-Id
+// parameters, which are also the values of the CloudFront-* signed cookies.
+func (s *cloudFrontSigner) signPolicy(resource string, expireTime time.Duration, sourceIP string) (url.Values, error) {
+	statement := cloudFrontStatement{Resource: resource}
+	statement.Condition.DateLessThan.EpochTime = time.Now().Add(expireTime).Unix()
+	if sourceIP != "" {
+		cidr, err := ipToCIDR(sourceIP)
+		if err != nil {
+			return nil, err
+		}
+		statement.Condition.IPAddress = &struct {
+			SourceIP string `json:"AWS:SourceIp"`
+		}{cidr}
+	}
+
+	policy, err := json.Marshal(cloudFrontPolicy{Statement: []cloudFrontStatement{statement}})
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := sha1.Sum(policy)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA1, hashed[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CloudFront policy: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("Policy", cloudFrontBase64(policy))
+	query.Set("Signature", cloudFrontBase64(signature))
+	query.Set("Key-Pair-Id", s.keyPairID)
+	return query, nil
+}
+
+func cloudFrontBase64(dat []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(dat))
+}
+
+// Turns a single IP address into the CIDR form CloudFront policies expect
+func ipToCIDR(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address: %s", ip)
+	}
+	if parsed.To4() != nil {
+		return parsed.String() + "/32", nil
+	}
+	return parsed.String() + "/128", nil
+}
+
+// The address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
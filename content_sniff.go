@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// How much of a file is read to tell what it really is, as much as
+// http.DetectContentType looks at
+const sniffLength = 512
+
+// Top-level boxes an MP4 or QuickTime file can start with. QuickTime files
+// from older cameras often have no ftyp box at all.
+var quickTimeLeadingBoxes = [][]byte{[]byte("moov"), []byte("mdat"), []byte("wide"), []byte("free"), []byte("skip")}
+
+// Works out the media type of a file from its first bytes. Unlike
+// http.DetectContentType, MP4 files of any brand are recognized, and QuickTime
+// ones are told apart by theirs.
+func sniffMediaType(head []byte) string {
+	if len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")) {
+		if bytes.Equal(head[8:12], []byte("qt  ")) {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	}
+	if len(head) >= 8 {
+		for _, box := range quickTimeLeadingBoxes {
+			if bytes.Equal(head[4:8], box) {
+				return "video/quicktime"
+			}
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return mediaType
+}
+
+// Media types whose files look alike on the first bytes: MP4 and QuickTime
+// share a format, and which brand a file carries says little about which of
+// the two it was labelled as. ffprobe has the final word on those.
+var sniffFamilies = map[string]string{
+	"video/mp4":       "video/mp4",
+	"video/quicktime": "video/mp4",
+}
+
+// Checks the first bytes of an upload match the media type the client declared
+// for it (after aliases), so a file can't get in under another type's label
+func (cfg *apiConfig) checkContentSignature(contentType string, head []byte) error {
+	declared, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return &uploadError{http.StatusBadRequest, "Invalid content type", err}
+	}
+	if normalized, ok := cfg.videoContentTypeAliases[declared]; ok {
+		declared = normalized
+	}
+
+	sniffed := sniffMediaType(head)
+	family := func(mediaType string) string {
+		if f, ok := sniffFamilies[mediaType]; ok {
+			return f
+		}
+		return mediaType
+	}
+	if family(sniffed) != family(declared) {
+		msg := fmt.Sprintf("File contents don't match its content type %s", declared)
+		return &uploadError{http.StatusBadRequest, msg, fmt.Errorf("file looks like %s", sniffed)}
+	}
+	return nil
+}
+
+// Reads the first bytes of r for sniffing. Returns fewer for a shorter file.
+func readSniffHead(r io.Reader) ([]byte, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, head)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return head[:n], err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Turns away an upload of size bytes (-1 if unknown) when the temp volume
+// wouldn't have UPLOAD_MIN_FREE_DISK left once it's saved, so the upload fails
+// now instead of partway through and takes in-flight uploads with it. Platforms
+// where free space can't be read don't check.
+func (cfg *apiConfig) checkUploadDiskSpace(size int64) error {
+	dir := cfg.uploadTempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	free, err := freeDiskSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		// Not knowing isn't a reason to refuse; the copy fails cleanly if it's full
+		log.Printf("Couldn't check free space in %s: %v", dir, err)
+		return nil
+	}
+
+	required := uint64(max(size, 0)) + uint64(cfg.uploadMinFreeDisk)
+	if free < required {
+		return &uploadError{
+			http.StatusInsufficientStorage,
+			"Not enough disk space to accept this upload right now, try again later",
+			fmt.Errorf("%d bytes free in %s, %d needed", free, dir, required),
+		}
+	}
+	return nil
+}
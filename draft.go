@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+/*
+Drafts
+
+A video can be uploaded as a draft (draft=true on any upload) while its owner is
+still working on it. Draft files are kept in our bucket under draftKeyPrefix,
+optionally in a cheaper DRAFT_STORAGE_CLASS, instead of where the video would
+normally go. POST /videos/{videoID}/publish moves the file out of the draft
+prefix and makes it a regular video; who can see it is still up to its
+visibility. Drafts that aren't published within DRAFT_TTL are deleted along with
+everything stored for them.
+
+Uploading a non-draft file replaces a draft like any other file. Published
+drafts are routed by aspect ratio like any other upload (see aspect_storage.go)
+but stay in our buckets, even for users with storage of their own.
+*/
+
+const draftKeyPrefix = "drafts"
+
+// Where draft files are stored: our bucket, in the draft storage class
+func (cfg *apiConfig) draftStorage() storageTarget {
+	storage := cfg.defaultStorage()
+	storage.storageClass = cfg.draftStorageClass
+	return storage
+}
+
+// Moves a draft's file out of the draft prefix, to where a regular upload of it
+// would have gone, along with its recorded assets, and clears its expiry
+func (cfg *apiConfig) publishDraft(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		return database.Video{}, fmt.Errorf("draft %s has no file", video.ID)
+	}
+	bucket, key, err := parseStoredURL(*video.VideoURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	publishedKey, ok := strings.CutPrefix(key, draftKeyPrefix+"/")
+	if !ok || bucket != cfg.s3Bucket {
+		return database.Video{}, fmt.Errorf("%s isn't a draft file", key)
+	}
+
+	storage := cfg.publishedDraftStorage(publishedKey)
+	err = cfg.copyS3ObjectTo(ctx, bucket, key, storage, publishedKey)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	for _, asset := range assets {
+		assetBucket, assetKey, err := parseStoredURL(asset.URL)
+		if err != nil {
+			return database.Video{}, err
+		}
+		assetStorage, newAssetKey, err := cfg.assetLocation(asset.Kind, assetPrefix(publishedKey), path.Base(assetKey))
+		if err != nil {
+			return database.Video{}, err
+		}
+		err = cfg.copyS3ObjectTo(ctx, assetBucket, assetKey, assetStorage, newAssetKey)
+		if err != nil {
+			return database.Video{}, err
+		}
+		asset.URL = fmt.Sprintf("%s,%s", assetStorage.bucket, newAssetKey)
+		err = cfg.db.UpsertVideoAsset(asset)
+		if err != nil {
+			return database.Video{}, err
+		}
+	}
+
+	published := video
+	videoURL := fmt.Sprintf("%s,%s", storage.bucket, publishedKey)
+	published.VideoURL = &videoURL
+	published.DraftExpiresAt = nil
+	published.UpdatedAt = time.Now()
+	err = cfg.db.UpdateVideo(published)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("failed to update video: %w", err)
+	}
+
+	// The published copies are in place; the draft file and everything derived
+	// from it, recorded or not, are only orphans
+	objects := map[s3Object]bool{{bucket, key}: true}
+	err = cfg.listAssetObjects(ctx, key, objects)
+	if err == nil {
+		_, err = cfg.deleteObjects(ctx, objects)
+	}
+	if err != nil {
+		log.Printf("Couldn't delete all draft files of video %s: %v", video.ID, err)
+	}
+	return published, nil
+}
+
+// Where a draft's file goes once published: our bucket, routed by the aspect
+// ratio whose key prefix publishedKey starts with
+func (cfg *apiConfig) publishedDraftStorage(publishedKey string) storageTarget {
+	prefix, _, _ := strings.Cut(publishedKey, "/")
+	for _, aspectRatio := range videoAspectRatios {
+		storage, keyPrefix := cfg.routeByAspect(cfg.defaultStorage(), aspectRatio)
+		if keyPrefix == prefix {
+			return storage
+		}
+	}
+	return cfg.defaultStorage()
+}
+
+// Periodically deletes drafts that weren't published in time, along with their
+// files
+func (cfg *apiConfig) startDraftJanitor(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			drafts, err := cfg.db.GetExpiredDrafts(time.Now())
+			if err != nil {
+				log.Printf("Couldn't list expired drafts: %v", err)
+				continue
+			}
+			for _, video := range drafts {
+				assets, err := cfg.db.GetVideoAssets(video.ID)
+				if err != nil {
+					log.Printf("Couldn't get assets of expired draft %s: %v", video.ID, err)
+					continue
+				}
+				// Files first: a draft whose files couldn't all be deleted is kept,
+				// and the next run tries again
+				deleted, err := cfg.deleteVideoFiles(context.Background(), video, assets)
+				if err != nil {
+					log.Printf("Couldn't remove all files of expired draft %s: %v", video.ID, err)
+					continue
+				}
+				err = cfg.db.DeleteVideo(video.ID)
+				if err != nil {
+					log.Printf("Couldn't delete expired draft %s: %v", video.ID, err)
+					continue
+				}
+				cfg.recordAudit(auditDraftExpired, video.UserID, video.ID, "", map[string]any{
+					"title":            video.Title,
+					"video_url":        video.VideoURL,
+					"draft_expires_at": video.DraftExpiresAt,
+				})
+				log.Printf("Deleted expired draft %s and %d stored objects", video.ID, deleted)
+			}
+		}
+	}()
+}
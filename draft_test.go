@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Published drafts land where a regular upload of the same aspect ratio would
+func TestPublishedDraftStorage(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	rules, err := parseAspectStorage(
+		map[string]string{"portrait": "shorts"},
+		map[string]string{"portrait": "tubely-shorts"},
+		map[string]string{"portrait": "STANDARD_IA"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.aspectStorage = rules
+
+	tests := []struct {
+		key          string
+		bucket       string
+		storageClass types.StorageClass
+	}{
+		{"shorts/abc.mp4", "tubely-shorts", types.StorageClassStandardIa},
+		{"landscape/abc.mp4", testBucket, ""},
+		// Prefixes no longer configured stay in our bucket
+		{"portrait/abc.mp4", testBucket, ""},
+	}
+	for _, tt := range tests {
+		storage := cfg.publishedDraftStorage(tt.key)
+		if storage.bucket != tt.bucket || storage.storageClass != tt.storageClass {
+			t.Errorf("%s: got %s in %q, want %s in %q", tt.key, storage.bucket, storage.storageClass, tt.bucket, tt.storageClass)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+/*
+Frames from stored videos
+
+Grabbing a single frame from a stored video shouldn't mean downloading all of
+it. Stored videos are fast start, so the moov atom (the index of where every
+frame lives in the file) is at the front. We fetch the head of the object plus
+a window around where the requested time should be, and write both into a
+sparse file the size of the whole object at their real offsets. ffmpeg reads
+the index from the head, seeks to the keyframe before the requested time and
+decodes from the window; the holes in between are never read.
+
+The window is placed assuming a constant bitrate, which is only an estimate. If
+the keyframe falls outside it, ffmpeg decodes zeroes from a hole, -xerror turns
+the decode errors into a failure, and we fall back to downloading the object in
+full.
+*/
+
+const (
+	// Enough to hold the moov atom of all but very long videos
+	frameFetchHeadSize = 4 << 20
+	// Fetched around the estimated position of the requested time; a quarter of
+	// it before, to catch the keyframe the frame depends on
+	frameFetchWindowSize = 8 << 20
+)
+
+var errFrameOutOfRange = errors.New("frame out of range")
+
+// A point in a video: a number of seconds in, or a fraction of the way through
+// it for clients that don't know how long it is
+type framePosition struct {
+	seconds  float64
+	fraction float64
+	relative bool
+}
+
+// Parses a position given as seconds ("12.5") or a percentage from 0 to 100
+// ("25%")
+func parseFramePosition(value string) (framePosition, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "%") {
+		fraction, err := parsePercentage(value)
+		if err != nil {
+			return framePosition{}, err
+		}
+		return framePosition{fraction: fraction, relative: true}, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) {
+		return framePosition{}, fmt.Errorf("invalid position %q, must be a number of seconds or a percentage", value)
+	}
+	return framePosition{seconds: seconds}, nil
+}
+
+// Where the position falls in a video of the given length, in seconds. A
+// percentage always lands on a frame, so 100% means the last frame.
+func (p framePosition) resolve(duration float64) float64 {
+	if !p.relative {
+		return p.seconds
+	}
+	return min(p.fraction*duration, max(duration-lastFrameMargin, 0))
+}
+
+// How far before the end the last frame is looked for, since the end itself is
+// past every frame
+const lastFrameMargin = 0.1
+
+// Grabs the frame at the given time as a JPEG at outputPath
+func extractFrame(videoPath string, atSeconds float64, outputPath string) error {
+	cmd := exec.Command("ffmpeg",
+		"-xerror",
+		"-ss", strconv.FormatFloat(atSeconds, 'f', 3, 64),
+		"-i", videoPath,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-y", outputPath,
+	)
+	err := runCommand(cmd)
+	if err != nil {
+		return fmt.Errorf("ffmpeg frame extraction at %.1fs failed: %w", atSeconds, err)
+	}
+	// ffmpeg exits cleanly without writing anything when the time is past the end
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() == 0 {
+		return fmt.Errorf("no frame at %.1fs", atSeconds)
+	}
+	return nil
+}
+
+// Grabs the frame at a position in a video's stored file using ranged GETs,
+// downloading the whole object only if that fails. Returns the path of the JPEG,
+// which the caller owns (and removes), and the video's duration.
+func (cfg *apiConfig) extractFrameFromS3(ctx context.Context, video database.Video, position framePosition) (string, float64, error) {
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		return "", 0, err
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		return "", 0, err
+	}
+	client := storage.client
+
+	partial, err := os.CreateTemp(cfg.uploadTempDir, "tubely-partial-*.mp4")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(partial.Name())
+	defer partial.Close()
+
+	size, err := fetchS3Range(ctx, client, partial, bucket, key, versionID, 0, frameFetchHeadSize)
+	if err != nil {
+		return "", 0, err
+	}
+	complete := size <= frameFetchHeadSize
+	if !complete {
+		// Reads past the fetched ranges see zeroes instead of hitting EOF
+		err = partial.Truncate(size)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	duration, err := getVideoDuration(partial.Name())
+	if err != nil && !complete {
+		// The index isn't in the head (not a fast start file), so nothing short of
+		// the whole object will do
+		_, err = fetchS3Range(ctx, client, partial, bucket, key, versionID, 0, 0)
+		if err != nil {
+			return "", 0, err
+		}
+		complete = true
+		duration, err = getVideoDuration(partial.Name())
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	atSeconds := position.resolve(duration)
+	if atSeconds >= duration {
+		return "", 0, fmt.Errorf("%w: %.1fs is past the end of the %.1fs video", errFrameOutOfRange, atSeconds, duration)
+	}
+
+	framePath := strings.TrimSuffix(partial.Name(), ".mp4") + ".jpg"
+	if !complete {
+		offset := int64(atSeconds/duration*float64(size)) - frameFetchWindowSize/4
+		offset = max(offset, frameFetchHeadSize)
+		if offset < size {
+			_, err = fetchS3Range(ctx, client, partial, bucket, key, versionID, offset, frameFetchWindowSize)
+			if err != nil {
+				return "", 0, err
+			}
+		}
+		err = extractFrame(partial.Name(), atSeconds, framePath)
+		if err == nil {
+			return framePath, duration, nil
+		}
+		os.Remove(framePath)
+
+		_, err = fetchS3Range(ctx, client, partial, bucket, key, versionID, 0, 0)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	err = extractFrame(partial.Name(), atSeconds, framePath)
+	if err != nil {
+		os.Remove(framePath)
+		return "", 0, err
+	}
+	return framePath, duration, nil
+}
+
+// Writes length bytes of an S3 object from offset into f at the same offset, or
+// the whole object if length is 0. Returns the object's total size.
+func fetchS3Range(ctx context.Context, client *s3.Client, f *os.File, bucket, key, versionID string, offset, length int64) (int64, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	if length > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+
+	output, err := client.GetObject(ctx, input)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	defer output.Body.Close()
+
+	size := aws.ToInt64(output.ContentLength)
+	if length > 0 {
+		// Content-Range is "bytes start-end/total"
+		contentRange := aws.ToString(output.ContentRange)
+		_, total, ok := strings.Cut(contentRange, "/")
+		size, err = strconv.ParseInt(total, 10, 64)
+		if !ok || err != nil {
+			return 0, fmt.Errorf("unexpected Content-Range %q for %s", contentRange, key)
+		}
+	}
+
+	_, err = io.Copy(io.NewOffsetWriter(f, offset), output.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return size, nil
+}
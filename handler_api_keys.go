@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxAPIKeyNameLength = 100
+
+// Creates an API key for the caller. The key is only ever returned here.
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	userID, ok := cfg.authorizeAPIKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, "name is too long", nil)
+		return
+	}
+
+	random, err := generateRandomName()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate API key", err)
+		return
+	}
+	key := apiKeyPrefix + random
+
+	apiKey, err := cfg.db.CreateAPIKey(userID, params.Name, hashAPIKey(key))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKey,
+		Key:    key,
+	})
+}
+
+// Lists the caller's active API keys
+func (cfg *apiConfig) handlerAPIKeysList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorizeAPIKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := cfg.db.GetActiveAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API keys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authorizeAPIKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeAPIKey(userID, keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Authenticates a request to manage API keys, which needs a user's JWT: a key
+// that could mint more keys would survive being revoked. Responds and returns
+// false otherwise.
+func (cfg *apiConfig) authorizeAPIKeyOwner(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	if r.Header.Get(apiKeyHeader) != "" {
+		respondWithError(w, http.StatusForbidden, "API keys can't manage API keys", nil)
+		return uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
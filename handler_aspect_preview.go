@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// Shows how a video would be categorized without storing anything, so the
+// frontend can warn about a borderline video before it's uploaded for real.
+// Takes either a JSON body with the video's width and height, or the video
+// itself as a multipart upload like POST /video_upload/{videoID}.
+func (cfg *apiConfig) handlerAspectPreview(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	_, err = auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		params := parameters{}
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+		if params.Width <= 0 || params.Height <= 0 {
+			respondWithError(w, http.StatusBadRequest, "width and height must be positive", nil)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, analyzeAspectRatio(params.Width, params.Height))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoUploadBytes)
+	form, err := cfg.readVideoUploadForm(r)
+	if form.path != "" {
+		defer os.Remove(form.path)
+	}
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	width, height, err := getVideoDimensions(form.path)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read video file", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, analyzeAspectRatio(width, height))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// Lists audit records, newest first, optionally filtered by ?user_id=,
+// ?video_id= and ?action=. ?before= takes the ID of the last record of the
+// previous page.
+func (cfg *apiConfig) handlerAuditList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := database.AuditFilter{
+		Action: query.Get("action"),
+		Limit:  defaultAuditPageSize,
+	}
+	var err error
+	if userID := query.Get("user_id"); userID != "" {
+		filter.UserID, err = uuid.Parse(userID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user_id", err)
+			return
+		}
+	}
+	if videoID := query.Get("video_id"); videoID != "" {
+		filter.VideoID, err = uuid.Parse(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid video_id", err)
+			return
+		}
+	}
+	if before := query.Get("before"); before != "" {
+		filter.BeforeID, err = strconv.ParseInt(before, 10, 64)
+		if err != nil || filter.BeforeID <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid before", err)
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > maxAuditPageSize {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 1000", err)
+			return
+		}
+	}
+
+	records, err := cfg.db.GetAuditRecords(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audit records", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, records)
+}
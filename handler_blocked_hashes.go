@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func (cfg *apiConfig) handlerBlockedHashesList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	hashes, err := cfg.db.GetBlockedHashes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get blocked hashes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, hashes)
+}
+
+func (cfg *apiConfig) handlerBlockedHashesCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Hash   string `json:"hash"`
+		Reason string `json:"reason"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	hash := strings.ToLower(params.Hash)
+	if !isSHA256Hex(hash) {
+		respondWithError(w, http.StatusBadRequest, "Hash must be a hex-encoded SHA-256", nil)
+		return
+	}
+
+	blocked, err := cfg.db.CreateBlockedHash(hash, params.Reason)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't block hash", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, blocked)
+}
+
+func (cfg *apiConfig) handlerBlockedHashesDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	hash := strings.ToLower(r.PathValue("hash"))
+	if !isSHA256Hex(hash) {
+		respondWithError(w, http.StatusBadRequest, "Hash must be a hex-encoded SHA-256", nil)
+		return
+	}
+
+	err := cfg.db.DeleteBlockedHash(hash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unblock hash", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Returns the hex-encoded SHA-256 of a file's contents
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// Generates (or regenerates) a contact sheet for a video and returns its URL
+func (cfg *apiConfig) handlerContactSheet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL     string `json:"url"`
+		Columns int    `json:"columns"`
+		Rows    int    `json:"rows"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	stored, err := cfg.createContactSheet(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate contact sheet", err)
+		return
+	}
+
+	sheetURL, err := cfg.signStoredURL(r.Context(), stored, signOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		URL:     sheetURL,
+		Columns: cfg.contactSheetColumns,
+		Rows:    cfg.contactSheetRows,
+	})
+}
@@ -0,0 +1,13 @@
+package main
+
+import "net/http"
+
+// Lists the background jobs that failed for good (out of retries, or failed
+// with an error retrying won't fix), newest first. The list lives in memory and
+// starts empty after a restart.
+func (cfg *apiConfig) handlerFailedJobsList(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.backgroundJobs.failedJobs())
+}
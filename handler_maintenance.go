@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (cfg *apiConfig) handlerMaintenanceGet(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.maintenanceMode.get())
+}
+
+// Turns maintenance mode on or off, optionally with the message clients get
+func (cfg *apiConfig) handlerMaintenanceSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Enabled *bool  `json:"enabled"`
+		Message string `json:"message"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Enabled == nil {
+		respondWithError(w, http.StatusBadRequest, "enabled is required", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.maintenanceMode.set(*params.Enabled, params.Message))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// Generates (or regenerates) a video's preview loop in ?format=mp4 (the default)
+// or webm and returns its URL
+func (cfg *apiConfig) handlerPreviewLoop(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL         string `json:"url"`
+		ContentType string `json:"content_type"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "mp4"
+	}
+	format, ok := previewLoopFormats[formatName]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "format must be mp4 or webm", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	stored, err := cfg.createPreviewLoop(r.Context(), video, format)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate preview loop", err)
+		return
+	}
+
+	loopURL, err := cfg.signStoredURL(r.Context(), stored, signOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		URL:         loopURL,
+		ContentType: format.contentType,
+	})
+}
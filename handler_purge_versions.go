@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type purgeVersionsReport struct {
+	DryRun          bool     `json:"dry_run"`
+	VersionsScanned int      `json:"versions_scanned"`
+	StaleCount      int      `json:"stale_count"`
+	StaleVersions   []string `json:"stale_versions"`
+	DeletedVersions int      `json:"deleted_versions"`
+}
+
+// Removes old versions of the objects the database references, for buckets with
+// versioning enabled. A version is kept if it's the current one or the one a
+// record pins; everything else (including noncurrent delete markers) is stale.
+// Unreferenced objects are left to /admin/reconcile. Like reconcile, this only
+// reports unless called with ?dry_run=false.
+func (cfg *apiConfig) handlerPurgeVersions(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") != "false"
+
+	report, err := cfg.purgeStaleVersions(r.Context(), dryRun)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't purge old versions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func (cfg *apiConfig) purgeStaleVersions(ctx context.Context, dryRun bool) (purgeVersionsReport, error) {
+	report := purgeVersionsReport{
+		DryRun:        dryRun,
+		StaleVersions: []string{},
+	}
+
+	refs, err := cfg.db.GetStorageReferences()
+	if err != nil {
+		return report, err
+	}
+
+	// Key -> pinned version IDs ("" when the reference follows the latest version)
+	pinned := make(map[string]map[string]bool)
+	for _, ref := range refs {
+		bucket, key, versionID, err := parseStoredObject(ref.URL)
+		if err != nil || bucket != cfg.s3Bucket {
+			continue
+		}
+		if pinned[key] == nil {
+			pinned[key] = make(map[string]bool)
+		}
+		pinned[key][versionID] = true
+	}
+
+	var stale []types.ObjectIdentifier
+	addStale := func(key, versionID *string, isLatest *bool) {
+		report.VersionsScanned++
+		keep, ok := pinned[aws.ToString(key)]
+		if !ok || aws.ToBool(isLatest) || keep[aws.ToString(versionID)] {
+			return
+		}
+		report.StaleCount++
+		if len(report.StaleVersions) < maxReconcileReportItems {
+			report.StaleVersions = append(report.StaleVersions, fmt.Sprintf("%s?versionId=%s", aws.ToString(key), aws.ToString(versionID)))
+		}
+		stale = append(stale, types.ObjectIdentifier{Key: key, VersionId: versionID})
+	}
+
+	paginator := s3.NewListObjectVersionsPaginator(cfg.s3Client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(cfg.s3Bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return report, fmt.Errorf("failed to list object versions: %w", err)
+		}
+		for _, version := range page.Versions {
+			addStale(version.Key, version.VersionId, version.IsLatest)
+		}
+		for _, marker := range page.DeleteMarkers {
+			addStale(marker.Key, marker.VersionId, marker.IsLatest)
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	report.DeletedVersions, err = cfg.deleteS3Objects(ctx, stale)
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Report entries beyond this are counted but not listed
+const maxReconcileReportItems = 1000
+
+type reconcileReport struct {
+	DryRun          bool                        `json:"dry_run"`
+	ObjectsScanned  int                         `json:"objects_scanned"`
+	OrphanedCount   int                         `json:"orphaned_count"`
+	OrphanedObjects []string                    `json:"orphaned_objects"`
+	DanglingCount   int                         `json:"dangling_count"`
+	DanglingRecords []database.StorageReference `json:"dangling_records"`
+	DeletedObjects  int                         `json:"deleted_objects"`
+	ClearedRecords  int                         `json:"cleared_records"`
+}
+
+// Cross-references the bucket with the database. Reports objects no record points
+// at and records pointing at missing objects; with ?dry_run=false it also deletes
+// the orphaned objects and clears the dangling references. Objects newer than
+// ?grace_period (default 1h) are never treated as orphans since an upload may
+// still be about to record them.
+func (cfg *apiConfig) handlerReconcile(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") != "false"
+
+	gracePeriod := time.Hour
+	if value := r.URL.Query().Get("grace_period"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid grace_period", err)
+			return
+		}
+		gracePeriod = parsed
+	}
+
+	report, err := cfg.reconcileStorage(r.Context(), dryRun, gracePeriod)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Reconciliation failed", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+func (cfg *apiConfig) reconcileStorage(ctx context.Context, dryRun bool, gracePeriod time.Duration) (reconcileReport, error) {
+	report := reconcileReport{
+		DryRun:          dryRun,
+		OrphanedObjects: []string{},
+		DanglingRecords: []database.StorageReference{},
+	}
+
+	refs, err := cfg.db.GetStorageReferences()
+	if err != nil {
+		return report, err
+	}
+
+	// Only the referenced keys are held in memory; the bucket is streamed page by page
+	referenced := make(map[string][]database.StorageReference)
+	for _, ref := range refs {
+		bucket, key, err := parseStoredURL(ref.URL)
+		if err != nil || bucket != cfg.s3Bucket {
+			continue
+		}
+		referenced[key] = append(referenced[key], ref)
+	}
+	found := make(map[string]bool, len(referenced))
+
+	var orphans []types.ObjectIdentifier
+	paginator := s3.NewListObjectsV2Paginator(cfg.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(cfg.s3Bucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return report, fmt.Errorf("failed to list bucket: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			report.ObjectsScanned++
+
+			if _, ok := referenced[key]; ok {
+				found[key] = true
+				continue
+			}
+			if object.LastModified != nil && time.Since(*object.LastModified) < gracePeriod {
+				continue
+			}
+
+			report.OrphanedCount++
+			if len(report.OrphanedObjects) < maxReconcileReportItems {
+				report.OrphanedObjects = append(report.OrphanedObjects, key)
+			}
+			orphans = append(orphans, types.ObjectIdentifier{Key: object.Key})
+		}
+	}
+
+	var dangling []database.StorageReference
+	for key, keyRefs := range referenced {
+		if found[key] {
+			continue
+		}
+		dangling = append(dangling, keyRefs...)
+	}
+	report.DanglingCount = len(dangling)
+	if len(dangling) > maxReconcileReportItems {
+		report.DanglingRecords = dangling[:maxReconcileReportItems]
+	} else if len(dangling) > 0 {
+		report.DanglingRecords = dangling
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	report.DeletedObjects, err = cfg.deleteS3Objects(ctx, orphans)
+	if err != nil {
+		return report, err
+	}
+
+	for _, ref := range dangling {
+		err = cfg.clearStorageReference(ref)
+		if err != nil {
+			return report, err
+		}
+		report.ClearedRecords++
+	}
+
+	return report, nil
+}
+
+// Deletes objects from the configured bucket in batches of up to 1000 (the
+// DeleteObjects limit) and returns how many were deleted
+func (cfg *apiConfig) deleteS3Objects(ctx context.Context, objects []types.ObjectIdentifier) (int, error) {
+	return cfg.deleteS3ObjectsIn(ctx, cfg.s3Bucket, objects)
+}
+
+// Like deleteS3Objects, from another bucket of ours
+func (cfg *apiConfig) deleteS3ObjectsIn(ctx context.Context, bucket string, objects []types.ObjectIdentifier) (int, error) {
+	deleted := 0
+	for start := 0; start < len(objects); start += 1000 {
+		end := min(start+1000, len(objects))
+		output, err := cfg.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{
+				Objects: objects[start:end],
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete objects: %w", err)
+		}
+		deleted += end - start - len(output.Errors)
+		for _, deleteErr := range output.Errors {
+			fmt.Printf("Failed to delete %s: %s\n", aws.ToString(deleteErr.Key), aws.ToString(deleteErr.Message))
+		}
+	}
+	return deleted, nil
+}
+
+// Removes a record's pointer to an object that no longer exists
+func (cfg *apiConfig) clearStorageReference(ref database.StorageReference) error {
+	if ref.Kind != "video" {
+		return cfg.db.DeleteVideoAsset(ref.VideoID, ref.Kind, ref.Name)
+	}
+
+	video, err := cfg.db.GetVideo(ref.VideoID)
+	if err != nil {
+		return err
+	}
+	video.VideoURL = nil
+	video.UpdatedAt = time.Now()
+	return cfg.db.UpdateVideo(video)
+}
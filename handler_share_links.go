@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+Share links
+
+A share link lets someone without an account watch one private video:
+
+	http://localhost:8091/share/<token>
+
+The token is random and only its SHA-256 is stored, so a leaked database
+doesn't leak working links. Opening the link redirects to a short-lived signed
+URL for the video; the redirect itself isn't cacheable, so revoking a link or
+letting it expire takes effect immediately. Opening links is rate limited per
+client IP (SHARE_LINK_RATE_LIMIT per minute) to stop tokens being guessed or a
+link being hammered.
+*/
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (cfg *apiConfig) shareLinkURL(token string) string {
+	return fmt.Sprintf("http://localhost:%s/share/%s", cfg.port, token)
+}
+
+// Creates a share link for a video. The token is only ever returned here.
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Go duration like "72h"; defaults to SHARE_LINK_DEFAULT_EXPIRY
+		ExpiresIn string `json:"expires_in"`
+	}
+	type response struct {
+		database.ShareLink
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	expiresIn := cfg.shareLinkDefaultExpiry
+	if params.ExpiresIn != "" {
+		expiresIn, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			respondWithError(w, http.StatusBadRequest, "expires_in must be a positive duration like 72h", err)
+			return
+		}
+	}
+	if expiresIn > cfg.shareLinkMaxExpiry {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in can be at most %s", cfg.shareLinkMaxExpiry), nil)
+		return
+	}
+
+	token, err := generateRandomName()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate share token", err)
+		return
+	}
+
+	link, err := cfg.db.CreateShareLink(video.ID, hashShareToken(token), time.Now().Add(expiresIn))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		ShareLink: link,
+		Token:     token,
+		URL:       cfg.shareLinkURL(token),
+	})
+}
+
+// Lists a video's active share links
+func (cfg *apiConfig) handlerShareLinksList(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.GetActiveShareLinks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share links", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, links)
+}
+
+func (cfg *apiConfig) handlerShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	shareID, err := uuid.Parse(r.PathValue("shareID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeShareLink(video.ID, shareID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Redirects a share link to a signed URL for its video
+func (cfg *apiConfig) handlerShareLinkOpen(w http.ResponseWriter, r *http.Request) {
+	allowed, retryAfter := cfg.shareLinkLimiter.allow(clientIP(r))
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later", nil)
+		return
+	}
+
+	link, err := cfg.db.GetShareLinkByTokenHash(hashShareToken(r.PathValue("token")))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	// Unknown, expired and revoked links all look the same from outside
+	if link.ID == uuid.Nil || !link.IsActive() {
+		respondWithError(w, http.StatusNotFound, "Share link not found or expired", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil || video.ID == uuid.Nil || video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusNotFound, "Video not available", err)
+		return
+	}
+
+	videoURL, err := cfg.signStoredURL(r.Context(), *video.VideoURL, signingOptions{owner: video.UserID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+
+	err = cfg.db.RecordShareLinkUse(link.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update share link", err)
+		return
+	}
+	cfg.views.recordView(video.ID, viewerKey(r))
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, videoURL, http.StatusFound)
+}
+
+// Loads the video named by the videoID path value and checks the request comes
+// from its owner, responding with an error if not
+func (cfg *apiConfig) authorizeVideoOwner(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
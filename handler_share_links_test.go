@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// A share link is the way to hand out a private video: /play stays closed to
+// anyone without the owner's token, while the link still plays
+func TestShareLinkPlaysPrivateVideo(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	video, _ := createTestVideo(t, cfg, database.VisibilityPrivate)
+
+	token := "test-share-token"
+	_, err := cfg.db.CreateShareLink(video.ID, hashShareToken(token), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("couldn't create share link: %v", err)
+	}
+
+	mux := newTestMux(map[string]http.HandlerFunc{
+		"GET /api/v1/videos/{videoID}/play": cfg.handlerVideoPlay,
+		"GET /share/{token}":                cfg.handlerShareLinkOpen,
+	})
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/videos/"+video.ID.String()+"/play", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/play without a token: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/share/"+token, nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("/share/{token}: got status %d, want %d: %s", rec.Code, http.StatusFound, rec.Body)
+	}
+	if rec.Header().Get("Location") == "" {
+		t.Error("/share/{token} redirected without a Location")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Most videos a single request may list
+const maxThumbnailBatchSize = 1000
+
+// Queues thumbnail regeneration for the listed videos, or for every uploaded
+// video of a user. Responds straight away with the batch to poll for progress.
+func (cfg *apiConfig) handlerThumbnailBatchCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+		UserID   *uuid.UUID  `json:"user_id"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if (len(params.VideoIDs) == 0) == (params.UserID == nil) {
+		respondWithError(w, http.StatusBadRequest, "Set exactly one of video_ids and user_id", nil)
+		return
+	}
+
+	videoIDs := params.VideoIDs
+	if params.UserID != nil {
+		user, err := cfg.db.GetUser(*params.UserID)
+		if err != nil || user == nil {
+			respondWithError(w, http.StatusNotFound, "User not found", err)
+			return
+		}
+		videos, err := cfg.db.GetVideos(*params.UserID, database.VideoSortCreatedAt)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+			return
+		}
+		for _, video := range videos {
+			if video.VideoURL != nil && *video.VideoURL != "" {
+				videoIDs = append(videoIDs, video.ID)
+			}
+		}
+	} else if len(videoIDs) > maxThumbnailBatchSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d videos per batch", maxThumbnailBatchSize), nil)
+		return
+	}
+
+	// Each video only needs regenerating once
+	seen := make(map[uuid.UUID]bool, len(videoIDs))
+	unique := make([]uuid.UUID, 0, len(videoIDs))
+	for _, id := range videoIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	batch := cfg.queueThumbnailBatch(unique)
+	w.Header().Set("Location", "/admin/thumbnails/regenerate/"+batch.ID.String())
+	respondWithJSON(w, http.StatusAccepted, batch)
+}
+
+func (cfg *apiConfig) handlerThumbnailBatchGet(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	batchID, err := uuid.Parse(r.PathValue("batchID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid batch ID", err)
+		return
+	}
+
+	batch, ok := cfg.thumbnailBatches.get(batchID)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Batch not found", nil)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, batch)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// Sets a video's thumbnail to the frame at ?at= of the stored video, either
+// seconds in (default 0) or a percentage of its length ("25%"). Only the parts
+// of the video needed for that frame are downloaded.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	position := framePosition{}
+	if value := r.URL.Query().Get("at"); value != "" {
+		position, err = parseFramePosition(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "at must be a number of seconds or a percentage from 0% to 100%", err)
+			return
+		}
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	framePath, _, err := cfg.extractFrameFromS3(r.Context(), video, position)
+	if errors.Is(err, errFrameOutOfRange) {
+		respondWithError(w, http.StatusBadRequest, "at is past the end of the video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't extract frame", err)
+		return
+	}
+	defer os.Remove(framePath)
+
+	video, err = cfg.saveThumbnailFrame(video, framePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, signOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
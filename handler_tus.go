@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+/*
+Resumable uploads (tus 1.0.0, https://tus.io/protocols/resumable-upload)
+
+1. POST   /tus            creates an upload. Upload-Length gives the total size and
+                          Upload-Metadata must carry video_id and filetype, and
+                          may name a processing profile, set the stored
+                          object's language and metadata, and ask for a draft.
+2. PATCH  /tus/{uploadID} appends a chunk at Upload-Offset.
+3. HEAD   /tus/{uploadID} reports how much has been received, so an interrupted
+                          client knows where to resume.
+
+Chunks are written to a temp file. Once the last byte arrives the file goes
+through the same pipeline as a regular upload. Supported extensions are
+creation, expiration (unfinished uploads are discarded after tusUploadExpiry)
+and termination (DELETE).
+*/
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+type tusUpload struct {
+	// Serializes PATCH requests so two chunks can't be written at once
+	mu sync.Mutex
+
+	ID          string
+	VideoID     uuid.UUID
+	UserID      uuid.UUID
+	Length      int64
+	Offset      int64
+	Path        string
+	AliasedType bool
+	Profile     string
+	Metadata    objectMetadata
+	// If-Match from the start of the upload, for a conditional replace
+	IfMatch string
+	// Store the finished file as a draft
+	Draft     bool
+	ExpiresAt time.Time
+}
+
+type tusStore struct {
+	mu      sync.Mutex
+	uploads map[string]*tusUpload
+}
+
+func newTusStore() *tusStore {
+	return &tusStore{
+		uploads: make(map[string]*tusUpload),
+	}
+}
+
+func (s *tusStore) get(id string) (*tusUpload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[id]
+	if !ok || time.Now().After(upload.ExpiresAt) {
+		return nil, false
+	}
+	return upload, true
+}
+
+func (s *tusStore) add(upload *tusUpload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[upload.ID] = upload
+}
+
+// Forgets an upload and deletes its temp file
+func (s *tusStore) remove(id string) {
+	s.mu.Lock()
+	upload, ok := s.uploads[id]
+	delete(s.uploads, id)
+	s.mu.Unlock()
+
+	if ok {
+		os.Remove(upload.Path)
+	}
+}
+
+// Periodically discards expired uploads so abandoned temp files don't pile up
+func (s *tusStore) startJanitor(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.mu.Lock()
+			var expired []string
+			for id, upload := range s.uploads {
+				if time.Now().After(upload.ExpiresAt) {
+					expired = append(expired, id)
+				}
+			}
+			s.mu.Unlock()
+
+			for _, id := range expired {
+				log.Printf("Discarding expired tus upload %s", id)
+				s.remove(id)
+			}
+		}
+	}()
+}
+
+// Checks the protocol version and sets headers common to every tus response.
+// Returns false (having responded) if the client speaks an unsupported version.
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return false
+	}
+	return true
+}
+
+// Parses Upload-Metadata: comma-separated "key base64(value)" pairs
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %q: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(cfg.maxVideoUploadBytes, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > cfg.maxVideoUploadBytes {
+		respondWithUploadError(w, cfg.uploadTooLargeError(nil))
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+
+	videoID, err := uuid.Parse(metadata["video_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload-Metadata must include a valid video_id", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return
+	}
+
+	aliasedType, err := cfg.validateVideoMediaType(metadata["filetype"])
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	profile, ok := cfg.processingProfile(metadata["profile"])
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return
+	}
+
+	objectMeta, err := parseObjectMetadata(metadata["language"], metadata["metadata"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.checkVideoIfMatch(r.Context(), video, r.Header.Get("If-Match"))
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	tempFile, err := os.CreateTemp(cfg.uploadTempDir, "tubely-tus-*")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temp file", err)
+		return
+	}
+	tempFile.Close()
+
+	idBytes := make([]byte, 16)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate upload ID", err)
+		return
+	}
+
+	upload := &tusUpload{
+		ID:          hex.EncodeToString(idBytes),
+		VideoID:     videoID,
+		UserID:      userID,
+		Length:      length,
+		Path:        tempFile.Name(),
+		AliasedType: aliasedType,
+		Profile:     profile.name,
+		Metadata:    objectMeta,
+		IfMatch:     r.Header.Get("If-Match"),
+		Draft:       metadata["draft"] == "true",
+		ExpiresAt:   time.Now().Add(cfg.tusUploadExpiry),
+	}
+	cfg.tusUploads.add(upload)
+	cfg.uploads.start(videoID, userID, length)
+
+	fmt.Println("created tus upload", upload.ID, "for video", videoID, "by user", userID)
+
+	w.Header().Set("Location", "/api/"+apiVersion+"/tus/"+upload.ID)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Authenticates the request and looks up the upload it refers to. Responds and
+// returns false if either fails.
+//
+// The upload was authorized when it was created, so the token's expiry isn't
+// checked again here: a background upload from a phone can take longer than an
+// access token lives. The token must still be genuine and belong to the user who
+// created the upload, and the upload itself expires after tusUploadExpiry.
+func (cfg *apiConfig) getTusUpload(w http.ResponseWriter, r *http.Request) (*tusUpload, bool) {
+	if !checkTusResumable(w, r) {
+		return nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return nil, false
+	}
+	userID, err := auth.ValidateJWTIgnoringExpiry(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, false
+	}
+
+	upload, ok := cfg.tusUploads.get(r.PathValue("uploadID"))
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return nil, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return nil, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	upload.mu.Lock()
+	offset := upload.Offset
+	upload.mu.Unlock()
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Upload-Offset must be %d", upload.Offset), nil)
+		return
+	}
+
+	// Never accept more than the declared length, nor more than one chunk per request
+	remaining := upload.Length - upload.Offset
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.tusMaxChunkSize)
+
+	file, err := os.OpenFile(upload.Path, os.O_WRONLY, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to open upload", err)
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Seek(upload.Offset, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to open upload", err)
+		return
+	}
+
+	// Keep whatever arrived even if the client disconnects mid-chunk; it can resume from there
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, remaining))
+	upload.Offset += written
+	cfg.uploads.received(upload.VideoID, upload.Offset)
+	if copyErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(copyErr, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds maximum chunk size", copyErr)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to save chunk", copyErr)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+
+	if upload.Offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Last chunk: hand the complete file to the regular processing pipeline
+	file.Close()
+	defer cfg.tusUploads.remove(upload.ID)
+
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+
+	// Checked when the upload was created; only a config change since can remove it
+	profile, ok := cfg.processingProfile(upload.Profile)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return
+	}
+
+	fmt.Println("tus upload", upload.ID, "complete, processing video", upload.VideoID)
+	_, err = cfg.processVideoUpload(r.Context(), video, upload.Path, videoUploadOptions{
+		aliasedType: upload.AliasedType,
+		profile:     profile,
+		metadata:    upload.Metadata,
+		ifMatch:     upload.IfMatch,
+		draft:       upload.Draft,
+		clientIP:    clientIP(r),
+	})
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	// Wait for any in-flight chunk before deleting the file under it
+	upload.mu.Lock()
+	cfg.tusUploads.remove(upload.ID)
+	upload.mu.Unlock()
+	cfg.uploads.abandon(upload.VideoID, "Upload was cancelled")
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// What uploads the server currently accepts, so clients don't have to hardcode
+// limits that can change with its configuration
+func (cfg *apiConfig) handlerUploadConfig(w http.ResponseWriter, r *http.Request) {
+	type videoConfig struct {
+		MaxBytes     int64    `json:"max_bytes"`
+		ContentTypes []string `json:"content_types"`
+		FormField    string   `json:"form_field"`
+		// Short side videos are downscaled to, 0 for no limit
+		MaxResolution            int      `json:"max_resolution"`
+		ProcessingProfiles       []string `json:"processing_profiles"`
+		DefaultProcessingProfile string   `json:"default_processing_profile"`
+		Renditions               []string `json:"renditions"`
+		// What happens to HDR uploads: flag, reject or tonemap
+		HDRHandling string `json:"hdr_handling"`
+	}
+	type thumbnailConfig struct {
+		MaxBytes     int64    `json:"max_bytes"`
+		ContentTypes []string `json:"content_types"`
+		FormField    string   `json:"form_field"`
+		// "W:H" thumbnails are fitted to, empty if they're kept as uploaded
+		AspectRatio string `json:"aspect_ratio"`
+		Fit         string `json:"fit,omitempty"`
+	}
+	type resumableConfig struct {
+		Tus          bool   `json:"tus"`
+		TusVersion   string `json:"tus_version"`
+		ContentRange bool   `json:"content_range"`
+		MaxChunkSize int64  `json:"max_chunk_bytes"`
+		// How long an unfinished upload is kept
+		ExpiresAfterSeconds int64 `json:"expires_after_seconds"`
+		// Uploads straight to S3 in parts; see multipart_upload.go
+		DirectMultipart   bool `json:"direct_multipart"`
+		MaxMultipartParts int  `json:"max_multipart_parts"`
+	}
+	type response struct {
+		Video       videoConfig     `json:"video"`
+		Thumbnail   thumbnailConfig `json:"thumbnail"`
+		Resumable   resumableConfig `json:"resumable"`
+		Maintenance bool            `json:"maintenance"`
+	}
+
+	videoTypes := cfg.allowedVideoContentTypes()
+	var aliases []string
+	for alias := range cfg.videoContentTypeAliases {
+		aliases = append(aliases, alias)
+	}
+	slices.Sort(aliases)
+	videoTypes = append(videoTypes, aliases...)
+
+	profiles := []string{}
+	for name := range cfg.processingProfiles {
+		profiles = append(profiles, name)
+	}
+	if _, ok := cfg.processingProfiles[defaultProcessingProfile]; !ok {
+		profiles = append(profiles, defaultProcessingProfile)
+	}
+	slices.Sort(profiles)
+
+	thumbnail := thumbnailConfig{
+		MaxBytes:     maxThumbnailUploadSize,
+		ContentTypes: []string{"image/jpeg", "image/png"},
+		FormField:    cfg.thumbnailFormField,
+	}
+	if cfg.thumbnailShape.enabled() {
+		thumbnail.AspectRatio = fmt.Sprintf("%d:%d", cfg.thumbnailShape.aspectW, cfg.thumbnailShape.aspectH)
+		thumbnail.Fit = cfg.thumbnailShape.fit
+	}
+
+	renditions := cfg.renditions
+	if renditions == nil {
+		renditions = []string{}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	respondWithJSON(w, http.StatusOK, response{
+		Video: videoConfig{
+			MaxBytes:                 cfg.maxVideoUploadBytes,
+			ContentTypes:             videoTypes,
+			FormField:                cfg.videoFormField,
+			MaxResolution:            cfg.maxVideoResolution,
+			ProcessingProfiles:       profiles,
+			DefaultProcessingProfile: cfg.defaultProcessingProfile,
+			Renditions:               renditions,
+			HDRHandling:              cfg.hdrHandling,
+		},
+		Thumbnail: thumbnail,
+		Resumable: resumableConfig{
+			Tus:                 true,
+			TusVersion:          tusVersion,
+			ContentRange:        true,
+			MaxChunkSize:        cfg.tusMaxChunkSize,
+			ExpiresAfterSeconds: int64(cfg.tusUploadExpiry.Seconds()),
+			DirectMultipart:     true,
+			MaxMultipartParts:   maxMultipartParts,
+		},
+		Maintenance: cfg.maintenanceMode.get().Enabled,
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type multipartUploadResponse struct {
+	UploadID string             `json:"upload_id"`
+	PartURLs []multipartPartURL `json:"part_urls"`
+}
+
+// Starts a direct multipart upload; see multipart_upload.go
+func (cfg *apiConfig) handlerMultipartCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PartCount   int    `json:"part_count"`
+		ContentType string `json:"content_type"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.PartCount < 1 || params.PartCount > maxMultipartParts {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("part_count must be between 1 and %d", maxMultipartParts), nil)
+		return
+	}
+	_, err = cfg.validateVideoMediaType(params.ContentType)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	key, err := multipartStagingKey(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build upload key", err)
+		return
+	}
+	output, err := cfg.s3Client.CreateMultipartUpload(r.Context(), &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(cfg.s3Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(params.ContentType),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't start multipart upload", err)
+		return
+	}
+	uploadID := aws.ToString(output.UploadId)
+
+	partNumbers := make([]int32, params.PartCount)
+	for i := range partNumbers {
+		partNumbers[i] = int32(i + 1)
+	}
+	partURLs, err := cfg.presignUploadParts(r.Context(), key, uploadID, partNumbers)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload parts", err)
+		return
+	}
+
+	fmt.Println("started multipart upload of video", video.ID, "in", params.PartCount, "parts")
+	respondWithJSON(w, http.StatusCreated, multipartUploadResponse{UploadID: uploadID, PartURLs: partURLs})
+}
+
+// Finds the direct upload in progress for a video and what S3 has of it, for a
+// client that lost its upload ID. With ?parts=N, also presigns URLs for the
+// parts up to N that haven't arrived yet.
+func (cfg *apiConfig) handlerMultipartResume(w http.ResponseWriter, r *http.Request) {
+	type part struct {
+		PartNumber int32  `json:"part_number"`
+		ETag       string `json:"etag"`
+		Size       int64  `json:"size"`
+	}
+	type response struct {
+		UploadID string             `json:"upload_id"`
+		Parts    []part             `json:"parts"`
+		PartURLs []multipartPartURL `json:"part_urls"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	partCount := 0
+	if partsString := r.URL.Query().Get("parts"); partsString != "" {
+		var err error
+		partCount, err = strconv.Atoi(partsString)
+		if err != nil || partCount < 1 || partCount > maxMultipartParts {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("parts must be between 1 and %d", maxMultipartParts), err)
+			return
+		}
+	}
+
+	key, err := multipartStagingKey(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build upload key", err)
+		return
+	}
+	uploadID, err := cfg.findMultipartUpload(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't look up multipart uploads", err)
+		return
+	}
+	if uploadID == "" {
+		respondWithError(w, http.StatusNotFound, "No multipart upload in progress", nil)
+		return
+	}
+	uploaded, err := cfg.listUploadedParts(r.Context(), key, uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't list uploaded parts", err)
+		return
+	}
+
+	received := make(map[int32]bool)
+	parts := make([]part, 0, len(uploaded))
+	for _, p := range uploaded {
+		received[aws.ToInt32(p.PartNumber)] = true
+		parts = append(parts, part{
+			PartNumber: aws.ToInt32(p.PartNumber),
+			ETag:       aws.ToString(p.ETag),
+			Size:       aws.ToInt64(p.Size),
+		})
+	}
+	var missing []int32
+	for n := int32(1); n <= int32(partCount); n++ {
+		if !received[n] {
+			missing = append(missing, n)
+		}
+	}
+	partURLs, err := cfg.presignUploadParts(r.Context(), key, uploadID, missing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload parts", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{UploadID: uploadID, Parts: parts, PartURLs: partURLs})
+}
+
+// Assembles the uploaded parts and processes the result like any other upload
+func (cfg *apiConfig) handlerMultipartComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UploadID string `json:"upload_id"`
+		Profile  string `json:"profile"`
+		Language string `json:"language"`
+		// JSON object of string values, as in the multipart form upload
+		Metadata string `json:"metadata"`
+		Draft    bool   `json:"draft"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.UploadID == "" {
+		respondWithError(w, http.StatusBadRequest, "upload_id is required", nil)
+		return
+	}
+	profile, ok := cfg.processingProfile(params.Profile)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return
+	}
+	metadata, err := parseObjectMetadata(params.Language, params.Metadata)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	key, err := multipartStagingKey(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build upload key", err)
+		return
+	}
+	uploaded, err := cfg.listUploadedParts(r.Context(), key, params.UploadID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Multipart upload not found", err)
+		return
+	}
+	if len(uploaded) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No parts have been uploaded", nil)
+		return
+	}
+	var total int64
+	completed := make([]types.CompletedPart, 0, len(uploaded))
+	for _, p := range uploaded {
+		total += aws.ToInt64(p.Size)
+		completed = append(completed, types.CompletedPart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	if total > cfg.maxVideoUploadBytes {
+		respondWithUploadError(w, cfg.uploadTooLargeError(nil))
+		return
+	}
+
+	_, err = cfg.s3Client.CompleteMultipartUpload(r.Context(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(cfg.s3Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(params.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't complete multipart upload", err)
+		return
+	}
+	// The staging object is only needed until it's processed
+	defer cfg.deleteS3Objects(r.Context(), []types.ObjectIdentifier{{Key: aws.String(key)}})
+
+	head, err := cfg.s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: aws.String(cfg.s3Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't get uploaded video", err)
+		return
+	}
+	aliasedType, err := cfg.validateVideoMediaType(aws.ToString(head.ContentType))
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	tempPath, err := cfg.downloadStagingObject(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't get uploaded video", err)
+		return
+	}
+	defer os.Remove(tempPath)
+
+	fmt.Println("multipart upload complete, processing video", video.ID)
+	updatedVideo, err := cfg.processVideoUpload(r.Context(), video, tempPath, videoUploadOptions{
+		aliasedType: aliasedType,
+		profile:     profile,
+		metadata:    metadata,
+		draft:       params.Draft,
+		clientIP:    clientIP(r),
+	})
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), updatedVideo, signOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+/*
+Content-Range uploads
+
+A simpler resumable option than tus for clients that just want to retry where
+they stopped. The video is sent as the raw request body of one or more
+
+	PUT /video_upload/{videoID}
+	Content-Type: video/mp4
+	Content-Range: bytes 0-8388607/52428800
+
+requests, each continuing exactly where the previous one ended. Until the last
+byte arrives the response is a 202 with a Range header ("bytes=0-8388607") saying
+what has been received. After an interruption the client asks the same with an
+empty PUT whose Content-Range has "*" in place of the byte range, and resumes
+from there.
+
+The first chunk may set ?profile=, ?language=, ?metadata= and ?draft= like the
+multipart upload's form fields, and If-Match for a conditional replace. Chunks go into a temp file; the completed file goes
+through the regular processing pipeline and the last response is the video.
+Unfinished uploads are discarded after TUS_UPLOAD_EXPIRY, and a chunk starting
+at 0 starts the upload over.
+*/
+
+// A parsed Content-Range header. start is -1 for "bytes */total".
+type contentRange struct {
+	start, end, total int64
+}
+
+func parseContentRange(header string) (contentRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return contentRange{}, fmt.Errorf("Content-Range must be in bytes, got %q", header)
+	}
+	byteRange, totalString, ok := strings.Cut(spec, "/")
+	if !ok {
+		return contentRange{}, fmt.Errorf("Content-Range %q has no total length", header)
+	}
+	total, err := strconv.ParseInt(totalString, 10, 64)
+	if err != nil || total <= 0 {
+		return contentRange{}, fmt.Errorf("Content-Range %q has an invalid total length", header)
+	}
+	if byteRange == "*" {
+		return contentRange{start: -1, end: -1, total: total}, nil
+	}
+
+	startString, endString, ok := strings.Cut(byteRange, "-")
+	start, startErr := strconv.ParseInt(startString, 10, 64)
+	end, endErr := strconv.ParseInt(endString, 10, 64)
+	if !ok || startErr != nil || endErr != nil || start < 0 || end < start || end >= total {
+		return contentRange{}, fmt.Errorf("Content-Range %q has an invalid byte range", header)
+	}
+	return contentRange{start: start, end: end, total: total}, nil
+}
+
+// Reports how much of an upload has been received
+func writeRangeUploadStatus(w http.ResponseWriter, code int, upload *tusUpload) {
+	type response struct {
+		ReceivedBytes int64 `json:"received_bytes"`
+		TotalBytes    int64 `json:"total_bytes"`
+	}
+
+	if upload.Offset > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", upload.Offset-1))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, response{
+		ReceivedBytes: upload.Offset,
+		TotalBytes:    upload.Length,
+	})
+}
+
+func (cfg *apiConfig) handlerUploadVideoRange(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return
+	}
+
+	chunk, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if chunk.total > cfg.maxVideoUploadBytes {
+		respondWithUploadError(w, cfg.uploadTooLargeError(nil))
+		return
+	}
+
+	upload, ok := cfg.rangeUploads.get(videoID.String())
+	if chunk.start == -1 {
+		if !ok || upload.Length != chunk.total {
+			respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+			return
+		}
+		upload.mu.Lock()
+		defer upload.mu.Unlock()
+		writeRangeUploadStatus(w, http.StatusOK, upload)
+		return
+	}
+
+	if chunk.start == 0 {
+		err = cfg.checkVideoIfMatch(r.Context(), video, r.Header.Get("If-Match"))
+		if err != nil {
+			respondWithUploadError(w, err)
+			return
+		}
+		upload, ok = cfg.startRangeUpload(w, r, videoID, userID, chunk.total)
+		if !ok {
+			return
+		}
+	} else if !ok {
+		respondWithError(w, http.StatusNotFound, "Upload not found; start again from byte 0", nil)
+		return
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	if chunk.total != upload.Length {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Content-Range total must be %d", upload.Length), nil)
+		return
+	}
+	if chunk.start != upload.Offset {
+		if upload.Offset > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", upload.Offset-1))
+		}
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Chunk must start at byte %d", upload.Offset), nil)
+		return
+	}
+
+	chunkSize := chunk.end - chunk.start + 1
+	if chunkSize > cfg.tusMaxChunkSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Chunk exceeds maximum chunk size", nil)
+		return
+	}
+	if r.ContentLength >= 0 && r.ContentLength != chunkSize {
+		respondWithError(w, http.StatusBadRequest, "Content-Length doesn't match Content-Range", nil)
+		return
+	}
+
+	file, err := os.OpenFile(upload.Path, os.O_WRONLY, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to open upload", err)
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Seek(upload.Offset, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to open upload", err)
+		return
+	}
+
+	// Keep whatever arrived even if the client disconnects mid-chunk; it can resume from there
+	r.Body = http.MaxBytesReader(w, r.Body, chunkSize)
+	written, copyErr := io.Copy(file, r.Body)
+	upload.Offset += written
+	cfg.uploads.received(videoID, upload.Offset)
+	if copyErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(copyErr, &maxBytesErr) {
+			respondWithError(w, http.StatusBadRequest, "Body is longer than Content-Range", copyErr)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Failed to save chunk", copyErr)
+		return
+	}
+	if written != chunkSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Body is shorter than Content-Range; received up to byte %d", upload.Offset), nil)
+		return
+	}
+
+	if upload.Offset < upload.Length {
+		writeRangeUploadStatus(w, http.StatusAccepted, upload)
+		return
+	}
+
+	// Last chunk: hand the complete file to the regular processing pipeline
+	file.Close()
+	defer cfg.rangeUploads.remove(upload.ID)
+
+	// Checked when the upload was started; only a config change since can remove it
+	profile, ok := cfg.processingProfile(upload.Profile)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	fmt.Println("range upload complete, processing video", videoID)
+	updatedVideo, err := cfg.processVideoUpload(r.Context(), video, upload.Path, videoUploadOptions{
+		aliasedType: upload.AliasedType,
+		profile:     profile,
+		metadata:    upload.Metadata,
+		ifMatch:     upload.IfMatch,
+		draft:       upload.Draft,
+		clientIP:    clientIP(r),
+	})
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), updatedVideo, signOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// Starts (or starts over) the Content-Range upload of videoID with the options
+// given on its first chunk. Responds and returns false if they're invalid.
+func (cfg *apiConfig) startRangeUpload(w http.ResponseWriter, r *http.Request, videoID, userID uuid.UUID, length int64) (*tusUpload, bool) {
+	aliasedType, err := cfg.validateVideoMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithUploadError(w, err)
+		return nil, false
+	}
+
+	query := r.URL.Query()
+	profile, ok := cfg.processingProfile(query.Get("profile"))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
+		return nil, false
+	}
+	objectMeta, err := parseObjectMetadata(query.Get("language"), query.Get("metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return nil, false
+	}
+
+	tempFile, err := os.CreateTemp(cfg.uploadTempDir, "tubely-range-*")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create temp file", err)
+		return nil, false
+	}
+	tempFile.Close()
+
+	// Replaces (and deletes the file of) any earlier attempt, once its in-flight
+	// chunk is done
+	if previous, ok := cfg.rangeUploads.get(videoID.String()); ok {
+		previous.mu.Lock()
+		cfg.rangeUploads.remove(previous.ID)
+		previous.mu.Unlock()
+	}
+	upload := &tusUpload{
+		ID:          videoID.String(),
+		VideoID:     videoID,
+		UserID:      userID,
+		Length:      length,
+		Path:        tempFile.Name(),
+		AliasedType: aliasedType,
+		Profile:     profile.name,
+		Metadata:    objectMeta,
+		IfMatch:     r.Header.Get("If-Match"),
+		Draft:       query.Get("draft") == "true",
+		ExpiresAt:   time.Now().Add(cfg.tusUploadExpiry),
+	}
+	cfg.rangeUploads.add(upload)
+	cfg.uploads.start(videoID, userID, length)
+
+	fmt.Println("started range upload of video", videoID, "by user", userID)
+	return upload, true
+}
@@ -15,6 +15,8 @@ import (
 	"github.com/google/uuid"
 )
 
+const maxThumbnailUploadSize = 10 << 20 // 10MB
+
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -29,7 +31,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -38,16 +40,16 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
 
 	// Parsing form data for multipart files
-	const maxMemory = 10 << 20
-	err = r.ParseMultipartForm(maxMemory)
+	r.Body = http.MaxBytesReader(w, r.Body, maxThumbnailUploadSize)
+	err = r.ParseMultipartForm(maxThumbnailUploadSize)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form data", err)
 		return
 	}
 
-	file, header, err := r.FormFile("thumbnail")
+	file, header, err := r.FormFile(cfg.thumbnailFormField)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to get form file", err)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unable to get thumbnail file from form field %q", cfg.thumbnailFormField), err)
 		return
 	}
 	defer file.Close()
@@ -66,6 +68,31 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Check the file really is the image type it claims to be, then rewind it for
+	// the checks and re-encoding below
+	head, err := readSniffHead(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read thumbnail file", err)
+		return
+	}
+	err = cfg.checkContentSignature(mediaType, head)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to read thumbnail file", err)
+		return
+	}
+
+	// Reject images that smuggle another format (e.g. an appended ZIP)
+	err = cfg.checkPolyglot(file, header.Size, false)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
 	// Determine file extension from media type
 	fileExtension := getFileExtension(mediaType)
 	
@@ -92,10 +119,12 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 	defer outFile.Close()
 
-	// Copy the file content to disk
-	_, err = io.Copy(outFile, file)
+	// Re-encode the image to disk, which strips EXIF metadata (GPS, device info)
+	err = sanitizeImage(file, outFile, mediaType, cfg.thumbnailShape, cfg.progressiveThumbnails)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save file", err)
+		outFile.Close()
+		os.Remove(filePath)
+		respondWithError(w, http.StatusBadRequest, "Unable to process image", err)
 		return
 	}
 
@@ -119,6 +148,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	updatedVideo := video // Copy existing video
 	updatedVideo.UpdatedAt = time.Now()
 	updatedVideo.ThumbnailURL = &thumbnailURL
+	cfg.setThumbnailPlaceholder(&updatedVideo, filePath)
 
 	// Update video in database
 	err = cfg.db.UpdateVideo(updatedVideo)
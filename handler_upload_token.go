@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+/*
+Upload tokens
+
+An embedded upload widget shouldn't hold its user's access token. The owner of a
+video can instead mint an upload token for it with
+POST /videos/{videoID}/upload-token. The widget sends it as a bearer token to
+POST /video_upload/{videoID}, the only endpoint that accepts it, and only for
+that video. Tokens expire after UPLOAD_TOKEN_EXPIRY and can't be used to get
+another.
+*/
+
+// Mints an upload token for one of the user's videos
+func (cfg *apiConfig) handlerUploadTokenCreate(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Token     string    `json:"token"`
+		VideoID   uuid.UUID `json:"video_id"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(cfg.uploadTokenExpiry)
+	token, err := auth.MakeUploadToken(video.UserID, video.ID, cfg.jwtKeys, cfg.uploadTokenExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Token:     token,
+		VideoID:   video.ID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// Authenticates an upload to videoID, which takes the user's access token or an
+// upload token made for that video. Returns the user, or responds with an error
+// and returns false.
+func (cfg *apiConfig) authenticateUpload(w http.ResponseWriter, r *http.Request, videoID uuid.UUID) (uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, false
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err == nil {
+		return userID, true
+	}
+	userID, tokenVideoID, uploadErr := auth.ValidateUploadToken(token, cfg.jwtKeys)
+	if uploadErr != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, false
+	}
+	if tokenVideoID != videoID {
+		respondWithError(w, http.StatusForbidden, "Upload token is for a different video", nil)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
@@ -1,22 +1,26 @@
 package main
 
 import (
-	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"time"
+	"slices"
+	"strconv"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/google/uuid"
 )
 
+// Content types some browsers send for files that are really MP4. Uploads labelled
+// with one of these are accepted only if ffprobe confirms the container.
+var defaultVideoContentTypeAliases = map[string]string{
+	"application/mp4": "video/mp4",
+}
+
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	// Step 1: Extract videoID from request path
 	videoIDString := r.PathValue("videoID")
@@ -26,16 +30,12 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Step 2: Authenticate user to get userID
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	// Step 2: Authenticate user to get userID, with their JWT or an upload token
+	// for this video. This is the only auth check: the upload and processing
+	// below can outlast the token, and everything after this point acts on the
+	// captured userID.
+	userID, ok := cfg.authenticateUpload(w, r, videoID)
+	if !ok {
 		return
 	}
 
@@ -52,156 +52,221 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	fmt.Println("uploading video", videoID, "by user", userID)
-
-	// Step 5: Set upload limit & parse form
-	const maxUploadSize = 1 << 30 // 1GB
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
-
-	err = r.ParseMultipartForm(maxUploadSize)
+	signOpts, err := cfg.signingOptionsFor(r)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form data", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
-	// Get the video file from form
-	file, header, err := r.FormFile("video")
+	// Fail a stale conditional replace before the file is sent
+	ifMatch := r.Header.Get("If-Match")
+	err = cfg.checkVideoIfMatch(r.Context(), video, ifMatch)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to get video file", err)
+		respondWithUploadError(w, err)
 		return
 	}
-	defer file.Close()
 
-	// Step 6: Validate it's an MP4
-	contentType := header.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
+	// Refuse what won't fit before any of it is written
+	err = cfg.checkUploadDiskSpace(r.ContentLength)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid content type", err)
+		respondWithUploadError(w, err)
 		return
 	}
 
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Only MP4 videos are allowed", nil)
-		return
-	}
+	fmt.Println("uploading video", videoID, "by user", userID)
 
-	// Step 7: Save to temp file (Enable streaming files to disk & then to S3 & avoiding memory overload. Also for network resilience)
-	tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
+	cfg.uploads.start(videoID, userID, r.ContentLength)
+	defer cfg.uploads.abandon(videoID, "Upload was rejected or interrupted")
+
+	// Step 5: Set upload limit
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxVideoUploadBytes)
+	r.Body = &progressReader{ReadCloser: r.Body, tracker: cfg.uploads, videoID: videoID}
+
+	// Steps 6-7: Validate it's an MP4 and stream it to a temp file (Enable streaming
+	// files to disk & then to S3 & avoiding memory overload. Also for network resilience)
+	form, err := cfg.readVideoUploadForm(r)
+	if form.path != "" {
+		defer os.Remove(form.path) // Clean up temp file
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create temp file", err)
+		respondWithUploadError(w, err)
 		return
 	}
-	defer os.Remove(tempFile.Name()) // Clean up temp file
-	defer tempFile.Close()
 
-	// Copy uploaded file to temp file
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save video to temp file", err)
+	profile, ok := cfg.processingProfile(form.values.Get("profile"))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown processing profile", nil)
 		return
 	}
 
-	// Close the temp file so ffmpeg can access it
-	tempFile.Close()
-
-	// Step 7b: Process video for fast start in-order to enable video streaming before uploading to S3
-	fmt.Println("Processing video for fast start...")
-	processedPath, err := processVideoForFastStart(tempFile.Name())
+	metadata, err := parseObjectMetadata(form.values.Get("language"), form.values.Get("metadata"))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to process video for fast start", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
-	defer os.Remove(processedPath) // Clean up processed file
 
-	// Open the processed file for S3 upload
-	processedFile, err := os.Open(processedPath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to open processed video", err)
-		return
+	fastStart := true
+	if value := form.values.Get("fast_start"); value != "" {
+		fastStart, err = strconv.ParseBool(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "fast_start must be true or false", err)
+			return
+		}
 	}
-	defer processedFile.Close()
 
-	// Generate random filename
-	randomBytes := make([]byte, 32)
-	_, err = rand.Read(randomBytes)
+	// Steps 7a-10: Validate, process and store the video
+	updatedVideo, err := cfg.processVideoUpload(r.Context(), video, form.path, videoUploadOptions{
+		aliasedType:    form.aliasedType,
+		skipProcessing: form.values.Get("skip_processing") == "true",
+		noFastStart:    !fastStart,
+		profile:        profile,
+		metadata:       metadata,
+		ifMatch:        ifMatch,
+		draft:          form.values.Get("draft") == "true",
+		clientIP:       clientIP(r),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate random filename", err)
+		respondWithUploadError(w, err)
 		return
 	}
 
-	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
-
-	// Detect video aspect ratio
-	aspectRatio, err := getVideoAspectRatio(processedPath)
+	// Convert to signed video for response
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), updatedVideo, signOpts)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to analyze video", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
 		return
 	}
 
-	fmt.Printf("Detected video aspect ratio: %s\n", aspectRatio)
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
 
-	// Create S3 key with aspect ratio prefix
-	fileKey := fmt.Sprintf("%s/%s.mp4", aspectRatio, randomString)
+// Largest non-file form field a video upload accepts
+const maxVideoFormValueSize = 64 << 10
 
-	// Step 8: Upload to S3 with retry logic
-	maxRetries := 3
-	var uploadErr error
+// A video upload's multipart form, with the file already saved to a temp file
+type videoUploadForm struct {
+	values url.Values
+	// Where the video was saved, empty if it never arrived. The caller removes it.
+	path string
+	// The file was sent with an MP4 alias content type; see validateVideoMediaType
+	aliasedType bool
+}
 
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		// Reset file pointer to beginning for each retry
-		_, seekErr := processedFile.Seek(0, io.SeekStart)
-		if seekErr != nil {
-			uploadErr = seekErr
+// Reads a video upload's multipart form part by part, streaming the video
+// straight into a temp file in the upload temp dir. ParseMultipartForm would
+// hold it in memory or spill it into the OS temp dir first, wherever that is.
+// Query parameters fill in fields the form doesn't have.
+func (cfg *apiConfig) readVideoUploadForm(r *http.Request) (videoUploadForm, error) {
+	form := videoUploadForm{values: url.Values{}}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return form, &uploadError{http.StatusBadRequest, "Unable to parse form data", err}
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
 			break
 		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return form, cfg.uploadTooLargeError(err)
+		}
+		if err != nil {
+			return form, &uploadError{http.StatusBadRequest, "Unable to parse form data", err}
+		}
 
-		_, uploadErr = cfg.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-			Bucket:      aws.String(cfg.s3Bucket),
-			Key:         aws.String(fileKey),
-			Body:        processedFile,
-			ContentType: aws.String("video/mp4"),
-		})
+		name := part.FormName()
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxVideoFormValueSize+1))
+			if errors.As(err, &maxBytesErr) {
+				return form, cfg.uploadTooLargeError(err)
+			}
+			if err != nil {
+				return form, &uploadError{http.StatusBadRequest, "Unable to parse form data", err}
+			}
+			if len(value) > maxVideoFormValueSize {
+				return form, &uploadError{http.StatusBadRequest, fmt.Sprintf("Form field %q is too large", name), nil}
+			}
+			form.values.Add(name, string(value))
+			continue
+		}
+		if name != cfg.videoFormField || form.path != "" {
+			continue
+		}
 
-		if uploadErr == nil {
-			// Success!
-			break
+		form.aliasedType, err = cfg.validateVideoMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			return form, err
 		}
 
-		fmt.Printf("S3 upload attempt %d failed: %v\n", attempt, uploadErr)
+		// Check the file is what it claims to be before saving any of it. The part
+		// can't be rewound, so the sniffed bytes are put back in front of the rest.
+		head, err := readSniffHead(part)
+		if errors.As(err, &maxBytesErr) {
+			return form, cfg.uploadTooLargeError(err)
+		}
+		if err != nil {
+			return form, &uploadError{http.StatusBadRequest, "Unable to parse form data", err}
+		}
+		err = cfg.checkContentSignature(part.Header.Get("Content-Type"), head)
+		if err != nil {
+			return form, err
+		}
 
-		// If not the last attempt, wait before retrying
-		if attempt < maxRetries {
-			backoffTime := time.Second * time.Duration(attempt) // 1s, 2s, 3s
-			time.Sleep(backoffTime)
+		tempFile, err := os.CreateTemp(cfg.uploadTempDir, "tubely-upload-*")
+		if err != nil {
+			return form, &uploadError{http.StatusInternalServerError, "Failed to create temp file", err}
+		}
+		form.path = tempFile.Name()
+		_, err = io.Copy(tempFile, io.MultiReader(bytes.NewReader(head), part))
+		// Close the temp file so ffmpeg can access it
+		closeErr := tempFile.Close()
+		if errors.As(err, &maxBytesErr) {
+			return form, cfg.uploadTooLargeError(err)
+		}
+		if err != nil || closeErr != nil {
+			return form, &uploadError{http.StatusInternalServerError, "Failed to save video to temp file", errors.Join(err, closeErr)}
 		}
 	}
 
-	if uploadErr != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to upload to S3 after retries", uploadErr)
-		return
+	if form.path == "" {
+		return form, &uploadError{http.StatusBadRequest, fmt.Sprintf("Unable to get video file from form field %q", cfg.videoFormField), nil}
 	}
+	for key, values := range r.URL.Query() {
+		if !form.values.Has(key) {
+			form.values[key] = values
+		}
+	}
+	return form, nil
+}
 
-	// Step 9: Update DB with S3 URL
-	videoURL := fmt.Sprintf("%s,%s", cfg.s3Bucket, fileKey)
-
-	// Update the video with the S3 URL
-	updatedVideo := video // Copy existing video
-	updatedVideo.UpdatedAt = time.Now()
-	updatedVideo.VideoURL = &videoURL
+// The error for a video larger than MAX_VIDEO_UPLOAD_BYTES, stating the limit
+func (cfg *apiConfig) uploadTooLargeError(err error) *uploadError {
+	limit := strconv.FormatFloat(float64(cfg.maxVideoUploadBytes)/(1<<20), 'f', -1, 64)
+	return &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Video exceeds the %sMB upload limit", limit), err}
+}
 
-	// Update video in database
-	err = cfg.db.UpdateVideo(updatedVideo)
+// Checks the client-declared content type of a video upload is one of the allowed
+// containers; see video_containers.go. Returns whether the type was one of the
+// configured MP4 aliases, in which case the container still has to be confirmed
+// with ffprobe.
+func (cfg *apiConfig) validateVideoMediaType(contentType string) (bool, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update video", err)
-		return
+		return false, &uploadError{http.StatusBadRequest, "Invalid content type", err}
 	}
 
-	// Convert to signed video for response
-	signedVideo, err := cfg.dbVideoToSignedVideo(updatedVideo)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
-		return
+	// Some browsers label MP4 files with a synonym; accept those provisionally and let ffprobe decide later
+	aliasedType := false
+	if normalized, ok := cfg.videoContentTypeAliases[mediaType]; ok {
+		mediaType = normalized
+		aliasedType = true
 	}
 
-	respondWithJSON(w, http.StatusOK, signedVideo)
+	container, ok := videoContainerTypes[mediaType]
+	if !ok || !slices.Contains(cfg.allowedVideoContainers, container) {
+		return false, cfg.unsupportedContainerError()
+	}
+	return aliasedType, nil
 }
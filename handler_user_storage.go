@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var (
+	bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	awsRegionPattern  = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+)
+
+// Looks up the user named in the path. Responds and returns false if there's no
+// such user.
+func (cfg *apiConfig) adminPathUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return uuid.Nil, false
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", err)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func (cfg *apiConfig) handlerUserStorageGet(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, ok := cfg.adminPathUser(w, r)
+	if !ok {
+		return
+	}
+
+	storage, err := cfg.db.GetUserStorage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage settings", err)
+		return
+	}
+	if storage.Bucket == "" {
+		respondWithError(w, http.StatusNotFound, "User stores videos in the default bucket", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, storage)
+}
+
+// Points a user's future uploads at their own bucket. The bucket has to be
+// reachable with the given settings before they're saved.
+func (cfg *apiConfig) handlerUserStoragePut(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Bucket     string `json:"bucket"`
+		Region     string `json:"region"`
+		RoleARN    string `json:"role_arn"`
+		ExternalID string `json:"external_id"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, ok := cfg.adminPathUser(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !bucketNamePattern.MatchString(params.Bucket) {
+		respondWithError(w, http.StatusBadRequest, "Invalid bucket name", nil)
+		return
+	}
+	if params.Bucket == cfg.s3Bucket {
+		respondWithError(w, http.StatusBadRequest, "That's the default bucket", nil)
+		return
+	}
+	if !awsRegionPattern.MatchString(params.Region) {
+		respondWithError(w, http.StatusBadRequest, "Invalid region", nil)
+		return
+	}
+	if params.RoleARN != "" && !strings.HasPrefix(params.RoleARN, "arn:aws:iam::") {
+		respondWithError(w, http.StatusBadRequest, "role_arn must be an IAM role ARN", nil)
+		return
+	}
+	if params.ExternalID != "" && params.RoleARN == "" {
+		respondWithError(w, http.StatusBadRequest, "external_id requires role_arn", nil)
+		return
+	}
+
+	storage := database.UserStorage{
+		UserID:     userID,
+		Bucket:     params.Bucket,
+		Region:     params.Region,
+		RoleARN:    params.RoleARN,
+		ExternalID: params.ExternalID,
+	}
+	err = cfg.storageClients.get(storage).check(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't access bucket with these settings", err)
+		return
+	}
+
+	storage, err = cfg.db.UpsertUserStorage(storage)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save storage settings", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, storage)
+}
+
+// Sends a user's future uploads back to the default bucket. Videos already in
+// their bucket stay there.
+func (cfg *apiConfig) handlerUserStorageDelete(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+	userID, ok := cfg.adminPathUser(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.db.DeleteUserStorage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete storage settings", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "User stores videos in the default bucket", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
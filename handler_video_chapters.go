@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type chaptersResponse struct {
+	Chapters []database.VideoChapter `json:"chapters"`
+	// Signed URL of the WebVTT chapters file, empty when there are no chapters
+	URL string `json:"url"`
+}
+
+// Lists a video's chapters along with the URL of their VTT file
+func (cfg *apiConfig) handlerVideoChaptersGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	chapters, err := cfg.db.GetVideoChapters(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+
+	response := chaptersResponse{Chapters: chapters}
+	for _, asset := range assets {
+		if asset.Kind == assetKindChapters {
+			response.URL, err = cfg.signStoredURL(r.Context(), asset.URL, cfg.signingOptionsForVideo(video, signOpts))
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+				return
+			}
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// Replaces a video's chapters with either an uploaded WebVTT chapters file
+// (Content-Type: text/vtt) or a JSON list of chapters to generate one from
+func (cfg *apiConfig) handlerVideoChaptersPut(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Chapters []database.VideoChapter `json:"chapters"`
+	}
+
+	video, ok := cfg.chaptersVideo(w, r)
+	if !ok {
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxChaptersFileBytes)
+	var chapters []database.VideoChapter
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/vtt":
+		data, err := io.ReadAll(r.Body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Chapters file is too large", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't read chapters file", err)
+			return
+		}
+		chapters, err = parseChaptersVTT(data)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid chapters file: "+err.Error(), err)
+			return
+		}
+	case "application/json", "":
+		params := parameters{}
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+		err = validateChapters(params.Chapters)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid chapters: "+err.Error(), err)
+			return
+		}
+		chapters = params.Chapters
+	default:
+		respondWithError(w, http.StatusUnsupportedMediaType, "Send chapters as text/vtt or application/json", nil)
+		return
+	}
+
+	stored, err := cfg.saveChapters(r.Context(), video, chapters)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+	chaptersURL, err := cfg.signStoredURL(r.Context(), stored, cfg.signingOptionsForVideo(video, signOpts))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chaptersResponse{Chapters: chapters, URL: chaptersURL})
+}
+
+func (cfg *apiConfig) handlerVideoChaptersDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.chaptersVideo(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.deleteChapters(r.Context(), video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chapters", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Looks up the uploaded video in the path and checks the caller owns it.
+// Responds and returns false otherwise.
+func (cfg *apiConfig) chaptersVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return database.Video{}, false
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Duplicates a video in the owner's library. Stored objects are copied server-side
+// in S3, so nothing is downloaded or re-uploaded.
+func (cfg *apiConfig) handlerVideoCopy(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't copy this video", nil)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	newVideo, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       video.Title,
+		Description: video.Description,
+		UserID:      userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+
+	// Copy the video file along with its derived assets
+	if video.VideoURL != nil && *video.VideoURL != "" {
+		newVideoURL, err := cfg.copyVideoObjects(r.Context(), video, newVideo.ID)
+		if err != nil {
+			cfg.db.DeleteVideo(newVideo.ID)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't copy video file", err)
+			return
+		}
+		newVideo.VideoURL = &newVideoURL
+		// A copy of a draft is a draft too, since its file is under the draft prefix
+		newVideo.DraftExpiresAt = video.DraftExpiresAt
+		newVideo.ColorPrimaries = video.ColorPrimaries
+		newVideo.ColorTransfer = video.ColorTransfer
+		newVideo.ColorSpace = video.ColorSpace
+		newVideo.HDR = video.HDR
+		newVideo.PerceptualHash = video.PerceptualHash
+		newVideo.DurationSeconds = video.DurationSeconds
+	}
+
+	// Thumbnails in the local assets directory get their own file so deleting one
+	// copy doesn't break the other
+	if video.ThumbnailURL != nil {
+		thumbnailURL := *video.ThumbnailURL
+		if srcPath, ok := cfg.localAssetPath(thumbnailURL); ok {
+			thumbnailURL, err = cfg.copyLocalAsset(srcPath)
+			if err != nil {
+				cfg.db.DeleteVideo(newVideo.ID)
+				respondWithError(w, http.StatusInternalServerError, "Couldn't copy thumbnail", err)
+				return
+			}
+		}
+		newVideo.ThumbnailURL = &thumbnailURL
+		newVideo.ThumbnailPlaceholder = video.ThumbnailPlaceholder
+		newVideo.ThumbnailColor = video.ThumbnailColor
+	}
+
+	newVideo.UpdatedAt = time.Now()
+	err = cfg.db.UpdateVideo(newVideo)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), newVideo, signOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, signedVideo)
+}
+
+// Copies a video's S3 object and its derived assets to fresh keys, records the
+// copied assets against newVideoID and returns the new "bucket,key" video reference
+func (cfg *apiConfig) copyVideoObjects(ctx context.Context, video database.Video, newVideoID uuid.UUID) (string, error) {
+	srcBucket, srcKey, err := parseStoredURL(*video.VideoURL)
+	if err != nil {
+		return "", err
+	}
+
+	randomString, err := generateRandomName()
+	if err != nil {
+		return "", err
+	}
+
+	// Keep the aspect ratio directory and extension: landscape/<random>.mp4
+	ext := path.Ext(srcKey)
+	newKey, err := buildObjectKey(path.Dir(srcKey), randomString+ext)
+	if err != nil {
+		return "", err
+	}
+	err = cfg.copyS3Object(ctx, srcBucket, srcKey, newKey)
+	if err != nil {
+		return "", err
+	}
+
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		return "", err
+	}
+	newAssetPrefix := assetPrefix(newKey)
+	for _, asset := range assets {
+		assetBucket, assetKey, err := parseStoredURL(asset.URL)
+		if err != nil {
+			return "", err
+		}
+
+		// Copies go where the asset kind is stored now
+		storage, newAssetKey, err := cfg.assetLocation(asset.Kind, newAssetPrefix, path.Base(assetKey))
+		if err != nil {
+			return "", err
+		}
+		err = cfg.copyS3ObjectTo(ctx, assetBucket, assetKey, storage, newAssetKey)
+		if err != nil {
+			return "", err
+		}
+
+		err = cfg.db.UpsertVideoAsset(database.VideoAsset{
+			VideoID: newVideoID,
+			Kind:    asset.Kind,
+			Name:    asset.Name,
+			URL:     fmt.Sprintf("%s,%s", storage.bucket, newAssetKey),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The chapters file was copied with the other assets
+	chapters, err := cfg.db.GetVideoChapters(video.ID)
+	if err != nil {
+		return "", err
+	}
+	err = cfg.db.ReplaceVideoChapters(newVideoID, chapters)
+	if err != nil {
+		return "", err
+	}
+
+	// The copy is the same file, so it has the same keyframes
+	keyframes, err := cfg.db.GetVideoKeyframes(video.ID)
+	if err != nil {
+		return "", err
+	}
+	if keyframes.VideoID != uuid.Nil {
+		keyframes.VideoID = newVideoID
+		err = cfg.db.UpsertVideoKeyframes(keyframes)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%s,%s", cfg.s3Bucket, newKey), nil
+}
+
+// Copies a file in the assets directory to a new random name and returns its URL
+func (cfg *apiConfig) copyLocalAsset(srcPath string) (string, error) {
+	randomString, err := generateRandomName()
+	if err != nil {
+		return "", err
+	}
+	filename := randomString + filepath.Ext(srcPath)
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filepath.Join(cfg.assetsRoot, filename))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Signs one CloudFront credential for everything stored under a video's asset
+// prefix (e.g. landscape/abc/* for landscape/abc.mp4), so a player can load all
+// the segments of a stream with it instead of needing a URL per segment. The
+// credential is returned both as query parameters to append to each URL and as
+// the values of CloudFront's signed cookies, which are also set when
+// HLS_COOKIE_DOMAIN puts this server and the distribution under one domain.
+func (cfg *apiConfig) handlerVideoHLSAuth(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		// The policy's resource, ending in a wildcard
+		Resource string `json:"resource"`
+		// Segment URLs start with this
+		URLPrefix string `json:"url_prefix"`
+		// Query string to append to every URL under the prefix
+		Query     string            `json:"query"`
+		Cookies   map[string]string `json:"cookies"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusNotFound, "Video has not been uploaded yet", nil)
+		return
+	}
+	if cfg.cloudFrontSigner == nil {
+		respondWithError(w, http.StatusNotImplemented, "Prefix credentials require CloudFront signing to be configured", nil)
+		return
+	}
+
+	bucket, key, _, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video location", err)
+		return
+	}
+	if bucket != cfg.s3Bucket {
+		respondWithError(w, http.StatusConflict, "Video isn't served through CloudFront", nil)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	prefix := assetPrefix(key) + "/"
+	urlPrefix := cfg.cdnURL(prefix, "")
+	expiresAt := time.Now().Add(signedURLExpiry)
+	query, err := cfg.cloudFrontSigner.signPolicy(urlPrefix+"*", signedURLExpiry, signOpts.clientIP)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to sign credentials", err)
+		return
+	}
+
+	cookies := map[string]string{
+		"CloudFront-Policy":      query.Get("Policy"),
+		"CloudFront-Signature":   query.Get("Signature"),
+		"CloudFront-Key-Pair-Id": query.Get("Key-Pair-Id"),
+	}
+	if cfg.hlsCookieDomain != "" {
+		for name, value := range cookies {
+			http.SetCookie(w, &http.Cookie{
+				Name:     name,
+				Value:    value,
+				Domain:   cfg.hlsCookieDomain,
+				Path:     "/" + prefix,
+				Expires:  expiresAt,
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: http.SameSiteNoneMode,
+			})
+		}
+	}
+
+	// Handing out a playable credential counts as a view
+	cfg.views.recordView(video.ID, viewerKey(r))
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		Resource:  urlPrefix + "*",
+		URLPrefix: urlPrefix,
+		Query:     query.Encode(),
+		Cookies:   cookies,
+		ExpiresAt: expiresAt.UTC(),
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Returns a video's keyframe timestamps. Videos stored before keyframes were
+// indexed (or whose profile skipped it) are indexed on first request.
+func (cfg *apiConfig) handlerVideoKeyframes(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	keyframes, err := cfg.db.GetVideoKeyframes(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get keyframes", err)
+		return
+	}
+	if keyframes.VideoID == uuid.Nil {
+		sourceURL, err := cfg.videoSourceURL(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't index keyframes", err)
+			return
+		}
+		keyframes, err = cfg.indexKeyframes(video.ID, sourceURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't index keyframes", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, keyframes)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type manifestEntry struct {
+	Name      string     `json:"name,omitempty"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Everything a player needs to start a video in one response: the video itself,
+// its thumbnail and a signed URL for every derived asset, grouped by kind
+// (rendition, sprite, thumbnail_track, chapters, ...). URLs come from the signed
+// URL cache like everywhere else.
+func (cfg *apiConfig) handlerVideoManifest(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID              uuid.UUID                  `json:"video_id"`
+		Video                *manifestEntry             `json:"video"`
+		ThumbnailURL         *string                    `json:"thumbnail_url"`
+		ThumbnailPlaceholder *string                    `json:"thumbnail_placeholder"`
+		ThumbnailColor       *string                    `json:"thumbnail_color"`
+		Assets               map[string][]manifestEntry `json:"assets"`
+		// When the first of the URLs expires, so the player knows when to ask again
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}
+
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	signOpts = cfg.signingOptionsForVideo(video, signOpts)
+
+	manifest := response{
+		VideoID:              video.ID,
+		ThumbnailURL:         video.ThumbnailURL,
+		ThumbnailPlaceholder: video.ThumbnailPlaceholder,
+		ThumbnailColor:       video.ThumbnailColor,
+		Assets:               map[string][]manifestEntry{},
+	}
+	sign := func(name, stored string) (manifestEntry, error) {
+		signed, err := cfg.signStoredURLWithExpiry(r.Context(), stored, signOpts)
+		if err != nil {
+			return manifestEntry{}, err
+		}
+		entry := manifestEntry{Name: name, URL: signed.url}
+		if !signed.expiresAt.IsZero() {
+			expiresAt := signed.expiresAt.UTC()
+			entry.ExpiresAt = &expiresAt
+			if manifest.ExpiresAt == nil || expiresAt.Before(*manifest.ExpiresAt) {
+				manifest.ExpiresAt = &expiresAt
+			}
+		}
+		return entry, nil
+	}
+
+	if video.VideoURL != nil && *video.VideoURL != "" {
+		entry, err := sign("", *video.VideoURL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+			return
+		}
+		manifest.Video = &entry
+	}
+
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video assets", err)
+		return
+	}
+	for _, asset := range assets {
+		// The track's sprite references are only signed when it's served
+		if asset.Kind == "thumbnail_track" {
+			entry := manifestEntry{Name: asset.Name, URL: cfg.thumbnailTrackURL(video.ID)}
+			manifest.Assets[asset.Kind] = append(manifest.Assets[asset.Kind], entry)
+			continue
+		}
+		entry, err := sign(asset.Name, asset.URL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+			return
+		}
+		manifest.Assets[asset.Kind] = append(manifest.Assets[asset.Kind], entry)
+	}
+
+	// Handing out a playable URL counts as a view
+	if manifest.Video != nil {
+		cfg.views.recordView(video.ID, viewerKey(r))
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	respondWithJSON(w, http.StatusOK, manifest)
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -22,7 +26,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -36,6 +40,10 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	params.UserID = userID
+	if params.Visibility != "" && !database.IsValidVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private or public", nil)
+		return
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
@@ -59,7 +67,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -75,91 +83,268 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	assets, err := cfg.db.GetVideoAssets(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video assets", err)
+		return
+	}
+
+	// Files go first, so a failure leaves the record in place to retry the delete
+	// with. Objects that are already gone don't count as failures.
+	deleted, err := cfg.deleteVideoFiles(r.Context(), video, assets)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video files", err)
+		return
+	}
+
 	err = cfg.db.DeleteVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.recordAudit(auditVideoDelete, userID, videoID, clientIP(r), map[string]any{
+		"title":     video.Title,
+		"video_url": video.VideoURL,
+	})
+	fmt.Printf("deleted video %s and %d stored objects\n", videoID, deleted)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Returns a video with signed URLs. Private and scheduled videos are only
+// returned to their owner; see authorizeVideoViewer.
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
+	fields, err := parseFieldsParam(r.URL.Query().Get("fields"), videoFieldNames)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
 	// Get video from database first
-	video, err := cfg.db.GetVideo(videoID)
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
+	signOpts.rendition = r.URL.Query().Get("rendition")
 
 	// Convert to signed video
-	signedVideo, err := cfg.dbVideoToSignedVideo(video)
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, signOpts)
+	if errors.Is(err, errRenditionNotFound) {
+		respondWithError(w, http.StatusNotFound, "Rendition not found", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, signedVideo)
+	// Handing out a playable URL counts as a view
+	if video.VideoURL != nil && *video.VideoURL != "" {
+		cfg.views.recordView(video.ID, viewerKey(r))
+	}
+
+	response, err := selectFields(signedVideo, fields)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to select fields", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
 }
 
+func (cfg *apiConfig) handlerVideoStats(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID   uuid.UUID `json:"video_id"`
+		ViewCount int64     `json:"view_count"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't view stats for this video", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		VideoID:   video.ID,
+		ViewCount: video.ViewCount + cfg.views.pendingViews(video.ID),
+	})
+}
+
+const (
+	defaultVideoPageSize = 50
+	maxVideoPageSize     = 200
+)
+
+// Lists the user's videos a page at a time (?limit=, ?offset=), along with how
+// many there are in total
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos     []interface{} `json:"videos"`
+		TotalCount int           `json:"total_count"`
+		Limit      int           `json:"limit"`
+		Offset     int           `json:"offset"`
+	}
+
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	// Get videos from database first
-	videos, err := cfg.db.GetVideos(userID)
+	fields, err := parseFieldsParam(r.URL.Query().Get("fields"), videoFieldNames)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	sort := database.VideoSort(r.URL.Query().Get("sort"))
+	if sort == "" {
+		sort = database.VideoSortCreatedAt
+	}
+	if sort != database.VideoSortCreatedAt && sort != database.VideoSortRecordedAt {
+		respondWithError(w, http.StatusBadRequest, "sort must be created_at or recorded_at", nil)
+		return
+	}
+
+	visibility := r.URL.Query().Get("visibility")
+	if visibility != "" && visibility != database.VisibilityScheduled && !database.IsValidVisibility(visibility) {
+		respondWithError(w, http.StatusBadRequest, "visibility must be private, public or scheduled", nil)
+		return
+	}
+
+	limit, offset := defaultVideoPageSize, 0
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		limit, err = strconv.Atoi(limitString)
+		if err != nil || limit <= 0 || limit > maxVideoPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxVideoPageSize), err)
+			return
+		}
+	}
+	if offsetString := r.URL.Query().Get("offset"); offsetString != "" {
+		offset, err = strconv.Atoi(offsetString)
+		if err != nil || offset < 0 {
+			respondWithError(w, http.StatusBadRequest, "offset must be a non-negative number", err)
+			return
+		}
+	}
+
+	// Let polling clients skip the download if nothing changed; see video_list_etag.go
+	version, err := cfg.db.GetVideoListVersion(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to get videos", err)
+		return
+	}
+	etag := videoListETag(userID, version, r.URL.RawQuery, signOpts)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagNoneMatchHit(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Get the page of videos from database first, so only those are signed
+	videos, totalCount, err := cfg.db.GetVideosPaginated(userID, sort, visibility, limit, offset)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to get videos", err)
 		return
 	}
 
 	// Convert each video to signed version
-	signedVideos := make([]database.Video, len(videos))
+	signedVideos := make([]interface{}, len(videos))
 	for i, video := range videos {
-		signedVideo, err := cfg.dbVideoToSignedVideo(video)
+		signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, signOpts)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URLs", err)
 			return
 		}
-		signedVideos[i] = signedVideo
+		signedVideos[i], err = selectFields(signedVideo, fields)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to select fields", err)
+			return
+		}
 	}
 	
-	respondWithJSON(w, http.StatusOK, signedVideos)
+	respondWithJSON(w, http.StatusOK, response{
+		Videos:     signedVideos,
+		TotalCount: totalCount,
+		Limit:      limit,
+		Offset:     offset,
+	})
 }
 
 
-func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
+func (cfg *apiConfig) dbVideoToSignedVideo(ctx context.Context, video database.Video, opts signingOptions) (database.Video, error) {
+	opts = cfg.signingOptionsForVideo(video, opts)
+
+	// Sign derived assets (sprite sheets, thumbnail tracks, ...) stored alongside the video
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		return video, err
+	}
+	assets, err = selectRenditions(assets, opts.rendition)
+	if err != nil {
+		return video, err
+	}
+	for i := range assets {
+		// The track's sprite references are only signed when it's served
+		if assets[i].Kind == "thumbnail_track" {
+			assets[i].URL = cfg.thumbnailTrackURL(video.ID)
+			continue
+		}
+		assets[i].URL, err = cfg.signStoredURL(ctx, assets[i].URL, opts)
+		if err != nil {
+			return video, err
+		}
+	}
+	if len(assets) > 0 {
+		video.Assets = assets
+	}
+
 	// If no video URL, return as-is
 	if video.VideoURL == nil || *video.VideoURL == "" {
 		return video, nil
 	}
 	
-	// Split bucket and key from stored string
-	parts := strings.Split(*video.VideoURL, ",")
-	if len(parts) != 2 {
-		return video, fmt.Errorf("invalid bucket/key format: %s", *video.VideoURL)
-	}
-	
-	bucket := parts[0]
-	key := parts[1]
-	
-	// Generate presigned URL (15 minutes expiry)
-	presignedURL, err := generatePresignedURL(cfg.s3Client, bucket, key, 15*time.Minute)
+	presignedURL, err := cfg.signStoredURL(ctx, *video.VideoURL, opts)
 	if err != nil {
 		return video, err
 	}
@@ -167,4 +352,123 @@ func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video
 	// Update video with presigned URL
 	video.VideoURL = &presignedURL
 	return video, nil
-}
\ No newline at end of file
+}
+
+// Turns a stored "bucket,key" reference into a presigned URL. Objects in our bucket
+// are signed through CloudFront when a key pair is configured (or not signed at
+// all when opts.unsigned is set), everything else with an S3 presign.
+func (cfg *apiConfig) signStoredURL(ctx context.Context, stored string, opts signingOptions) (string, error) {
+	signed, err := cfg.signStoredURLWithExpiry(ctx, stored, opts)
+	return signed.url, err
+}
+
+// Like signStoredURL, but also returns when the URL expires (zero for unsigned
+// URLs, which don't). Signed URLs come from cfg.signedURLs while they have enough
+// life left.
+func (cfg *apiConfig) signStoredURLWithExpiry(ctx context.Context, stored string, opts signingOptions) (signedURL, error) {
+	bucket, key, versionID, err := parseStoredObject(stored)
+	if err != nil {
+		return signedURL{}, err
+	}
+
+	if opts.unsigned && bucket == cfg.s3Bucket {
+		return signedURL{url: cfg.cdnURL(key, versionID)}, nil
+	}
+
+	cacheKey := signedURLCacheKey{stored: stored, clientIP: opts.clientIP}
+	if cached, ok := cfg.signedURLs.get(cacheKey); ok {
+		return cached, nil
+	}
+
+	// Objects in an owner's own bucket are signed with that bucket's credentials
+	storage := cfg.defaultStorage()
+	if opts.owner != uuid.Nil {
+		storage, err = cfg.storageForObject(opts.owner, bucket)
+		if err != nil {
+			return signedURL{}, err
+		}
+	}
+
+	signed := signedURL{expiresAt: time.Now().Add(signedURLExpiry)}
+	if cfg.cloudFrontSigner != nil && bucket == cfg.s3Bucket {
+		resourceURL := cfg.cdnURL(key, versionID)
+		signed.url, err = cfg.cloudFrontSigner.signURL(resourceURL, signedURLExpiry, opts.clientIP)
+	} else if opts.clientIP != "" {
+		return signedURL{}, fmt.Errorf("can't bind %s to a client IP without CloudFront signing", key)
+	} else {
+		var expiry time.Duration
+		signed.url, expiry, err = generatePresignedURL(ctx, storage.presign, bucket, key, versionID, signedURLExpiry)
+		signed.expiresAt = time.Now().Add(expiry)
+	}
+	if err != nil {
+		return signedURL{}, err
+	}
+
+	cfg.signedURLs.put(cacheKey, signed)
+	return signed, nil
+}
+
+// Restrictions applied when signing URLs for a request
+type signingOptions struct {
+	// Only allow the URL to be used from this IP address (CloudFront signing only)
+	clientIP string
+	// Which renditions to sign; see selectRenditions
+	rendition string
+	// Return plain CDN URLs for objects in our bucket instead of signing them
+	unsigned bool
+	// Whose videos are being signed, to find their own bucket's credentials
+	owner uuid.UUID
+}
+
+// Works out the signing restrictions for a request. URLs are bound to the client's
+// IP when configured globally or when the client asks for it with ?bind_ip=true.
+func (cfg *apiConfig) signingOptionsFor(r *http.Request) (signingOptions, error) {
+	if !cfg.bindSignedURLsToIP && r.URL.Query().Get("bind_ip") != "true" {
+		return signingOptions{}, nil
+	}
+	if cfg.cloudFrontSigner == nil {
+		return signingOptions{}, errors.New("IP-bound URLs require CloudFront signing to be configured")
+	}
+	return signingOptions{clientIP: clientIP(r)}, nil
+}
+
+// Adds the signing options that depend on the video itself. Public videos can be
+// served from the CDN without a signature, unless the client asked for a URL
+// bound to its IP.
+func (cfg *apiConfig) signingOptionsForVideo(video database.Video, opts signingOptions) signingOptions {
+	opts.owner = video.UserID
+	if cfg.cleanPublicURLs && video.Visibility == database.VisibilityPublic && opts.clientIP == "" {
+		opts.unsigned = true
+	}
+	return opts
+}
+
+// The URL of key on the CloudFront distribution
+func (cfg *apiConfig) cdnURL(key, versionID string) string {
+	resourceURL := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key)
+	if versionID != "" {
+		// Only honored if the distribution forwards the versionId query string to S3
+		resourceURL += "?versionId=" + url.QueryEscape(versionID)
+	}
+	return resourceURL
+}
+
+// Splits a stored "bucket,key" reference
+func parseStoredURL(stored string) (string, string, error) {
+	bucket, key, _, err := parseStoredObject(stored)
+	return bucket, key, err
+}
+
+// Like parseStoredURL, but also returns the S3 version ID pinned by
+// "bucket,key,versionID" references (empty for unversioned references)
+func parseStoredObject(stored string) (string, string, string, error) {
+	parts := strings.Split(stored, ",")
+	if len(parts) != 2 && len(parts) != 3 {
+		return "", "", "", fmt.Errorf("invalid bucket/key format: %s", stored)
+	}
+	versionID := ""
+	if len(parts) == 3 {
+		versionID = parts[2]
+	}
+	return parts[0], parts[1], versionID, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// A full page of the list endpoint, with every video URL signed on each request
+func BenchmarkVideosRetrieve(b *testing.B) {
+	cfg := newTestAPIConfig(b)
+	cfg.signedURLs = newSignedURLCache(0)
+
+	first, token := createTestVideo(b, cfg, database.VisibilityPrivate)
+	for i := 1; i < defaultVideoPageSize; i++ {
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+			Title:  fmt.Sprintf("Test video %d", i),
+			UserID: first.UserID,
+		})
+		if err != nil {
+			b.Fatalf("couldn't create video: %v", err)
+		}
+		videoURL := testBucket + ",landscape/" + video.ID.String() + ".mp4"
+		video.VideoURL = &videoURL
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			b.Fatalf("couldn't update video: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/videos", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		cfg.handlerVideosRetrieve(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("got status %d: %s", rec.Code, rec.Body)
+		}
+	}
+}
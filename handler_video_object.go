@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// Describes the S3 object holding a video, including the language and metadata
+// it was uploaded with
+func (cfg *apiConfig) handlerVideoObject(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ContentType     string            `json:"content_type"`
+		ContentLength   int64             `json:"content_length"`
+		ContentLanguage string            `json:"content_language,omitempty"`
+		Metadata        map[string]string `json:"metadata"`
+		ETag            string            `json:"etag"`
+		VersionID       string            `json:"version_id,omitempty"`
+		LastModified    *time.Time        `json:"last_modified,omitempty"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video location", err)
+		return
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+	output, err := storage.client.HeadObject(r.Context(), input)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't get video object", err)
+		return
+	}
+
+	metadata := output.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		ContentType:     aws.ToString(output.ContentType),
+		ContentLength:   aws.ToInt64(output.ContentLength),
+		ContentLanguage: aws.ToString(output.ContentLanguage),
+		Metadata:        metadata,
+		ETag:            aws.ToString(output.ETag),
+		VersionID:       aws.ToString(output.VersionId),
+		LastModified:    output.LastModified,
+	})
+}
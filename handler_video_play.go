@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+/*
+Playback
+
+GET /videos/{videoID}/play plays a video, however the deployment can reach S3.
+In redirect mode (VIDEO_DELIVERY=redirect, the default) it answers with a 302 to
+a signed URL, like GET /videos/{videoID} hands out. In proxy mode the server
+fetches the object from S3 itself and streams it to the client, passing Range
+requests through so players can seek, for networks where clients can't reach S3
+or CloudFront directly. Proxied responses always carry the exact Content-Length
+of what's sent, which players and download managers rely on for buffering and
+progress; HEAD requests get the same headers from a HeadObject without fetching
+any of the video.
+*/
+
+const (
+	videoDeliveryRedirect = "redirect"
+	videoDeliveryProxy    = "proxy"
+)
+
+func isValidVideoDelivery(mode string) bool {
+	return mode == videoDeliveryRedirect || mode == videoDeliveryProxy
+}
+
+// Response headers of the S3 object that are passed on when proxying
+var proxiedObjectHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// What S3 said about a proxied object, from GetObject or HeadObject
+type proxiedObject struct {
+	contentType   *string
+	contentLength *int64
+	contentRange  *string
+	acceptRanges  *string
+	etag          *string
+	lastModified  *time.Time
+}
+
+// How many bytes the response carries: S3's Content-Length, or the size of the
+// range in Content-Range if it didn't send one
+func (o proxiedObject) length() (int64, bool) {
+	if o.contentLength != nil {
+		return *o.contentLength, true
+	}
+	// "bytes 0-1023/146515"
+	var first, last int64
+	var total string
+	_, err := fmt.Sscanf(aws.ToString(o.contentRange), "bytes %d-%d/%s", &first, &last, &total)
+	if err != nil || last < first {
+		return 0, false
+	}
+	return last - first + 1, true
+}
+
+// Sets the proxied response's headers and returns its status. Ranges are always
+// advertised, since requests for them are passed on to S3.
+func (o proxiedObject) writeHeaders(w http.ResponseWriter) int {
+	header := map[string]string{
+		"Content-Type":  aws.ToString(o.contentType),
+		"Content-Range": aws.ToString(o.contentRange),
+		"Accept-Ranges": aws.ToString(o.acceptRanges),
+		"ETag":          aws.ToString(o.etag),
+	}
+	if header["Accept-Ranges"] == "" {
+		header["Accept-Ranges"] = "bytes"
+	}
+	if length, ok := o.length(); ok {
+		header["Content-Length"] = strconv.FormatInt(length, 10)
+	}
+	if o.lastModified != nil {
+		header["Last-Modified"] = o.lastModified.UTC().Format(http.TimeFormat)
+	}
+	for _, name := range proxiedObjectHeaders {
+		if header[name] != "" {
+			w.Header().Set(name, header[name])
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if o.contentRange != nil {
+		return http.StatusPartialContent
+	}
+	return http.StatusOK
+}
+
+// Whether S3 refused a request's Range. HEAD responses have no body to carry an
+// error code, so the status is all there is to go on.
+func isInvalidRange(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+		return true
+	}
+	var responseErr *awshttp.ResponseError
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable
+}
+
+func (cfg *apiConfig) handlerVideoPlay(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusNotFound, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	if cfg.videoDelivery != videoDeliveryProxy {
+		signOpts, err := cfg.signingOptionsFor(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		videoURL, err := cfg.signStoredURL(r.Context(), *video.VideoURL, cfg.signingOptionsForVideo(video, signOpts))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+			return
+		}
+		cfg.views.recordView(video.ID, viewerKey(r))
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, videoURL, http.StatusFound)
+		return
+	}
+
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video location", err)
+		return
+	}
+	var rangeHeader *string
+	if value := r.Header.Get("Range"); value != "" {
+		rangeHeader = aws.String(value)
+	}
+	var versionIDParam *string
+	if versionID != "" {
+		versionIDParam = aws.String(versionID)
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+	headObject := func() (*s3.HeadObjectOutput, error) {
+		return storage.client.HeadObject(r.Context(), &s3.HeadObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: versionIDParam,
+			Range:     rangeHeader,
+		})
+	}
+
+	if r.Method == http.MethodHead {
+		output, err := headObject()
+		if isInvalidRange(err) {
+			respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Range not satisfiable", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't get video object", err)
+			return
+		}
+		object := proxiedObject{
+			contentType:   output.ContentType,
+			contentLength: output.ContentLength,
+			contentRange:  output.ContentRange,
+			acceptRanges:  output.AcceptRanges,
+			etag:          output.ETag,
+			lastModified:  output.LastModified,
+		}
+		w.WriteHeader(object.writeHeaders(w))
+		return
+	}
+
+	output, err := storage.client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: versionIDParam,
+		Range:     rangeHeader,
+	})
+	if isInvalidRange(err) {
+		respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Range not satisfiable", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't get video object", err)
+		return
+	}
+	defer output.Body.Close()
+
+	object := proxiedObject{
+		contentType:   output.ContentType,
+		contentLength: output.ContentLength,
+		contentRange:  output.ContentRange,
+		acceptRanges:  output.AcceptRanges,
+		etag:          output.ETag,
+		lastModified:  output.LastModified,
+	}
+	if _, ok := object.length(); !ok {
+		// Without a length the response would be chunked, and players couldn't
+		// tell how much is coming; the object's metadata has it
+		head, err := headObject()
+		if err != nil {
+			log.Printf("Couldn't get the size of video %s: %v", video.ID, err)
+		} else {
+			object.contentLength = head.ContentLength
+			object.contentRange = head.ContentRange
+		}
+	}
+	status := object.writeHeaders(w)
+	cfg.views.recordView(video.ID, viewerKey(r))
+
+	w.WriteHeader(status)
+	_, err = io.Copy(w, output.Body)
+	if err != nil {
+		// Headers are out, so all that's left is to log it
+		log.Printf("Proxying video %s stopped: %v", video.ID, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Called by the player just before playback. Checks the video object exists and
+// signs its URLs (the video's and its assets') into the signed URL cache, so the
+// GET /videos/{videoID} that follows doesn't wait on signing. Like that GET, it
+// only needs authentication for videos that aren't public, and doesn't count as
+// a view.
+func (cfg *apiConfig) handlerVideoPrepare(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL       string     `json:"url"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}
+
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video location", err)
+		return
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+	_, err = storage.client.HeadObject(r.Context(), input)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't find video object", err)
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	signOpts.rendition = r.URL.Query().Get("rendition")
+
+	// Signs everything the GET will hand out, leaving it in the cache
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, signOpts)
+	if errors.Is(err, errRenditionNotFound) {
+		respondWithError(w, http.StatusNotFound, "Rendition not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+
+	// Hits the cache; we only need the expiry
+	signed, err := cfg.signStoredURLWithExpiry(r.Context(), *video.VideoURL, cfg.signingOptionsForVideo(video, signOpts))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+	resp := response{URL: *signedVideo.VideoURL}
+	// Unsigned CDN URLs don't expire
+	if !signed.expiresAt.IsZero() {
+		resp.ExpiresAt = &signed.expiresAt
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Returns ffprobe's full format and stream information for a video's stored
+// file, for its owner or an admin. ffprobe reads the file over a presigned URL,
+// fetching only the parts it needs. The result is kept until the file is
+// replaced.
+func (cfg *apiConfig) handlerVideoProbe(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID  uuid.UUID       `json:"video_id"`
+		ProbedAt time.Time       `json:"probed_at"`
+		Probe    json.RawMessage `json:"probe"`
+	}
+
+	video, ok := cfg.authorizeVideoOwnerOrAdmin(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	probe, err := cfg.db.GetVideoProbe(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get probe", err)
+		return
+	}
+	if probe.VideoURL != *video.VideoURL {
+		sourceURL, err := cfg.videoSourceURL(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't probe video", err)
+			return
+		}
+		output, err := probeVideoJSON(sourceURL)
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, "Couldn't probe video", err)
+			return
+		}
+		if !json.Valid(output) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't probe video", errors.New("ffprobe printed invalid JSON"))
+			return
+		}
+		probe = database.VideoProbe{
+			VideoID:   video.ID,
+			VideoURL:  *video.VideoURL,
+			Probe:     string(output),
+			CreatedAt: time.Now(),
+		}
+		err = cfg.db.UpsertVideoProbe(probe)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save probe", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		VideoID:  video.ID,
+		ProbedAt: probe.CreatedAt.UTC(),
+		Probe:    json.RawMessage(probe.Probe),
+	})
+}
+
+// Like authorizeVideoOwner, but also lets in requests with the admin API key
+func (cfg *apiConfig) authorizeVideoOwnerOrAdmin(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+		return cfg.authorizeVideoOwner(w, r)
+	}
+	if !cfg.requireAdmin(w, r) {
+		return database.Video{}, false
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return database.Video{}, false
+	}
+	return video, true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Lists videos that look like the owner's video, closest first: other videos of
+// theirs and public videos whose perceptual hash is within
+// SIMILAR_VIDEO_MAX_DISTANCE of its own. ?max_distance= can narrow that down.
+func (cfg *apiConfig) handlerVideoSimilar(w http.ResponseWriter, r *http.Request) {
+	type similarVideo struct {
+		VideoID      uuid.UUID `json:"video_id"`
+		Title        string    `json:"title"`
+		UserID       uuid.UUID `json:"user_id"`
+		ThumbnailURL *string   `json:"thumbnail_url"`
+		// Bits the perceptual hashes differ in, out of maxPerceptualHashDistance
+		Distance int `json:"distance"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if video.PerceptualHash == nil {
+		respondWithError(w, http.StatusConflict, "Video has no perceptual hash", nil)
+		return
+	}
+
+	maxDistance := cfg.similarVideoMaxDistance
+	if value := r.URL.Query().Get("max_distance"); value != "" {
+		distance, err := strconv.Atoi(value)
+		if err != nil || distance < 0 || distance > cfg.similarVideoMaxDistance {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("max_distance must be between 0 and %d", cfg.similarVideoMaxDistance), err)
+			return
+		}
+		maxDistance = distance
+	}
+
+	candidates, err := cfg.db.GetVideosWithPerceptualHash()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	similar := []similarVideo{}
+	for _, candidate := range candidates {
+		if candidate.ID == video.ID {
+			continue
+		}
+		if candidate.UserID != video.UserID && candidate.Visibility != database.VisibilityPublic {
+			continue
+		}
+		distance, err := perceptualHashDistance(*video.PerceptualHash, *candidate.PerceptualHash)
+		if err != nil {
+			log.Printf("Couldn't compare video %s with %s: %v", video.ID, candidate.ID, err)
+			continue
+		}
+		if distance > maxDistance {
+			continue
+		}
+		similar = append(similar, similarVideo{
+			VideoID:      candidate.ID,
+			Title:        candidate.Title,
+			UserID:       candidate.UserID,
+			ThumbnailURL: candidate.ThumbnailURL,
+			Distance:     distance,
+		})
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+
+	respondWithJSON(w, http.StatusOK, similar)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+/*
+Thumbnail track
+
+The stored thumbnail track names its sprite sheets relative to itself (see
+sprite_utils.go). A player resolves those names against the URL it loaded the
+track from, which for a presigned S3 URL drops the signature in its query string,
+so the sprites of a private bucket would be refused. GET
+/videos/{videoID}/thumbnails.vtt serves the track with every sprite reference
+replaced by that sprite's own signed URL, and is the URL handed out for the
+thumbnail_track asset in place of the stored file's.
+*/
+
+// Where the thumbnail track of a video is served from
+func (cfg *apiConfig) thumbnailTrackURL(videoID uuid.UUID) string {
+	return fmt.Sprintf("http://localhost:%s/api/%s/videos/%s/thumbnails.vtt", cfg.port, apiVersion, videoID)
+}
+
+func (cfg *apiConfig) handlerVideoThumbnailTrack(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+
+	signOpts, err := cfg.signingOptionsFor(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	signOpts = cfg.signingOptionsForVideo(video, signOpts)
+
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video assets", err)
+		return
+	}
+	track := ""
+	sprites := make(map[string]string)
+	for _, asset := range assets {
+		switch asset.Kind {
+		case "thumbnail_track":
+			track = asset.URL
+		case "sprite":
+			sprites[asset.Name] = asset.URL
+		}
+	}
+	if track == "" {
+		respondWithError(w, http.StatusNotFound, "Video has no thumbnail track", nil)
+		return
+	}
+
+	bucket, key, err := parseStoredURL(track)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read thumbnail track location", err)
+		return
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+	output, err := storage.client.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't get thumbnail track", err)
+		return
+	}
+	defer output.Body.Close()
+	stored, err := io.ReadAll(output.Body)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't get thumbnail track", err)
+		return
+	}
+
+	signed, err := cfg.signThumbnailTrack(r.Context(), string(stored), sprites, signOpts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+
+	// The sprite URLs in it expire
+	w.Header().Set("Content-Type", "text/vtt")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, signed)
+}
+
+// Replaces the sprite sheet each cue of a thumbnail track points at with its
+// signed URL. sprites maps the names the track uses to their stored references;
+// names that aren't in it are left as they are.
+func (cfg *apiConfig) signThumbnailTrack(ctx context.Context, track string, sprites map[string]string, opts signingOptions) (string, error) {
+	signedURLs := make(map[string]string)
+	lines := strings.Split(track, "\n")
+	for i, line := range lines {
+		// "sprite_001.jpg#xywh=160,0,160,90"
+		name, fragment, ok := strings.Cut(line, "#xywh=")
+		stored, known := sprites[name]
+		if !ok || !known {
+			continue
+		}
+		signed, ok := signedURLs[name]
+		if !ok {
+			var err error
+			signed, err = cfg.signStoredURL(ctx, stored, opts)
+			if err != nil {
+				return "", err
+			}
+			signedURLs[name] = signed
+		}
+		lines[i] = signed + "#xywh=" + fragment
+	}
+	return strings.Join(lines, "\n"), nil
+}
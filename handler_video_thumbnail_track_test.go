@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSignThumbnailTrack(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	track := "WEBVTT\n\n00:00:00.000 --> 00:00:10.000\nsprite_001.jpg#xywh=0,0,160,90\n\n" +
+		"00:00:10.000 --> 00:00:20.000\nsprite_001.jpg#xywh=160,0,160,90\n\n" +
+		"00:00:20.000 --> 00:00:30.000\nunknown.jpg#xywh=0,0,160,90\n"
+	sprites := map[string]string{"sprite_001.jpg": testBucket + ",landscape/abc/sprite_001.jpg"}
+
+	signed, err := cfg.signThumbnailTrack(context.Background(), track, sprites, signingOptions{})
+	if err != nil {
+		t.Fatalf("couldn't sign track: %v", err)
+	}
+	spriteURL, err := cfg.signStoredURL(context.Background(), sprites["sprite_001.jpg"], signingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		spriteURL + "#xywh=0,0,160,90\n",
+		spriteURL + "#xywh=160,0,160,90\n",
+		"\nunknown.jpg#xywh=0,0,160,90\n",
+	} {
+		if !strings.Contains(signed, want) {
+			t.Errorf("signed track doesn't contain %q:\n%s", want, signed)
+		}
+	}
+	if !strings.Contains(spriteURL, "X-Amz-Signature=") {
+		t.Errorf("sprite URL %q isn't presigned", spriteURL)
+	}
+}
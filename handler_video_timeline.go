@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// The user's videos grouped by ?group=day or month (the default), newest first,
+// with how many videos each group has and a thumbnail to show for it. ?date=
+// picks what videos are placed by: recorded_at (the default, falling back to
+// created_at) or created_at.
+func (cfg *apiConfig) handlerVideoTimeline(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	interval := database.TimelineInterval(r.URL.Query().Get("group"))
+	if interval == "" {
+		interval = database.TimelineMonth
+	}
+	if interval != database.TimelineDay && interval != database.TimelineMonth {
+		respondWithError(w, http.StatusBadRequest, "group must be day or month", nil)
+		return
+	}
+
+	date := database.VideoSort(r.URL.Query().Get("date"))
+	if date == "" {
+		date = database.VideoSortRecordedAt
+	}
+	if date != database.VideoSortCreatedAt && date != database.VideoSortRecordedAt {
+		respondWithError(w, http.StatusBadRequest, "date must be created_at or recorded_at", nil)
+		return
+	}
+
+	groups, err := cfg.db.GetVideoTimeline(userID, interval, date)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get timeline", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, groups)
+}
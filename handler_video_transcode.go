@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Streams the stored video transcoded to ?format= (see streamFormats). Like
+// playback, private videos are only streamed to their owner, who's checked
+// before any transcode capacity is taken.
+func (cfg *apiConfig) handlerVideoTranscode(w http.ResponseWriter, r *http.Request) {
+	formatName := r.URL.Query().Get("format")
+	format, ok := streamFormats[formatName]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "format must be one of "+strings.Join(streamFormatNames(), ", "), nil)
+		return
+	}
+
+	video, ok := cfg.authorizeVideoViewer(w, r)
+	if !ok {
+		return
+	}
+	videoID := video.ID
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video has not been uploaded yet", nil)
+		return
+	}
+
+	select {
+	case cfg.streamTranscodeSlots <- struct{}{}:
+		defer func() { <-cfg.streamTranscodeSlots }()
+	default:
+		w.Header().Set("Retry-After", "30")
+		respondWithError(w, http.StatusServiceUnavailable, "Too many transcodes in progress, try again later", nil)
+		return
+	}
+
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video location", err)
+		return
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video storage", err)
+		return
+	}
+	// ffmpeg reads the source as it goes, so the URL has to outlive the transcode
+	source, _, err := generatePresignedURL(r.Context(), storage.presign, bucket, key, versionID, cfg.streamTranscodeTimeout+time.Minute)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.streamTranscodeTimeout)
+	defer cancel()
+
+	args := append([]string{"-v", "error", "-i", source}, format.args...)
+	args = append(args, "pipe:1")
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Waiting for a free ffmpeg slot counts against the timeout
+	release, err := acquireFFmpegSlot(ctx)
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "Too many videos are being processed, try again later", err)
+		return
+	}
+	defer release()
+
+	rc := http.NewResponseController(w)
+	// The server's write timeout is meant for regular responses
+	err = rc.SetWriteDeadline(time.Now().Add(cfg.streamTranscodeTimeout))
+	if err != nil {
+		log.Printf("Transcode of video %s may be cut off at the server's write timeout: %v", videoID, err)
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	out := &streamWriter{w: w, rc: rc}
+	cmd.Stdout = out
+
+	err = cmd.Run()
+	if err == nil {
+		return
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", cfg.streamTranscodeTimeout)
+	}
+	if output := strings.TrimSpace(stderr.String()); output != "" {
+		err = fmt.Errorf("%w\n%s", err, output)
+	}
+	if out.written == 0 {
+		respondWithError(w, http.StatusInternalServerError, "Failed to transcode video", err)
+		return
+	}
+	// Headers are long gone; all we can do is cut the stream short
+	if r.Context().Err() == nil {
+		log.Printf("Transcode of video %s to %s failed after %d bytes: %v", videoID, formatName, out.written, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Makes a video public or private, or schedules it to become public at
+// publish_at. Scheduling again moves the publish time; making it public
+// publishes it straight away.
+func (cfg *apiConfig) handlerVideoVisibility(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Visibility string `json:"visibility"`
+		// Required for VisibilityScheduled
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	err = validateVisibilityChange(params.Visibility, params.PublishAt)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User is not the video owner", nil)
+		return
+	}
+
+	video, err = cfg.setVideoVisibility(video, params.Visibility, params.PublishAt, clientIP(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video, signingOptions{})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate signed URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// Most videos a single batch visibility request may list
+const maxVisibilityBatchSize = 500
+
+// Changes the visibility of many videos at once, each as PUT
+// /videos/{videoID}/visibility would. Videos the user doesn't own or that don't
+// exist are skipped; the response says what happened to each one.
+func (cfg *apiConfig) handlerVideosVisibility(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs   []uuid.UUID `json:"video_ids"`
+		Visibility string      `json:"visibility"`
+		PublishAt  *time.Time  `json:"publish_at"`
+	}
+	type result struct {
+		VideoID     uuid.UUID  `json:"video_id"`
+		Status      string     `json:"status"`
+		Error       string     `json:"error,omitempty"`
+		Visibility  string     `json:"visibility,omitempty"`
+		PublishedAt *time.Time `json:"published_at,omitempty"`
+	}
+	type response struct {
+		Results []result `json:"results"`
+		Updated int      `json:"updated"`
+		Failed  int      `json:"failed"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.VideoIDs) == 0 || len(params.VideoIDs) > maxVisibilityBatchSize {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("video_ids must list between 1 and %d videos", maxVisibilityBatchSize), nil)
+		return
+	}
+	err = validateVisibilityChange(params.Visibility, params.PublishAt)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	resp := response{Results: make([]result, 0, len(params.VideoIDs))}
+	seen := make(map[uuid.UUID]bool, len(params.VideoIDs))
+	for _, videoID := range params.VideoIDs {
+		if seen[videoID] {
+			continue
+		}
+		seen[videoID] = true
+
+		res := result{VideoID: videoID}
+		video, err := cfg.db.GetVideo(videoID)
+		switch {
+		case err != nil:
+			res.Status, res.Error = "failed", "Couldn't get video"
+		case video.ID == uuid.Nil:
+			res.Status, res.Error = "not_found", "Video not found"
+		case video.UserID != userID:
+			res.Status, res.Error = "forbidden", "User is not the video owner"
+		default:
+			video, err = cfg.setVideoVisibility(video, params.Visibility, params.PublishAt, clientIP(r))
+			if err != nil {
+				res.Status, res.Error = "failed", "Couldn't update video"
+				break
+			}
+			res.Status = "updated"
+			res.Visibility = video.Visibility
+			res.PublishedAt = video.PublishedAt
+		}
+		if err != nil {
+			log.Printf("Couldn't change visibility of video %s: %v", videoID, err)
+		}
+		if res.Status == "updated" {
+			resp.Updated++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, res)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// Checks a requested visibility and the publish time that goes with it
+func validateVisibilityChange(visibility string, publishAt *time.Time) error {
+	scheduled := visibility == database.VisibilityScheduled
+	if !scheduled && !database.IsValidVisibility(visibility) {
+		return errors.New("Visibility must be private, public or scheduled")
+	}
+	if scheduled && (publishAt == nil || !publishAt.After(time.Now())) {
+		return errors.New("Scheduled videos need a publish_at in the future")
+	}
+	if !scheduled && publishAt != nil {
+		return errors.New("publish_at is only for scheduled videos")
+	}
+	return nil
+}
+
+// Applies a validated visibility change to a video, saves it and records it in
+// the audit log
+func (cfg *apiConfig) setVideoVisibility(video database.Video, visibility string, publishAt *time.Time, clientIP string) (database.Video, error) {
+	previousVisibility := video.Visibility
+	switch visibility {
+	case database.VisibilityScheduled:
+		scheduledAt := publishAt.UTC()
+		video.PublishedAt = &scheduledAt
+	case database.VisibilityPublic:
+		// Keep the original publish time of a video that's already public
+		if video.Visibility != database.VisibilityPublic || video.PublishedAt == nil {
+			now := time.Now().UTC()
+			video.PublishedAt = &now
+		}
+	default:
+		video.PublishedAt = nil
+	}
+	video.Visibility = visibility
+	video.UpdatedAt = time.Now()
+	err := cfg.db.UpdateVideo(video)
+	if err != nil {
+		return database.Video{}, err
+	}
+	cfg.recordAudit(auditVideoVisibility, video.UserID, video.ID, clientIP, map[string]any{
+		"from":         previousVisibility,
+		"to":           video.Visibility,
+		"published_at": video.PublishedAt,
+	})
+	return video, nil
+}
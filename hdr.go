@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+/*
+HDR videos
+
+Phones record HDR (PQ or HLG transfer, BT.2020 primaries) by default, and most
+screens and browsers that can't display it show it washed out. Uploads are
+checked for HDR by the transfer characteristic ffprobe reports, and
+HDR_HANDLING decides what happens to HDR uploads:
+
+- "flag" (the default) stores them as they are, marked hdr on the video
+- "reject" refuses them
+- "tonemap" converts them to SDR (BT.709) with ffmpeg's zscale and tonemap
+  filters before they're stored; ffmpeg has to be built with zimg for that
+
+Every video records the color characteristics of its stored file.
+*/
+
+const (
+	hdrHandlingFlag    = "flag"
+	hdrHandlingReject  = "reject"
+	hdrHandlingTonemap = "tonemap"
+)
+
+func isValidHDRHandling(mode string) bool {
+	return mode == hdrHandlingFlag || mode == hdrHandlingReject || mode == hdrHandlingTonemap
+}
+
+// Color characteristics of a video stream, as ffprobe names them. Empty when
+// the file doesn't say.
+type videoColorInfo struct {
+	primaries string
+	transfer  string
+	space     string
+}
+
+// PQ (HDR10, Dolby Vision) and HLG are the HDR transfer characteristics
+func (c videoColorInfo) isHDR() bool {
+	return c.transfer == "smpte2084" || c.transfer == "arib-std-b67"
+}
+
+// Returns the color characteristics of the main video stream
+func getVideoColorInfo(filePath string) (videoColorInfo, error) {
+	probeOutput, err := probeVideo(filePath)
+	if err != nil {
+		return videoColorInfo{}, err
+	}
+	stream, ok := probeOutput.mainVideoStream()
+	if !ok {
+		return videoColorInfo{}, nil
+	}
+	return videoColorInfo{
+		primaries: stream.ColorPrimaries,
+		transfer:  stream.ColorTransfer,
+		space:     stream.ColorSpace,
+	}, nil
+}
+
+// Re-encodes an HDR video as SDR BT.709: linearize, map the highlights down
+// with the Hable curve, convert the primaries and encode as H.264
+func tonemapToSDR(inputPath string) (string, error) {
+	outputPath := inputPath + ".tonemapped"
+
+	cmd := exec.Command("ffmpeg",
+		"-i", inputPath,
+		"-vf", "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-color_primaries", "bt709",
+		"-color_trc", "bt709",
+		"-colorspace", "bt709",
+		"-c:a", "copy",
+		"-f", "mp4",
+		outputPath,
+	)
+
+	err := runCommand(cmd)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg tone mapping failed: %w", err)
+	}
+
+	return outputPath, nil
+}
+
+// For storing color characteristics the file didn't state as NULL
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+/*
+Thumbnail sanitizing
+
+Images straight from a phone carry EXIF metadata: GPS coordinates, device
+model, timestamps. Decoding and re-encoding keeps only the pixels, which drops
+all of it. EXIF is also where the camera records how the image should be
+rotated, so that orientation is applied to the pixels first; otherwise the
+re-encoded thumbnail would come out sideways.
+*/
+
+// Decodes a JPEG or PNG, bakes in its EXIF orientation, fits it to shape and
+// writes it back out in the same format without any metadata. JPEGs come out
+// progressive if asked; see progressive_jpeg.go.
+func sanitizeImage(r io.Reader, w io.Writer, mediaType string, shape thumbnailShape, progressive bool) error {
+	dat, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(dat))
+		if err != nil {
+			return fmt.Errorf("failed to decode JPEG: %w", err)
+		}
+		img = applyOrientation(img, jpegOrientation(dat))
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, shape.apply(img), &jpeg.Options{Quality: 90})
+		if err != nil {
+			return err
+		}
+		out := buf.Bytes()
+		if progressive {
+			out = progressiveJPEG(out)
+		}
+		_, err = w.Write(out)
+		return err
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(dat))
+		if err != nil {
+			return fmt.Errorf("failed to decode PNG: %w", err)
+		}
+		return png.Encode(w, shape.apply(img))
+	}
+	return fmt.Errorf("unsupported image type %s", mediaType)
+}
+
+// Reads the EXIF orientation (1-8) from a JPEG's APP1 segment. Returns 1, the
+// identity, if there is none or it can't be parsed.
+func jpegOrientation(dat []byte) int {
+	if len(dat) < 4 || dat[0] != 0xFF || dat[1] != 0xD8 {
+		return 1
+	}
+
+	// Walk the marker segments up to the start of the image data
+	pos := 2
+	for pos+4 <= len(dat) {
+		if dat[pos] != 0xFF {
+			return 1
+		}
+		marker := dat[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(dat[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(dat) {
+			return 1
+		}
+		segment := dat[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+// Finds the Orientation tag (0x0112) in the first IFD of a TIFF-structured EXIF block
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset < 8 || ifdOffset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != 0x0112 {
+			continue
+		}
+		orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+		return orientation
+	}
+	return 1
+}
+
+// Transforms img so it displays upright without its EXIF orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	// Orientations 5-8 swap width and height
+	outW, outH := w, h
+	if orientation >= 5 {
+		outW, outH = h, w
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	out := image.NewRGBA(image.Rect(0, 0, outW, outH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			out.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return out
+}
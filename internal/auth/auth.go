@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -18,10 +19,14 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// Only good for uploading a file to one video; see MakeUploadToken
+	TokenTypeUpload TokenType = "tubely-upload"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
+var errUnknownKeyID = errors.New("token signed with an unknown key")
+
 func HashPassword(password string) (string, error) {
 	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
 	if err != nil {
@@ -38,42 +43,157 @@ func CheckPasswordHash(password, hash string) (bool, error) {
 	return match, nil
 }
 
+// A secret access tokens can be signed with. ID goes in the token's kid header
+// so validation knows which secret to check it against.
+type JWTKey struct {
+	ID     string
+	Secret string
+}
+
+// Derives a key ID from the secret, so rotating only means changing secrets. The
+// ID is a truncated hash and doesn't reveal the secret.
+func NewJWTKey(secret string) JWTKey {
+	sum := sha256.Sum256([]byte("tubely-jwt-kid:" + secret))
+	return JWTKey{ID: hex.EncodeToString(sum[:8]), Secret: secret}
+}
+
+// The secrets access tokens are accepted with. New tokens are only signed with
+// Current; tokens signed with a Previous secret stay valid until they expire, so
+// the secret can be rotated without logging everyone out.
+type JWTKeys struct {
+	Current  JWTKey
+	Previous []JWTKey
+}
+
+func (k JWTKeys) all() []JWTKey {
+	return append([]JWTKey{k.Current}, k.Previous...)
+}
+
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	keys JWTKeys,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
+	signingKey := []byte(keys.Current.Secret)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
 		Issuer:    string(TokenTypeAccess),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	})
+	token.Header["kid"] = keys.Current.ID
 	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+func ValidateJWT(tokenString string, keys JWTKeys) (uuid.UUID, error) {
+	return validateJWT(tokenString, keys)
+}
+
+// Like ValidateJWT, but accepts tokens that have expired. Only for requests that
+// continue something a valid token already authorized, such as the remaining
+// chunks of a long upload.
+func ValidateJWTIgnoringExpiry(tokenString string, keys JWTKeys) (uuid.UUID, error) {
+	return validateJWT(tokenString, keys, jwt.WithoutClaimsValidation())
+}
+
+func validateJWT(tokenString string, keys JWTKeys, options ...jwt.ParserOption) (uuid.UUID, error) {
+	claims := &jwt.RegisteredClaims{}
+	err := parseJWT(tokenString, keys, claims, options...)
 	if err != nil {
 		return uuid.Nil, err
 	}
+	return subjectOf(claims, TokenTypeAccess)
+}
+
+// Claims of an upload token: the user is the subject, as in access tokens
+type uploadClaims struct {
+	jwt.RegisteredClaims
+	VideoID string `json:"video_id"`
+}
+
+// Makes a token that only lets its holder upload a file to videoID, for
+// handing to clients that shouldn't get the user's access token. It isn't
+// accepted anywhere an access token is.
+func MakeUploadToken(
+	userID uuid.UUID,
+	videoID uuid.UUID,
+	keys JWTKeys,
+	expiresIn time.Duration,
+) (string, error) {
+	signingKey := []byte(keys.Current.Secret)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, uploadClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeUpload),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		VideoID: videoID.String(),
+	})
+	token.Header["kid"] = keys.Current.ID
+	return token.SignedString(signingKey)
+}
+
+// Returns the user an upload token was made for and the video it's good for
+func ValidateUploadToken(tokenString string, keys JWTKeys) (uuid.UUID, uuid.UUID, error) {
+	claims := &uploadClaims{}
+	err := parseJWT(tokenString, keys, claims)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	userID, err := subjectOf(claims, TokenTypeUpload)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	videoID, err := uuid.Parse(claims.VideoID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid video ID: %w", err)
+	}
+	return userID, videoID, nil
+}
+
+// Checks the token against the key named by its kid header and reads its
+// claims. Tokens without one (issued before key IDs were added) are tried
+// against every key.
+func parseJWT(tokenString string, keys JWTKeys, claims jwt.Claims, options ...jwt.ParserOption) error {
+	var err error
+	for _, key := range keys.all() {
+		hasKeyID, otherKey := false, false
+		_, err = jwt.ParseWithClaims(
+			tokenString,
+			claims,
+			func(token *jwt.Token) (interface{}, error) {
+				kid, ok := token.Header["kid"].(string)
+				hasKeyID = ok
+				if ok && kid != key.ID {
+					otherKey = true
+					return nil, errUnknownKeyID
+				}
+				return []byte(key.Secret), nil
+			},
+			options...,
+		)
+		// Move on only if the token may belong to a different key. Other failures
+		// (expired, malformed, ...) would be the same with any key.
+		if err == nil || !(otherKey || (!hasKeyID && errors.Is(err, jwt.ErrTokenSignatureInvalid))) {
+			break
+		}
+	}
+	return err
+}
 
-	userIDString, err := token.Claims.GetSubject()
+// Checks claims are of a token of tokenType and returns its user
+func subjectOf(claims jwt.Claims, tokenType TokenType) (uuid.UUID, error) {
+	userIDString, err := claims.GetSubject()
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	issuer, err := token.Claims.GetIssuer()
+	issuer, err := claims.GetIssuer()
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(tokenType) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
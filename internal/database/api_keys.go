@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A long-lived key a user's integrations authenticate with instead of a JWT.
+// Only a hash of the key is stored.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	KeyHash    string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+const apiKeyColumns = `id, user_id, name, key_hash, created_at, revoked_at, last_used_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var id, userID string
+	err := row.Scan(&id, &userID, &key.Name, &key.KeyHash, &key.CreatedAt, &key.RevokedAt, &key.LastUsedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.ID, err = uuid.Parse(id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (c Client) CreateAPIKey(userID uuid.UUID, name, keyHash string) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		user_id,
+		name,
+		key_hash,
+		created_at
+	) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, id.String(), userID.String(), name, keyHash)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.getAPIKey("id = ?", id.String())
+}
+
+// Returns an empty APIKey if no key has the hash
+func (c Client) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	return c.getAPIKey("key_hash = ?", keyHash)
+}
+
+func (c Client) getAPIKey(where string, arg any) (APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE ` + where
+	key, err := scanAPIKey(c.db.QueryRow(query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// The user's keys that haven't been revoked, newest first
+func (c Client) GetActiveAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ? AND revoked_at IS NULL
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Revokes one of the user's keys. Returns false if the user has no such active
+// key.
+func (c Client) RevokeAPIKey(userID, id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.Exec(query, id.String(), userID.String())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (c Client) RecordAPIKeyUse(id uuid.UUID) error {
+	query := `
+	UPDATE api_keys
+	SET last_used_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// One destructive operation: who did what to which video, when and from where.
+// Records can't be changed or deleted once written.
+type AuditRecord struct {
+	ID       int64      `json:"id"`
+	Action   string     `json:"action"`
+	UserID   uuid.UUID  `json:"user_id"`
+	VideoID  *uuid.UUID `json:"video_id"`
+	ClientIP string     `json:"client_ip"`
+	// What changed, specific to the action
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type CreateAuditRecordParams struct {
+	Action   string
+	UserID   uuid.UUID
+	VideoID  *uuid.UUID
+	ClientIP string
+	Details  json.RawMessage
+}
+
+// Which records GetAuditRecords returns. Zero fields don't filter.
+type AuditFilter struct {
+	UserID  uuid.UUID
+	VideoID uuid.UUID
+	Action  string
+	// Only records older than this ID, for paging backwards
+	BeforeID int64
+	Limit    int
+}
+
+func (c Client) CreateAuditRecord(params CreateAuditRecordParams) error {
+	query := `
+	INSERT INTO audit_log (
+		action,
+		user_id,
+		video_id,
+		client_ip,
+		details,
+		created_at
+	) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	var videoID *string
+	if params.VideoID != nil {
+		id := params.VideoID.String()
+		videoID = &id
+	}
+	details := string(params.Details)
+	if details == "" {
+		details = "{}"
+	}
+	_, err := c.db.Exec(query, params.Action, params.UserID.String(), videoID, params.ClientIP, details)
+	return err
+}
+
+// Records matching the filter, newest first
+func (c Client) GetAuditRecords(filter AuditFilter) ([]AuditRecord, error) {
+	var conditions []string
+	var args []any
+	if filter.UserID != uuid.Nil {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, filter.UserID.String())
+	}
+	if filter.VideoID != uuid.Nil {
+		conditions = append(conditions, "video_id = ?")
+		args = append(args, filter.VideoID.String())
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.BeforeID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := `SELECT id, action, user_id, video_id, client_ip, details, created_at FROM audit_log`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, filter.Limit)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var record AuditRecord
+		var userID string
+		var videoID *string
+		var details string
+		err := rows.Scan(&record.ID, &record.Action, &userID, &videoID, &record.ClientIP, &details, &record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		record.UserID, err = uuid.Parse(userID)
+		if err != nil {
+			return nil, err
+		}
+		if videoID != nil {
+			id, err := uuid.Parse(*videoID)
+			if err != nil {
+				return nil, err
+			}
+			record.VideoID = &id
+		}
+		record.Details = json.RawMessage(details)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
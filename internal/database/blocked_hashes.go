@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SHA-256 of a file that must not be uploaded again, e.g. content removed
+// for policy violations
+type BlockedHash struct {
+	Hash      string    `json:"hash"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c Client) CreateBlockedHash(hash, reason string) (BlockedHash, error) {
+	query := `
+	INSERT INTO blocked_hashes (
+		hash,
+		reason,
+		created_at
+	) VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(hash) DO UPDATE SET reason = excluded.reason
+	`
+	_, err := c.db.Exec(query, hash, reason)
+	if err != nil {
+		return BlockedHash{}, err
+	}
+
+	return c.GetBlockedHash(hash)
+}
+
+func (c Client) GetBlockedHash(hash string) (BlockedHash, error) {
+	query := `
+	SELECT hash, reason, created_at
+	FROM blocked_hashes
+	WHERE hash = ?
+	`
+	var blocked BlockedHash
+	err := c.db.QueryRow(query, hash).Scan(&blocked.Hash, &blocked.Reason, &blocked.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BlockedHash{}, nil
+		}
+		return BlockedHash{}, err
+	}
+	return blocked, nil
+}
+
+func (c Client) IsHashBlocked(hash string) (bool, error) {
+	blocked, err := c.GetBlockedHash(hash)
+	if err != nil {
+		return false, err
+	}
+	return blocked.Hash != "", nil
+}
+
+func (c Client) GetBlockedHashes() ([]BlockedHash, error) {
+	query := `
+	SELECT hash, reason, created_at
+	FROM blocked_hashes
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := []BlockedHash{}
+	for rows.Next() {
+		var blocked BlockedHash
+		if err := rows.Scan(&blocked.Hash, &blocked.Reason, &blocked.CreatedAt); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, blocked)
+	}
+	return hashes, rows.Err()
+}
+
+func (c Client) DeleteBlockedHash(hash string) error {
+	query := `
+	DELETE FROM blocked_hashes
+	WHERE hash = ?
+	`
+	_, err := c.db.Exec(query, hash)
+	return err
+}
@@ -25,63 +25,43 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
-	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
-	if err != nil {
-		return err
-	}
-
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_storage"); err != nil {
+		return fmt.Errorf("failed to reset table user_storage: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM blocked_hashes"); err != nil {
+		return fmt.Errorf("failed to reset table blocked_hashes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_stats"); err != nil {
+		return fmt.Errorf("failed to reset table video_stats: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM share_links"); err != nil {
+		return fmt.Errorf("failed to reset table share_links: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_probes"); err != nil {
+		return fmt.Errorf("failed to reset table video_probes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_keyframes"); err != nil {
+		return fmt.Errorf("failed to reset table video_keyframes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_chapters"); err != nil {
+		return fmt.Errorf("failed to reset table video_chapters: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_states"); err != nil {
+		return fmt.Errorf("failed to reset table upload_states: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_assets"); err != nil {
+		return fmt.Errorf("failed to reset table video_assets: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
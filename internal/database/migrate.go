@@ -0,0 +1,95 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Schema changes live in migrations/ as numbered .sql files, applied in name
+// order. Each runs once, in its own transaction, and is recorded in
+// schema_migrations. Never edit a migration that has shipped; add a new one.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+func (c *Client) autoMigrate() error {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+		if applied[version] {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		err = c.applyMigration(version, string(script))
+		if err != nil {
+			return fmt.Errorf("migration %s failed: %w", version, err)
+		}
+		log.Printf("Applied database migration %s", version)
+	}
+	return nil
+}
+
+func (c *Client) appliedMigrations() (map[string]bool, error) {
+	rows, err := c.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// Runs a migration script and records it, all or nothing
+func (c *Client) applyMigration(version, script string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(script)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
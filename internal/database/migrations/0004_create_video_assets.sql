@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS video_assets (
+	video_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	name TEXT NOT NULL,
+	url TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(video_id, kind, name),
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
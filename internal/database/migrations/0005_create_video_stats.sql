@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS video_stats (
+	video_id TEXT PRIMARY KEY,
+	view_count INTEGER NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
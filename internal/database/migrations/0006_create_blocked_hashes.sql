@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS blocked_hashes (
+	hash TEXT PRIMARY KEY,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private';
@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN recorded_at TIMESTAMP;
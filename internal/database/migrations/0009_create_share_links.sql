@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS share_links (
+	id TEXT PRIMARY KEY,
+	video_id TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	use_count INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+
+CREATE INDEX IF NOT EXISTS share_links_video_id ON share_links(video_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_storage (
+	user_id TEXT PRIMARY KEY,
+	bucket TEXT NOT NULL,
+	region TEXT NOT NULL,
+	role_arn TEXT NOT NULL DEFAULT '',
+	external_id TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS video_chapters (
+	video_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	start_ms INTEGER NOT NULL,
+	end_ms INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	PRIMARY KEY(video_id, position),
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
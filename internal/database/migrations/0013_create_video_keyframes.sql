@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS video_keyframes (
+	video_id TEXT PRIMARY KEY,
+	timestamps TEXT NOT NULL,
+	total INTEGER NOT NULL,
+	average_interval REAL NOT NULL,
+	max_interval REAL NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
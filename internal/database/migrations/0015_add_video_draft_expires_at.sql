@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN draft_expires_at TIMESTAMP;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	action TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	video_id TEXT,
+	client_ip TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_user_id ON audit_log(user_id);
+CREATE INDEX IF NOT EXISTS audit_log_video_id ON audit_log(video_id);
+
+-- Audit records are written once and never changed or removed
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit records are immutable');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit records are immutable');
+END;
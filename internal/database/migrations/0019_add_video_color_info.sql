@@ -0,0 +1,4 @@
+ALTER TABLE videos ADD COLUMN color_primaries TEXT;
+ALTER TABLE videos ADD COLUMN color_transfer TEXT;
+ALTER TABLE videos ADD COLUMN color_space TEXT;
+ALTER TABLE videos ADD COLUMN hdr BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS upload_states (
+	video_id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	state TEXT NOT NULL,
+	stage TEXT NOT NULL DEFAULT '',
+	bytes_total INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	-- While processing: the complete upload on disk and how it's processed, so
+	-- processing can start over after a restart
+	source_path TEXT NOT NULL DEFAULT '',
+	options TEXT NOT NULL DEFAULT '',
+	started_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
@@ -0,0 +1 @@
+ALTER TABLE upload_states ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN perceptual_hash TEXT;
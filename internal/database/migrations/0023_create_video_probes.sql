@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS video_probes (
+	video_id TEXT PRIMARY KEY,
+	-- The stored file the probe describes; a replaced file needs probing again
+	video_url TEXT NOT NULL,
+	probe TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
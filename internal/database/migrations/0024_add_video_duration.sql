@@ -0,0 +1 @@
+ALTER TABLE videos ADD COLUMN duration_seconds REAL;
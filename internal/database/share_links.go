@@ -0,0 +1,134 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A link that lets anyone holding its token watch one video, until it expires
+// or is revoked. Only a hash of the token is stored.
+type ShareLink struct {
+	ID         uuid.UUID  `json:"id"`
+	VideoID    uuid.UUID  `json:"video_id"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UseCount   int64      `json:"use_count"`
+}
+
+// Whether the link can still be used
+func (l ShareLink) IsActive() bool {
+	return l.RevokedAt == nil && time.Now().Before(l.ExpiresAt)
+}
+
+const shareLinkColumns = `id, video_id, token_hash, created_at, expires_at, revoked_at, last_used_at, use_count`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	var id, videoID string
+	err := row.Scan(&id, &videoID, &link.TokenHash, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt, &link.LastUsedAt, &link.UseCount)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	link.ID, err = uuid.Parse(id)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	link.VideoID, err = uuid.Parse(videoID)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+func (c Client) CreateShareLink(videoID uuid.UUID, tokenHash string, expiresAt time.Time) (ShareLink, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (
+		id,
+		video_id,
+		token_hash,
+		created_at,
+		expires_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), videoID.String(), tokenHash, expiresAt.UTC())
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	return c.getShareLink("id = ?", id.String())
+}
+
+// Returns an empty ShareLink if no link has the token hash
+func (c Client) GetShareLinkByTokenHash(tokenHash string) (ShareLink, error) {
+	return c.getShareLink("token_hash = ?", tokenHash)
+}
+
+func (c Client) getShareLink(where string, arg any) (ShareLink, error) {
+	query := `SELECT ` + shareLinkColumns + ` FROM share_links WHERE ` + where
+	link, err := scanShareLink(c.db.QueryRow(query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+// The video's links that haven't expired or been revoked, newest first
+func (c Client) GetActiveShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `
+	SELECT ` + shareLinkColumns + `
+	FROM share_links
+	WHERE video_id = ? AND revoked_at IS NULL AND expires_at > ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, videoID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// Revokes one of the video's links. Returns false if the video has no such
+// active link.
+func (c Client) RevokeShareLink(videoID, id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE share_links
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND video_id = ? AND revoked_at IS NULL
+	`
+	result, err := c.db.Exec(query, id.String(), videoID.String())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (c Client) RecordShareLinkUse(id uuid.UUID) error {
+	query := `
+	UPDATE share_links
+	SET last_used_at = CURRENT_TIMESTAMP, use_count = use_count + 1
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
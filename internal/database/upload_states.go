@@ -0,0 +1,109 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The last known state of a video upload, kept so uploads that were in flight
+// when the server stopped can be recovered when it starts again
+type UploadState struct {
+	VideoID    uuid.UUID
+	UserID     uuid.UUID
+	State      string
+	Stage      string
+	BytesTotal int64
+	Error      string
+	// How many times processing was tried, when it's run as a background job
+	Attempts   int
+	SourcePath string
+	// JSON, as the server wrote it
+	Options   string
+	StartedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (c Client) SaveUploadState(state UploadState) error {
+	query := `
+	INSERT INTO upload_states (
+		video_id,
+		user_id,
+		state,
+		stage,
+		bytes_total,
+		error,
+		attempts,
+		source_path,
+		options,
+		started_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		user_id = excluded.user_id,
+		state = excluded.state,
+		stage = excluded.stage,
+		bytes_total = excluded.bytes_total,
+		error = excluded.error,
+		attempts = excluded.attempts,
+		source_path = excluded.source_path,
+		options = excluded.options,
+		started_at = excluded.started_at,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query,
+		state.VideoID,
+		state.UserID,
+		state.State,
+		state.Stage,
+		state.BytesTotal,
+		state.Error,
+		state.Attempts,
+		state.SourcePath,
+		state.Options,
+		state.StartedAt.UTC(),
+		state.UpdatedAt.UTC(),
+	)
+	return err
+}
+
+func (c Client) GetUploadStates() ([]UploadState, error) {
+	query := `
+	SELECT video_id, user_id, state, stage, bytes_total, error, attempts, source_path, options, started_at, updated_at
+	FROM upload_states
+	ORDER BY started_at
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []UploadState{}
+	for rows.Next() {
+		var state UploadState
+		err := rows.Scan(
+			&state.VideoID,
+			&state.UserID,
+			&state.State,
+			&state.Stage,
+			&state.BytesTotal,
+			&state.Error,
+			&state.Attempts,
+			&state.SourcePath,
+			&state.Options,
+			&state.StartedAt,
+			&state.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func (c Client) DeleteUploadState(videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM upload_states WHERE video_id = ?", videoID)
+	return err
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A bucket of the user's own that their videos are stored in instead of ours.
+// If RoleARN is set the bucket is accessed through that IAM role, assumed with
+// ExternalID if given; otherwise with our own credentials.
+type UserStorage struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Bucket     string    `json:"bucket"`
+	Region     string    `json:"region"`
+	RoleARN    string    `json:"role_arn"`
+	ExternalID string    `json:"external_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (c Client) UpsertUserStorage(storage UserStorage) (UserStorage, error) {
+	query := `
+	INSERT INTO user_storage (
+		user_id,
+		bucket,
+		region,
+		role_arn,
+		external_id,
+		created_at,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT(user_id) DO UPDATE SET
+		bucket = excluded.bucket,
+		region = excluded.region,
+		role_arn = excluded.role_arn,
+		external_id = excluded.external_id,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, storage.UserID.String(), storage.Bucket, storage.Region, storage.RoleARN, storage.ExternalID)
+	if err != nil {
+		return UserStorage{}, err
+	}
+	return c.GetUserStorage(storage.UserID)
+}
+
+// Returns an empty UserStorage (Bucket == "") if the user stores videos with us
+func (c Client) GetUserStorage(userID uuid.UUID) (UserStorage, error) {
+	query := `
+	SELECT bucket, region, role_arn, external_id, created_at, updated_at
+	FROM user_storage
+	WHERE user_id = ?
+	`
+	storage := UserStorage{UserID: userID}
+	err := c.db.QueryRow(query, userID.String()).Scan(
+		&storage.Bucket,
+		&storage.Region,
+		&storage.RoleARN,
+		&storage.ExternalID,
+		&storage.CreatedAt,
+		&storage.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserStorage{}, nil
+		}
+		return UserStorage{}, err
+	}
+	return storage, nil
+}
+
+// Returns whether the user had a storage configuration to delete
+func (c Client) DeleteUserStorage(userID uuid.UUID) (bool, error) {
+	result, err := c.db.Exec("DELETE FROM user_storage WHERE user_id = ?", userID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
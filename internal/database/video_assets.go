@@ -0,0 +1,124 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A file derived from a video (sprite sheet, thumbnail track, ...) stored
+// alongside it. URL uses the same "bucket,key" format as Video.VideoURL.
+type VideoAsset struct {
+	VideoID   uuid.UUID `json:"-"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c Client) UpsertVideoAsset(asset VideoAsset) error {
+	query := `
+	INSERT INTO video_assets (
+		video_id,
+		kind,
+		name,
+		url,
+		created_at
+	) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id, kind, name) DO UPDATE SET
+		url = excluded.url,
+		created_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, asset.VideoID, asset.Kind, asset.Name, asset.URL)
+	return err
+}
+
+func (c Client) GetVideoAssets(videoID uuid.UUID) ([]VideoAsset, error) {
+	query := `
+	SELECT
+		video_id,
+		kind,
+		name,
+		url,
+		created_at
+	FROM video_assets
+	WHERE video_id = ?
+	ORDER BY kind, name
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []VideoAsset{}
+	for rows.Next() {
+		var asset VideoAsset
+		if err := rows.Scan(
+			&asset.VideoID,
+			&asset.Kind,
+			&asset.Name,
+			&asset.URL,
+			&asset.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, rows.Err()
+}
+
+func (c Client) DeleteVideoAssets(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM video_assets
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, videoID)
+	return err
+}
+
+func (c Client) DeleteVideoAsset(videoID uuid.UUID, kind, name string) error {
+	query := `
+	DELETE FROM video_assets
+	WHERE video_id = ? AND kind = ? AND name = ?
+	`
+	_, err := c.db.Exec(query, videoID, kind, name)
+	return err
+}
+
+// A stored object some record points at: a video's file (Kind "video") or one
+// of its assets
+type StorageReference struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name,omitempty"`
+	URL     string    `json:"url"`
+}
+
+// Lists every stored object reference across all videos and assets
+func (c Client) GetStorageReferences() ([]StorageReference, error) {
+	query := `
+	SELECT id, 'video', '', video_url FROM videos
+	WHERE video_url IS NOT NULL AND video_url != ''
+	UNION ALL
+	SELECT video_id, kind, name, url FROM video_assets
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []StorageReference{}
+	for rows.Next() {
+		var ref StorageReference
+		if err := rows.Scan(&ref.VideoID, &ref.Kind, &ref.Name, &ref.URL); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
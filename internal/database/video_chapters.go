@@ -0,0 +1,77 @@
+package database
+
+import (
+	"math"
+
+	"github.com/google/uuid"
+)
+
+// A named section of a video, from Start up to End (in seconds). Stored to the
+// millisecond, as in WebVTT.
+type VideoChapter struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title"`
+}
+
+// Replaces all of a video's chapters, in order. An empty list removes them.
+func (c Client) ReplaceVideoChapters(videoID uuid.UUID, chapters []VideoChapter) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_chapters WHERE video_id = ?", videoID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO video_chapters (
+		video_id,
+		position,
+		start_ms,
+		end_ms,
+		title
+	) VALUES (?, ?, ?, ?, ?)
+	`
+	for i, chapter := range chapters {
+		_, err = tx.Exec(query, videoID, i, int64(math.Round(chapter.Start*1000)), int64(math.Round(chapter.End*1000)), chapter.Title)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetVideoChapters(videoID uuid.UUID) ([]VideoChapter, error) {
+	query := `
+	SELECT
+		start_ms,
+		end_ms,
+		title
+	FROM video_chapters
+	WHERE video_id = ?
+	ORDER BY position
+	`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []VideoChapter{}
+	for rows.Next() {
+		var chapter VideoChapter
+		var startMS, endMS int64
+		if err := rows.Scan(&startMS, &endMS, &chapter.Title); err != nil {
+			return nil, err
+		}
+		chapter.Start = float64(startMS) / 1000
+		chapter.End = float64(endMS) / 1000
+		chapters = append(chapters, chapter)
+	}
+	return chapters, rows.Err()
+}
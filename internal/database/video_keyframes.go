@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The keyframe (I-frame) index of a video's stored file. Timestamps are in
+// seconds; long videos only keep an evenly spread subset of them, in which case
+// Total is larger than len(Timestamps). The intervals cover every keyframe.
+type VideoKeyframes struct {
+	VideoID         uuid.UUID `json:"video_id"`
+	Timestamps      []float64 `json:"timestamps"`
+	Total           int       `json:"total"`
+	AverageInterval float64   `json:"average_interval"`
+	MaxInterval     float64   `json:"max_interval"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func (c Client) UpsertVideoKeyframes(keyframes VideoKeyframes) error {
+	timestamps, err := json.Marshal(keyframes.Timestamps)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO video_keyframes (
+		video_id,
+		timestamps,
+		total,
+		average_interval,
+		max_interval,
+		created_at
+	) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id) DO UPDATE SET
+		timestamps = excluded.timestamps,
+		total = excluded.total,
+		average_interval = excluded.average_interval,
+		max_interval = excluded.max_interval,
+		created_at = CURRENT_TIMESTAMP
+	`
+	_, err = c.db.Exec(query, keyframes.VideoID, string(timestamps), keyframes.Total, keyframes.AverageInterval, keyframes.MaxInterval)
+	return err
+}
+
+// Returns an empty VideoKeyframes if the video hasn't been indexed
+func (c Client) GetVideoKeyframes(videoID uuid.UUID) (VideoKeyframes, error) {
+	query := `
+	SELECT
+		timestamps,
+		total,
+		average_interval,
+		max_interval,
+		created_at
+	FROM video_keyframes
+	WHERE video_id = ?
+	`
+
+	keyframes := VideoKeyframes{VideoID: videoID}
+	var timestamps string
+	err := c.db.QueryRow(query, videoID).Scan(
+		&timestamps,
+		&keyframes.Total,
+		&keyframes.AverageInterval,
+		&keyframes.MaxInterval,
+		&keyframes.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoKeyframes{}, nil
+	}
+	if err != nil {
+		return VideoKeyframes{}, err
+	}
+	err = json.Unmarshal([]byte(timestamps), &keyframes.Timestamps)
+	if err != nil {
+		return VideoKeyframes{}, err
+	}
+	return keyframes, nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ffprobe's output for a video's stored file, kept since it doesn't change
+// until the file is replaced
+type VideoProbe struct {
+	VideoID uuid.UUID
+	// The video_url of the file that was probed
+	VideoURL string
+	// JSON, as ffprobe printed it
+	Probe     string
+	CreatedAt time.Time
+}
+
+func (c Client) UpsertVideoProbe(probe VideoProbe) error {
+	query := `
+	INSERT INTO video_probes (
+		video_id,
+		video_url,
+		probe,
+		created_at
+	) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id) DO UPDATE SET
+		video_url = excluded.video_url,
+		probe = excluded.probe,
+		created_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, probe.VideoID, probe.VideoURL, probe.Probe)
+	return err
+}
+
+// Returns an empty VideoProbe if the video hasn't been probed
+func (c Client) GetVideoProbe(videoID uuid.UUID) (VideoProbe, error) {
+	query := `
+	SELECT
+		video_url,
+		probe,
+		created_at
+	FROM video_probes
+	WHERE video_id = ?
+	`
+
+	probe := VideoProbe{VideoID: videoID}
+	err := c.db.QueryRow(query, videoID).Scan(
+		&probe.VideoURL,
+		&probe.Probe,
+		&probe.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoProbe{}, nil
+	}
+	if err != nil {
+		return VideoProbe{}, err
+	}
+	return probe, nil
+}
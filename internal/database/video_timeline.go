@@ -0,0 +1,77 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// How GetVideoTimeline groups videos
+type TimelineInterval string
+
+const (
+	TimelineDay   TimelineInterval = "day"
+	TimelineMonth TimelineInterval = "month"
+)
+
+// The videos of one day or month. Period is "2006-01-02" or "2006-01", in UTC.
+type TimelineGroup struct {
+	Period string `json:"period"`
+	Count  int    `json:"count"`
+	// The thumbnail of the group's latest video that has one
+	ThumbnailURL *string `json:"thumbnail_url"`
+}
+
+// Groups a user's videos by the day or month they were made, newest first.
+// With VideoSortRecordedAt, videos are placed by when they were recorded, or
+// created if that isn't known.
+func (c Client) GetVideoTimeline(userID uuid.UUID, interval TimelineInterval, date VideoSort) ([]TimelineGroup, error) {
+	format := "%Y-%m"
+	if interval == TimelineDay {
+		format = "%Y-%m-%d"
+	}
+	dateColumn := "created_at"
+	if date == VideoSortRecordedAt {
+		dateColumn = "COALESCE(recorded_at, created_at)"
+	}
+
+	query := fmt.Sprintf(`
+	WITH dated AS (
+		SELECT
+			strftime('%s', %s) AS period,
+			%s AS made_at,
+			thumbnail_url
+		FROM videos
+		WHERE user_id = ?
+	)
+	SELECT
+		period,
+		COUNT(*),
+		(
+			SELECT sample.thumbnail_url FROM dated AS sample
+			WHERE sample.period = dated.period AND sample.thumbnail_url IS NOT NULL
+			ORDER BY sample.made_at DESC
+			LIMIT 1
+		)
+	FROM dated
+	GROUP BY period
+	ORDER BY period DESC
+	`, format, dateColumn, dateColumn)
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []TimelineGroup{}
+	for rows.Next() {
+		var group TimelineGroup
+		err := rows.Scan(&group.Period, &group.Count, &group.ThumbnailURL)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
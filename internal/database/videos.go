@@ -13,7 +13,35 @@ type Video struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	// Tiny blurry version of the thumbnail as a data: URI, shown while the real
+	// one loads
+	ThumbnailPlaceholder *string `json:"thumbnail_placeholder"`
+	// The thumbnail's dominant color as #rrggbb, for tinting around it
+	ThumbnailColor *string `json:"thumbnail_color"`
+	VideoURL       *string `json:"video_url"`
+	// When the video was filmed according to its metadata, or uploaded if it
+	// doesn't say. Nil until a video file has been uploaded.
+	RecordedAt *time.Time `json:"recorded_at"`
+	// When the video was made public, or for a scheduled video when it will be
+	PublishedAt *time.Time `json:"published_at"`
+	// Set while the video's file is an unpublished draft: when the video is
+	// deleted unless it's published before then
+	DraftExpiresAt *time.Time `json:"draft_expires_at"`
+	// Color characteristics of the stored file as ffprobe names them (e.g.
+	// bt709, bt2020), nil if it doesn't say
+	ColorPrimaries *string `json:"color_primaries"`
+	ColorTransfer  *string `json:"color_transfer"`
+	ColorSpace     *string `json:"color_space"`
+	// The stored file is HDR, which SDR screens show washed out
+	HDR bool `json:"hdr"`
+	// Perceptual hash of frames sampled from the stored file, as hex, for
+	// finding re-encodes of the same content
+	PerceptualHash *string `json:"perceptual_hash"`
+	// Length of the stored file, nil if ffprobe couldn't tell
+	DurationSeconds *float64 `json:"duration_seconds"`
+	ViewCount       int64    `json:"view_count"`
+	// Derived files, loaded separately with GetVideoAssets
+	Assets []VideoAsset `json:"assets,omitempty"`
 	CreateVideoParams
 }
 
@@ -21,22 +49,92 @@ type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// VisibilityPrivate or VisibilityPublic; empty means private. Videos are
+	// only VisibilityScheduled through a schedule set on an existing video.
+	Visibility string `json:"visibility"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+	// Private until PublishedAt, then public
+	VisibilityScheduled = "scheduled"
+)
+
+func IsValidVisibility(visibility string) bool {
+	return visibility == VisibilityPrivate || visibility == VisibilityPublic
+}
+
+// Columns selected for a Video, in the order scanVideo expects them
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_placeholder,
+		thumbnail_color,
 		video_url,
-		user_id
+		user_id,
+		visibility,
+		recorded_at,
+		published_at,
+		draft_expires_at,
+		color_primaries,
+		color_transfer,
+		color_space,
+		hdr,
+		perceptual_hash,
+		duration_seconds,
+		COALESCE((SELECT view_count FROM video_stats WHERE video_id = videos.id), 0)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailPlaceholder,
+		&video.ThumbnailColor,
+		&video.VideoURL,
+		&video.UserID,
+		&video.Visibility,
+		&video.RecordedAt,
+		&video.PublishedAt,
+		&video.DraftExpiresAt,
+		&video.ColorPrimaries,
+		&video.ColorTransfer,
+		&video.ColorSpace,
+		&video.HDR,
+		&video.PerceptualHash,
+		&video.DurationSeconds,
+		&video.ViewCount,
+	)
+	return video, err
+}
+
+// Orders for GetVideos, newest first
+type VideoSort string
+
+const (
+	VideoSortCreatedAt  VideoSort = "created_at"
+	VideoSortRecordedAt VideoSort = "recorded_at"
+)
+
+func (c Client) GetVideos(userID uuid.UUID, sort VideoSort) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
-	ORDER BY created_at DESC
+	ORDER BY ` + videoSortOrder(sort) + `
 	`
 
 	rows, err := c.db.Query(query, userID)
@@ -47,17 +145,8 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
@@ -66,6 +155,82 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 	return videos, nil
 }
 
+// Like GetVideos, one page of limit videos starting at offset, optionally only
+// those with the given visibility. Also returns how many videos there are on
+// all pages together.
+func (c Client) GetVideosPaginated(userID uuid.UUID, sort VideoSort, visibility string, limit, offset int) ([]Video, int, error) {
+	filter := `user_id = ? AND (? = '' OR visibility = ?)`
+
+	var total int
+	err := c.db.QueryRow(`SELECT COUNT(*) FROM videos WHERE `+filter, userID, visibility, visibility).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + filter + `
+	ORDER BY ` + videoSortOrder(sort) + `
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := c.db.Query(query, userID, visibility, visibility, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, total, rows.Err()
+}
+
+// The ORDER BY for a sort. Ties are broken by ID so pages don't overlap.
+func videoSortOrder(sort VideoSort) string {
+	if sort == VideoSortRecordedAt {
+		// Videos without a file yet have no recording date; place them by creation
+		return "COALESCE(recorded_at, created_at) DESC, created_at DESC, id"
+	}
+	return "created_at DESC, id"
+}
+
+// A cheap summary of a user's videos and their assets that changes whenever
+// one is added, removed or updated
+type VideoListVersion struct {
+	Videos          int
+	VideosUpdatedAt string
+	Assets          int
+	AssetsUpdatedAt string
+}
+
+func (c Client) GetVideoListVersion(userID uuid.UUID) (VideoListVersion, error) {
+	query := `
+	SELECT
+		COUNT(*),
+		COALESCE(MAX(updated_at), ''),
+		(SELECT COUNT(*) FROM video_assets JOIN videos ON videos.id = video_assets.video_id WHERE videos.user_id = ?),
+		(SELECT COALESCE(MAX(video_assets.created_at), '') FROM video_assets JOIN videos ON videos.id = video_assets.video_id WHERE videos.user_id = ?)
+	FROM videos
+	WHERE user_id = ?
+	`
+	var version VideoListVersion
+	err := c.db.QueryRow(query, userID, userID, userID).Scan(
+		&version.Videos,
+		&version.VideosUpdatedAt,
+		&version.Assets,
+		&version.AssetsUpdatedAt,
+	)
+	return version, err
+}
+
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
 	query := `
@@ -75,10 +240,21 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility,
+		published_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	visibility := params.Visibility
+	if visibility == "" {
+		visibility = VisibilityPrivate
+	}
+	var publishedAt *time.Time
+	if visibility == VisibilityPublic {
+		now := time.Now().UTC()
+		publishedAt = &now
+	}
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, visibility, publishedAt)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +264,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -125,27 +284,141 @@ func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = ?,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_placeholder = ?,
+		thumbnail_color = ?,
 		video_url = ?,
-		user_id = ?
+		user_id = ?,
+		visibility = ?,
+		recorded_at = ?,
+		published_at = ?,
+		draft_expires_at = ?,
+		color_primaries = ?,
+		color_transfer = ?,
+		color_space = ?,
+		hdr = ?,
+		perceptual_hash = ?,
+		duration_seconds = ?
 	WHERE id = ?
 	`
 
 	_, err := c.db.Exec(
 		query,
+		video.UpdatedAt.UTC(),
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		video.ThumbnailPlaceholder,
+		video.ThumbnailColor,
 		&video.VideoURL,
 		video.UserID,
+		video.Visibility,
+		video.RecordedAt,
+		video.PublishedAt,
+		video.DraftExpiresAt,
+		video.ColorPrimaries,
+		video.ColorTransfer,
+		video.ColorSpace,
+		video.HDR,
+		video.PerceptualHash,
+		video.DurationSeconds,
 		video.ID,
 	)
 	return err
 }
 
+// Makes every scheduled video whose publish time has come public. Returns how
+// many were published.
+func (c Client) PublishDueVideos(now time.Time) (int64, error) {
+	query := `
+	UPDATE videos
+	SET
+		visibility = ?,
+		updated_at = ?
+	WHERE visibility = ? AND published_at <= ?
+	`
+	result, err := c.db.Exec(query, VisibilityPublic, now.UTC(), VisibilityScheduled, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Lists drafts that expired before now
+func (c Client) GetExpiredDrafts(now time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE draft_expires_at <= ?
+	`
+
+	rows, err := c.db.Query(query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// Lists every video with a perceptual hash, for comparing a video against
+func (c Client) GetVideosWithPerceptualHash() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE perceptual_hash IS NOT NULL
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// Adds n views to a video's counter
+func (c Client) IncrementVideoViews(id uuid.UUID, n int64) error {
+	query := `
+	INSERT INTO video_stats (video_id, view_count, updated_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT(video_id) DO UPDATE SET
+		view_count = view_count + excluded.view_count,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, id, n)
+	return err
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	// Rows that hang off the video go first
+	for _, table := range []string{"video_assets", "video_chapters", "video_keyframes", "video_stats", "share_links", "upload_states", "video_probes"} {
+		_, err := c.db.Exec("DELETE FROM "+table+" WHERE video_id = ?", id)
+		if err != nil {
+			return err
+		}
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Lists the timestamps (in seconds) of the keyframes in a video's main video
+// stream. ffprobe reads them from the packet flags, so nothing is decoded.
+func listKeyframes(filePath string) ([]float64, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=p=0",
+		filePath,
+	)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := runCommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	timestamps := []float64{}
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		// "12.345000,K__"; packets without a timestamp say "N/A"
+		ptsTime, flags, ok := strings.Cut(scanner.Text(), ",")
+		if !ok || !strings.Contains(flags, "K") {
+			continue
+		}
+		t, err := strconv.ParseFloat(ptsTime, 64)
+		if err != nil {
+			continue
+		}
+		timestamps = append(timestamps, t)
+	}
+	return timestamps, scanner.Err()
+}
+
+// Summarizes keyframe timestamps, keeping at most maxStored of them spread
+// evenly over the list (always including the first and last)
+func summarizeKeyframes(videoID uuid.UUID, timestamps []float64, maxStored int) database.VideoKeyframes {
+	keyframes := database.VideoKeyframes{
+		VideoID:    videoID,
+		Timestamps: timestamps,
+		Total:      len(timestamps),
+	}
+	for i := 1; i < len(timestamps); i++ {
+		keyframes.MaxInterval = max(keyframes.MaxInterval, timestamps[i]-timestamps[i-1])
+	}
+	if len(timestamps) > 1 {
+		keyframes.AverageInterval = (timestamps[len(timestamps)-1] - timestamps[0]) / float64(len(timestamps)-1)
+	}
+
+	if maxStored > 1 && len(timestamps) > maxStored {
+		kept := make([]float64, maxStored)
+		for i := range kept {
+			kept[i] = timestamps[i*(len(timestamps)-1)/(maxStored-1)]
+		}
+		keyframes.Timestamps = kept
+	}
+	return keyframes
+}
+
+// Indexes the keyframes of the video file at filePath (a local path or URL) and
+// stores them for the video
+func (cfg *apiConfig) indexKeyframes(videoID uuid.UUID, filePath string) (database.VideoKeyframes, error) {
+	timestamps, err := listKeyframes(filePath)
+	if err != nil {
+		return database.VideoKeyframes{}, err
+	}
+	keyframes := summarizeKeyframes(videoID, timestamps, cfg.maxStoredKeyframes)
+	err = cfg.db.UpsertVideoKeyframes(keyframes)
+	if err != nil {
+		return database.VideoKeyframes{}, fmt.Errorf("failed to save keyframes: %w", err)
+	}
+	return cfg.db.GetVideoKeyframes(videoID)
+}
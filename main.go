@@ -5,9 +5,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"runtime"
+	"time"
 
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 
 	"github.com/joho/godotenv"
@@ -16,7 +21,7 @@ import (
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	jwtKeys          auth.JWTKeys
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
@@ -24,7 +29,142 @@ type apiConfig struct {
 	s3Region         string
 	s3CfDistribution string
 	port             string
-	s3Client *s3.Client
+	s3Client         *s3.Client
+	// Shared by every request; presign clients are safe for concurrent use
+	s3Presign *s3.PresignClient
+	// Clients for users who store videos in their own bucket
+	storageClients *storageClients
+	// Lifetime of upload tokens; see handler_upload_token.go
+	uploadTokenExpiry time.Duration
+
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+	enableHTTP2       bool
+
+	enableCompression  bool
+	compressionMinSize int
+
+	videoContentTypeAliases map[string]string
+	// Containers uploads are accepted in and converted to MP4; see video_containers.go
+	allowedVideoContainers []string
+
+	// Multipart form fields the upload handlers read files from
+	videoFormField     string
+	thumbnailFormField string
+
+	allowedVideoCodecs          []string
+	allowedAudioCodecs          []string
+	transcodeIncompatibleCodecs bool
+	// What to do with HDR uploads; see hdr.go
+	hdrHandling string
+
+	// How many times to probe a video's aspect ratio before giving up
+	ffprobeAttempts   int
+	ffprobeRetryDelay time.Duration
+
+	enableScrubPreviews   bool
+	scrubPreviewInterval  time.Duration
+	scrubPreviewColumns   int
+	scrubPreviewTileWidth int
+	scrubPreviewMaxHeight int
+
+	enableAutoThumbnails        bool
+	autoThumbnailPositions      autoThumbnailPositions
+	enableThumbnailPlaceholders bool
+	thumbnailShape              thumbnailShape
+	progressiveThumbnails       bool
+
+	contactSheetColumns   int
+	contactSheetRows      int
+	contactSheetTileWidth int
+
+	previewLoopLength time.Duration
+	previewLoopWidth  int
+
+	renditions []string
+	// Limits how many renditions are encoded at once, across all videos
+	renditionSlots chan struct{}
+
+	enableKeyframeIndex bool
+	// Longer keyframe lists are thinned out to this many when stored
+	maxStoredKeyframes int
+
+	enablePerceptualHash bool
+	// Largest perceptual hash distance at which videos count as similar
+	similarVideoMaxDistance int
+
+	processingProfiles       map[string]processingProfile
+	defaultProcessingProfile string
+
+	shareLinkDefaultExpiry time.Duration
+	shareLinkMaxExpiry     time.Duration
+	shareLinkLimiter       *rateLimiter
+
+	enableStreamTranscode  bool
+	streamTranscodeSlots   chan struct{}
+	streamTranscodeTimeout time.Duration
+
+	// Maximum short side of stored videos in pixels, 0 for no limit
+	maxVideoResolution int
+
+	adminAPIKey string
+
+	maintenanceMode *maintenanceMode
+
+	polyglotCheck string
+
+	cloudFrontSigner   *cloudFrontSigner
+	bindSignedURLsToIP bool
+	cleanPublicURLs    bool
+	// How GET /videos/{videoID}/play serves videos; see handler_video_play.go
+	videoDelivery string
+	// Domain the signed cookies of GET /videos/{videoID}/hls-auth are set for
+	hlsCookieDomain string
+	// Sites whose pages may fetch signed URLs; see origin_check.go
+	signedURLAllowedOrigins []string
+	signedURLs              *signedURLCache
+
+	views *viewTracker
+
+	trackObjectVersions bool
+	s3CacheControl      string
+	// Per aspect ratio key prefixes, buckets and storage classes; see aspect_storage.go
+	aspectStorage map[string]storageRule
+	// Per asset kind key prefixes, buckets and storage classes; see asset_storage.go
+	assetStorage map[string]storageRule
+
+	// Unpublished drafts are deleted after draftTTL; see draft.go
+	draftTTL          time.Duration
+	draftStorageClass types.StorageClass
+
+	// Where uploads are written while they arrive and are processed; "" is the
+	// OS temp dir
+	uploadTempDir string
+	// Largest video file accepted by any upload path
+	maxVideoUploadBytes int64
+	// Uploads are refused unless this many bytes would still be free in
+	// uploadTempDir after them; see disk_space.go
+	uploadMinFreeDisk int64
+	// How long storing a processed video in S3 may take, retries included
+	s3UploadTimeout time.Duration
+
+	uploads        *uploadTracker
+	processingLogs *processingLogStore
+	// Failed uploads are kept here for debugging if set
+	debugFailedUploadsDir string
+
+	tusUploads      *tusStore
+	tusUploadExpiry time.Duration
+	tusMaxChunkSize int64
+	// Content-Range uploads, by video ID
+	rangeUploads *tusStore
+
+	// Runs batch jobs such as thumbnail regeneration, rate limited
+	backgroundJobs   *workerPool
+	thumbnailBatches *thumbnailBatchStore
 }
 
 func main() {
@@ -44,6 +184,11 @@ func main() {
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
+	// Tokens signed with a previous secret keep working until they expire
+	jwtKeys := auth.JWTKeys{Current: auth.NewJWTKey(jwtSecret)}
+	for _, secret := range getEnvList("JWT_PREVIOUS_SECRETS", nil) {
+		jwtKeys.Previous = append(jwtKeys.Previous, auth.NewJWTKey(secret))
+	}
 
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
@@ -80,16 +225,148 @@ func main() {
 		log.Fatal("PORT environment variable is not set")
 	}
 
-	awsConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	// One connection pool shared by all S3 requests. S3_MAX_CONNECTIONS caps how
+	// many run at once; further requests wait for a free connection.
+	s3MaxConnections := getEnvInt("S3_MAX_CONNECTIONS", 64)
+	s3HTTPClient := awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+		t.MaxConnsPerHost = s3MaxConnections
+		t.MaxIdleConnsPerHost = s3MaxConnections
+	})
+
+	awsConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region), config.WithHTTPClient(s3HTTPClient))
 	if err != nil {
 		log.Fatalf("Failed to load AWS config: %v", err)
 	}
 
 	s3Client := s3.NewFromConfig(awsConfig)
 
+	// Optional CloudFront key pair for signing URLs through the distribution
+	var cfSigner *cloudFrontSigner
+	cfKeyPairID := os.Getenv("CLOUDFRONT_KEY_PAIR_ID")
+	cfPrivateKeyPath := os.Getenv("CLOUDFRONT_PRIVATE_KEY_PATH")
+	if cfKeyPairID != "" && cfPrivateKeyPath != "" {
+		cfSigner, err = newCloudFrontSigner(cfKeyPairID, cfPrivateKeyPath)
+		if err != nil {
+			log.Fatalf("Couldn't load CloudFront private key: %v", err)
+		}
+	}
+
+	bindSignedURLsToIP := getEnvBool("SIGNED_URL_BIND_IP", false)
+	if bindSignedURLsToIP && cfSigner == nil {
+		log.Fatal("SIGNED_URL_BIND_IP requires CLOUDFRONT_KEY_PAIR_ID and CLOUDFRONT_PRIVATE_KEY_PATH")
+	}
+
+	videoDelivery := getEnvString("VIDEO_DELIVERY", videoDeliveryRedirect)
+	if !isValidVideoDelivery(videoDelivery) {
+		log.Fatalf("VIDEO_DELIVERY must be redirect or proxy, got %q", videoDelivery)
+	}
+
+	renditions := getEnvList("RENDITIONS", nil)
+	for _, name := range renditions {
+		_, err := parseRenditionHeight(name)
+		if err != nil {
+			log.Fatalf("Invalid RENDITIONS: %v", err)
+		}
+	}
+
+	maxVideoResolution := 0
+	if value := os.Getenv("MAX_VIDEO_RESOLUTION"); value != "" {
+		maxVideoResolution, err = parseRenditionHeight(value)
+		if err != nil {
+			log.Fatalf("Invalid MAX_VIDEO_RESOLUTION: %v", err)
+		}
+	}
+
+	processingProfiles, err := parseProcessingProfiles(os.Getenv("PROCESSING_PROFILES"))
+	if err != nil {
+		log.Fatalf("Invalid PROCESSING_PROFILES: %v", err)
+	}
+	defaultProfile := getEnvString("DEFAULT_PROCESSING_PROFILE", defaultProcessingProfile)
+	if _, ok := processingProfiles[defaultProfile]; !ok && defaultProfile != defaultProcessingProfile {
+		log.Fatalf("DEFAULT_PROCESSING_PROFILE %q isn't defined in PROCESSING_PROFILES", defaultProfile)
+	}
+
+	contactSheetColumns := getEnvInt("CONTACT_SHEET_COLUMNS", 4)
+	contactSheetRows := getEnvInt("CONTACT_SHEET_ROWS", 4)
+	contactSheetTileWidth := getEnvInt("CONTACT_SHEET_TILE_WIDTH", 320)
+	err = validateContactSheetGrid(contactSheetColumns, contactSheetRows, contactSheetTileWidth)
+	if err != nil {
+		log.Fatalf("Invalid contact sheet settings: %v", err)
+	}
+
+	previewLoopLength := getEnvDuration("PREVIEW_LOOP_LENGTH", 3*time.Second)
+	previewLoopWidth := getEnvInt("PREVIEW_LOOP_WIDTH", 320)
+	err = validatePreviewLoop(previewLoopLength, previewLoopWidth)
+	if err != nil {
+		log.Fatalf("Invalid preview loop settings: %v", err)
+	}
+
+	thumbnailShape, err := parseThumbnailShape(os.Getenv("THUMBNAIL_ASPECT"), getEnvString("THUMBNAIL_FIT", thumbnailFitCrop))
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_ASPECT or THUMBNAIL_FIT: %v", err)
+	}
+
+	aspectStorage, err := parseAspectStorage(getEnvMap("ASPECT_KEY_PREFIXES", nil), getEnvMap("ASPECT_BUCKETS", nil), getEnvMap("ASPECT_STORAGE_CLASSES", nil))
+	if err != nil {
+		log.Fatalf("Invalid aspect storage settings: %v", err)
+	}
+	assetStorage, err := parseAssetStorage(getEnvMap("ASSET_KEY_PREFIXES", nil), getEnvMap("ASSET_BUCKETS", nil), getEnvMap("ASSET_STORAGE_CLASSES", nil))
+	if err != nil {
+		log.Fatalf("Invalid asset storage settings: %v", err)
+	}
+
+	autoThumbnailPositions, err := parseAutoThumbnailPositions(getEnvString("AUTO_THUMBNAIL_AT", "1"), getEnvMap("AUTO_THUMBNAIL_AT_BY_ASPECT", nil))
+	if err != nil {
+		log.Fatalf("Invalid AUTO_THUMBNAIL_AT or AUTO_THUMBNAIL_AT_BY_ASPECT: %v", err)
+	}
+
+	similarVideoMaxDistance := getEnvInt("SIMILAR_VIDEO_MAX_DISTANCE", 24)
+	if similarVideoMaxDistance < 0 || similarVideoMaxDistance > maxPerceptualHashDistance {
+		log.Fatalf("SIMILAR_VIDEO_MAX_DISTANCE must be between 0 and %d", maxPerceptualHashDistance)
+	}
+
+	progressiveThumbnails := getEnvBool("THUMBNAIL_PROGRESSIVE", true)
+	if progressiveThumbnails && !progressiveJPEGSupported() {
+		log.Printf("jpegtran not found, thumbnails will be baseline JPEGs")
+		progressiveThumbnails = false
+	}
+
+	uploadTempDir := os.Getenv("UPLOAD_TEMP_DIR")
+	maxVideoUploadBytes := getEnvInt64("MAX_VIDEO_UPLOAD_BYTES", 1<<30)
+	if maxVideoUploadBytes <= 0 {
+		log.Fatalf("MAX_VIDEO_UPLOAD_BYTES must be positive, got %d", maxVideoUploadBytes)
+	}
+
+	allowedVideoContainers := getEnvList("ALLOWED_VIDEO_CONTAINERS", supportedVideoContainers)
+	err = validateVideoContainers(allowedVideoContainers)
+	if err != nil {
+		log.Fatalf("Invalid ALLOWED_VIDEO_CONTAINERS: %v", err)
+	}
+
+	draftStorageClass, err := parseStorageClass(os.Getenv("DRAFT_STORAGE_CLASS"))
+	if err != nil {
+		log.Fatalf("Invalid DRAFT_STORAGE_CLASS: %v", err)
+	}
+
+	hdrHandling := getEnvString("HDR_HANDLING", hdrHandlingFlag)
+	if !isValidHDRHandling(hdrHandling) {
+		log.Fatalf("HDR_HANDLING must be flag, reject or tonemap, got %q", hdrHandling)
+	}
+
+	polyglotCheck := getEnvString("UPLOAD_POLYGLOT_CHECK", polyglotCheckStandard)
+	if !isValidPolyglotCheck(polyglotCheck) {
+		log.Fatalf("UPLOAD_POLYGLOT_CHECK must be off, standard or strict, got %q", polyglotCheck)
+	}
+
+	setFFmpegConcurrency(getEnvInt("FFMPEG_CONCURRENCY", runtime.NumCPU()))
+	err = setMaxPresignExpiry(getEnvDuration("PRESIGN_MAX_EXPIRY", sigV4MaxPresignExpiry))
+	if err != nil {
+		log.Fatalf("Invalid PRESIGN_MAX_EXPIRY: %v", err)
+	}
+
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
+		jwtKeys:          jwtKeys,
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
@@ -97,13 +374,144 @@ func main() {
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
-		s3Client: s3Client,
+		s3Client:         s3Client,
+		s3Presign:        s3.NewPresignClient(s3Client),
+		storageClients:   newStorageClients(awsConfig),
+
+		uploadTokenExpiry: getEnvDuration("UPLOAD_TOKEN_EXPIRY", 15*time.Minute),
+
+		readTimeout:       getEnvDuration("SERVER_READ_TIMEOUT", defaultReadTimeout),
+		readHeaderTimeout: getEnvDuration("SERVER_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		writeTimeout:      getEnvDuration("SERVER_WRITE_TIMEOUT", defaultWriteTimeout),
+		idleTimeout:       getEnvDuration("SERVER_IDLE_TIMEOUT", defaultIdleTimeout),
+		maxHeaderBytes:    getEnvInt("SERVER_MAX_HEADER_BYTES", defaultMaxHeaderBytes),
+		enableHTTP2:       getEnvBool("SERVER_ENABLE_HTTP2", true),
+
+		enableCompression:  getEnvBool("COMPRESSION_ENABLED", true),
+		compressionMinSize: getEnvInt("COMPRESSION_MIN_SIZE", 1024),
+
+		videoContentTypeAliases: getEnvMap("VIDEO_CONTENT_TYPE_ALIASES", defaultVideoContentTypeAliases),
+		allowedVideoContainers:  allowedVideoContainers,
+
+		videoFormField:     getEnvString("VIDEO_UPLOAD_FIELD", "video"),
+		thumbnailFormField: getEnvString("THUMBNAIL_UPLOAD_FIELD", "thumbnail"),
+
+		allowedVideoCodecs:          getEnvList("ALLOWED_VIDEO_CODECS", []string{"h264"}),
+		allowedAudioCodecs:          getEnvList("ALLOWED_AUDIO_CODECS", []string{"aac", "mp3"}),
+		transcodeIncompatibleCodecs: getEnvBool("TRANSCODE_INCOMPATIBLE_CODECS", false),
+		hdrHandling:                 hdrHandling,
+
+		ffprobeAttempts:   getEnvInt("FFPROBE_ATTEMPTS", 3),
+		ffprobeRetryDelay: getEnvDuration("FFPROBE_RETRY_DELAY", 500*time.Millisecond),
+
+		enableScrubPreviews:   getEnvBool("SCRUB_PREVIEW_ENABLED", true),
+		scrubPreviewInterval:  getEnvDuration("SCRUB_PREVIEW_INTERVAL", 10*time.Second),
+		scrubPreviewColumns:   getEnvInt("SCRUB_PREVIEW_COLUMNS", 10),
+		scrubPreviewTileWidth: getEnvInt("SCRUB_PREVIEW_TILE_WIDTH", 160),
+		scrubPreviewMaxHeight: getEnvInt("SCRUB_PREVIEW_MAX_SPRITE_HEIGHT", 4096),
+
+		enableAutoThumbnails:        getEnvBool("AUTO_THUMBNAIL_ENABLED", true),
+		autoThumbnailPositions:      autoThumbnailPositions,
+		enableThumbnailPlaceholders: getEnvBool("THUMBNAIL_PLACEHOLDER_ENABLED", true),
+		thumbnailShape:              thumbnailShape,
+		progressiveThumbnails:       progressiveThumbnails,
+
+		contactSheetColumns:   contactSheetColumns,
+		contactSheetRows:      contactSheetRows,
+		contactSheetTileWidth: contactSheetTileWidth,
+
+		previewLoopLength: previewLoopLength,
+		previewLoopWidth:  previewLoopWidth,
+
+		renditions:     renditions,
+		renditionSlots: make(chan struct{}, max(getEnvInt("RENDITION_CONCURRENCY", 2), 1)),
+
+		enableKeyframeIndex: getEnvBool("KEYFRAME_INDEX_ENABLED", true),
+		maxStoredKeyframes:  getEnvInt("KEYFRAMES_MAX_STORED", 5000),
+
+		enablePerceptualHash:    getEnvBool("PERCEPTUAL_HASH_ENABLED", true),
+		similarVideoMaxDistance: similarVideoMaxDistance,
+
+		processingProfiles:       processingProfiles,
+		defaultProcessingProfile: defaultProfile,
+
+		shareLinkDefaultExpiry: getEnvDuration("SHARE_LINK_DEFAULT_EXPIRY", 24*time.Hour),
+		shareLinkMaxExpiry:     getEnvDuration("SHARE_LINK_MAX_EXPIRY", 30*24*time.Hour),
+		shareLinkLimiter:       newRateLimiter(getEnvInt("SHARE_LINK_RATE_LIMIT", 30), time.Minute),
+
+		enableStreamTranscode:  getEnvBool("STREAM_TRANSCODE_ENABLED", false),
+		streamTranscodeSlots:   make(chan struct{}, max(getEnvInt("STREAM_TRANSCODE_MAX_CONCURRENT", 2), 1)),
+		streamTranscodeTimeout: getEnvDuration("STREAM_TRANSCODE_TIMEOUT", 10*time.Minute),
+
+		maxVideoResolution: maxVideoResolution,
+
+		adminAPIKey: os.Getenv("ADMIN_API_KEY"),
+
+		maintenanceMode: newMaintenanceMode(getEnvBool("MAINTENANCE_MODE", false), getEnvDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute)),
+
+		polyglotCheck: polyglotCheck,
+
+		cloudFrontSigner:        cfSigner,
+		bindSignedURLsToIP:      bindSignedURLsToIP,
+		cleanPublicURLs:         getEnvBool("PUBLIC_CLEAN_URLS", false),
+		videoDelivery:           videoDelivery,
+		hlsCookieDomain:         os.Getenv("HLS_COOKIE_DOMAIN"),
+		signedURLAllowedOrigins: getEnvList("SIGNED_URL_ALLOWED_ORIGINS", nil),
+		signedURLs:              newSignedURLCache(getEnvInt("SIGNED_URL_CACHE_SIZE", 10000)),
+
+		views: newViewTracker(db, getEnvDuration("VIEW_DEDUP_WINDOW", 30*time.Minute)),
+
+		trackObjectVersions: getEnvBool("TRACK_OBJECT_VERSIONS", false),
+		// Stored keys are random, so an object's content never changes under its URL
+		s3CacheControl: getEnvString("S3_CACHE_CONTROL", "public, max-age=31536000, immutable"),
+		aspectStorage:  aspectStorage,
+		assetStorage:   assetStorage,
+
+		draftTTL:          getEnvDuration("DRAFT_TTL", 7*24*time.Hour),
+		draftStorageClass: draftStorageClass,
+
+		uploadTempDir:       uploadTempDir,
+		maxVideoUploadBytes: maxVideoUploadBytes,
+		uploadMinFreeDisk:   getEnvInt64("UPLOAD_MIN_FREE_DISK", 256<<20),
+		s3UploadTimeout:     getEnvDuration("S3_UPLOAD_TIMEOUT", 30*time.Minute),
+
+		uploads:        newUploadTracker(db, getEnvDuration("UPLOAD_STATUS_TTL", 10*time.Minute), getEnvDuration("TUS_UPLOAD_EXPIRY", 24*time.Hour)),
+		processingLogs: newProcessingLogStore(getEnvDuration("PROCESSING_LOG_TTL", 24*time.Hour), uploadTempDir),
+
+		debugFailedUploadsDir: os.Getenv("DEBUG_FAILED_UPLOADS_DIR"),
+
+		tusUploads:      newTusStore(),
+		tusUploadExpiry: getEnvDuration("TUS_UPLOAD_EXPIRY", 24*time.Hour),
+		tusMaxChunkSize: getEnvInt64("TUS_MAX_CHUNK_SIZE", 64<<20),
+		rangeUploads:    newTusStore(),
+
+		backgroundJobs:   newWorkerPool(getEnvInt("BACKGROUND_JOB_WORKERS", 2), getEnvDuration("BACKGROUND_JOB_INTERVAL", time.Second), getEnvInt("BACKGROUND_JOB_MAX_ATTEMPTS", 3), getEnvDuration("BACKGROUND_JOB_RETRY_BACKOFF", 10*time.Second)),
+		thumbnailBatches: newThumbnailBatchStore(thumbnailBatchTTL),
 	}
 
 	err = cfg.ensureAssetsDir()
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
+	if cfg.uploadTempDir != "" {
+		err = os.MkdirAll(cfg.uploadTempDir, 0755)
+		if err != nil {
+			log.Fatalf("Couldn't create upload temp directory: %v", err)
+		}
+	}
+
+	cfg.recoverUploads()
+
+	cfg.tusUploads.startJanitor(time.Minute)
+	cfg.rangeUploads.startJanitor(time.Minute)
+	cfg.startMultipartJanitor(time.Hour, getEnvDuration("MULTIPART_UPLOAD_EXPIRY", 24*time.Hour))
+	cfg.views.start(10 * time.Second)
+	cfg.uploads.startJanitor(time.Minute)
+	cfg.processingLogs.startJanitor(time.Minute)
+	cfg.shareLinkLimiter.startJanitor(time.Minute)
+	cfg.thumbnailBatches.startJanitor(time.Minute)
+	cfg.startPublishScheduler(getEnvDuration("PUBLISH_SCHEDULER_INTERVAL", 30*time.Second))
+	cfg.startDraftJanitor(getEnvDuration("DRAFT_CLEANUP_INTERVAL", time.Hour))
 
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
@@ -112,25 +520,96 @@ func main() {
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	handleAPI(mux, "POST /login", cfg.handlerLogin)
+	handleAPI(mux, "POST /refresh", cfg.handlerRefresh)
+	handleAPI(mux, "POST /revoke", cfg.handlerRevoke)
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	handleAPI(mux, "POST /users", cfg.handlerUsersCreate)
+	handleAPI(mux, "POST /api_keys", cfg.handlerAPIKeyCreate)
+	handleAPI(mux, "GET /api_keys", cfg.handlerAPIKeysList)
+	handleAPI(mux, "DELETE /api_keys/{keyID}", cfg.handlerAPIKeyRevoke)
+
+	handleAPI(mux, "POST /videos", cfg.handlerVideoMetaCreate)
+	handleAPI(mux, "POST /videos/aspect_preview", cfg.handlerAspectPreview)
+	handleAPI(mux, "POST /thumbnail_upload/{videoID}", cfg.pausedDuringMaintenance(cfg.handlerUploadThumbnail))
+	handleAPI(mux, "POST /video_upload/{videoID}", cfg.pausedDuringMaintenance(cfg.handlerUploadVideo))
+	handleAPI(mux, "POST /videos/{videoID}/upload-token", cfg.handlerUploadTokenCreate)
+	handleAPI(mux, "PUT /video_upload/{videoID}", cfg.pausedDuringMaintenance(cfg.handlerUploadVideoRange))
+	handleAPI(mux, "POST /videos/{videoID}/upload/multipart", cfg.pausedDuringMaintenance(cfg.handlerMultipartCreate))
+	handleAPI(mux, "GET /videos/{videoID}/upload/resume", cfg.handlerMultipartResume)
+	handleAPI(mux, "POST /videos/{videoID}/upload/multipart/complete", cfg.pausedDuringMaintenance(cfg.handlerMultipartComplete))
+	handleAPI(mux, "GET /videos", cfg.requireAllowedOrigin(cfg.handlerVideosRetrieve))
+	handleAPI(mux, "GET /videos/timeline", cfg.handlerVideoTimeline)
+	handleAPI(mux, "GET /videos/{videoID}", cfg.requireAllowedOrigin(cfg.handlerVideoGet))
+	handleAPI(mux, "DELETE /videos/{videoID}", cfg.handlerVideoMetaDelete)
+	handleAPI(mux, "POST /videos/visibility", cfg.handlerVideosVisibility)
+	handleAPI(mux, "PUT /videos/{videoID}/visibility", cfg.handlerVideoVisibility)
+	handleAPI(mux, "POST /videos/{videoID}/publish", cfg.pausedDuringMaintenance(cfg.handlerVideoPublish))
+	handleAPI(mux, "GET /videos/{videoID}/stats", cfg.handlerVideoStats)
+	handleAPI(mux, "POST /videos/{videoID}/prepare", cfg.requireAllowedOrigin(cfg.handlerVideoPrepare))
+	handleAPI(mux, "GET /videos/{videoID}/processing-log", cfg.handlerProcessingLog)
+	handleAPI(mux, "GET /videos/{videoID}/object", cfg.handlerVideoObject)
+	handleAPI(mux, "POST /videos/{videoID}/shares", cfg.handlerShareLinkCreate)
+	handleAPI(mux, "GET /videos/{videoID}/shares", cfg.handlerShareLinksList)
+	handleAPI(mux, "DELETE /videos/{videoID}/shares/{shareID}", cfg.handlerShareLinkRevoke)
+	handleAPI(mux, "GET /uploads/active", cfg.handlerUploadsActive)
+	handleAPI(mux, "GET /upload/config", cfg.handlerUploadConfig)
+	handleAPI(mux, "POST /videos/{videoID}/copy", cfg.pausedDuringMaintenance(cfg.handlerVideoCopy))
+	handleAPI(mux, "POST /videos/{videoID}/contact-sheet", cfg.pausedDuringMaintenance(cfg.handlerContactSheet))
+	handleAPI(mux, "POST /videos/{videoID}/preview", cfg.pausedDuringMaintenance(cfg.handlerPreviewLoop))
+	handleAPI(mux, "POST /videos/{videoID}/thumbnail-from-frame", cfg.pausedDuringMaintenance(cfg.handlerThumbnailFromFrame))
+	handleAPI(mux, "POST /videos/{videoID}/repair", cfg.pausedDuringMaintenance(cfg.handlerVideoRepair))
+	handleAPI(mux, "GET /videos/{videoID}/chapters", cfg.handlerVideoChaptersGet)
+	handleAPI(mux, "GET /videos/{videoID}/keyframes", cfg.handlerVideoKeyframes)
+	handleAPI(mux, "GET /videos/{videoID}/manifest", cfg.requireAllowedOrigin(cfg.handlerVideoManifest))
+	handleAPI(mux, "GET /videos/{videoID}/play", cfg.requireAllowedOrigin(cfg.handlerVideoPlay))
+	handleAPI(mux, "GET /videos/{videoID}/hls-auth", cfg.requireAllowedOrigin(cfg.handlerVideoHLSAuth))
+	handleAPI(mux, "GET /videos/{videoID}/thumbnails.vtt", cfg.requireAllowedOrigin(cfg.handlerVideoThumbnailTrack))
+	handleAPI(mux, "GET /videos/{videoID}/similar", cfg.handlerVideoSimilar)
+	handleAPI(mux, "GET /videos/{videoID}/probe", cfg.handlerVideoProbe)
+	handleAPI(mux, "PUT /videos/{videoID}/chapters", cfg.pausedDuringMaintenance(cfg.handlerVideoChaptersPut))
+	handleAPI(mux, "DELETE /videos/{videoID}/chapters", cfg.pausedDuringMaintenance(cfg.handlerVideoChaptersDelete))
+	if cfg.enableStreamTranscode {
+		handleAPI(mux, "GET /videos/{videoID}/transcode", cfg.handlerVideoTranscode)
+	}
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	handleAPI(mux, "OPTIONS /tus", cfg.handlerTusOptions)
+	handleAPI(mux, "OPTIONS /tus/{uploadID}", cfg.handlerTusOptions)
+	handleAPI(mux, "POST /tus", cfg.pausedDuringMaintenance(cfg.handlerTusCreate))
+	handleAPI(mux, "HEAD /tus/{uploadID}", cfg.handlerTusHead)
+	handleAPI(mux, "PATCH /tus/{uploadID}", cfg.pausedDuringMaintenance(cfg.handlerTusPatch))
+	handleAPI(mux, "DELETE /tus/{uploadID}", cfg.handlerTusDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/blocked_hashes", cfg.handlerBlockedHashesList)
+	mux.HandleFunc("POST /admin/blocked_hashes", cfg.handlerBlockedHashesCreate)
+	mux.HandleFunc("DELETE /admin/blocked_hashes/{hash}", cfg.handlerBlockedHashesDelete)
+	mux.HandleFunc("POST /admin/reconcile", cfg.handlerReconcile)
+	mux.HandleFunc("POST /admin/purge_versions", cfg.handlerPurgeVersions)
+	mux.HandleFunc("GET /admin/maintenance", cfg.handlerMaintenanceGet)
+	mux.HandleFunc("PUT /admin/maintenance", cfg.handlerMaintenanceSet)
+	mux.HandleFunc("GET /admin/users/{userID}/storage", cfg.handlerUserStorageGet)
+	mux.HandleFunc("PUT /admin/users/{userID}/storage", cfg.handlerUserStoragePut)
+	mux.HandleFunc("DELETE /admin/users/{userID}/storage", cfg.handlerUserStorageDelete)
+	mux.HandleFunc("POST /admin/thumbnails/regenerate", cfg.pausedDuringMaintenance(cfg.handlerThumbnailBatchCreate))
+	mux.HandleFunc("GET /admin/thumbnails/regenerate/{batchID}", cfg.handlerThumbnailBatchGet)
+	mux.HandleFunc("GET /admin/jobs/failed", cfg.handlerFailedJobsList)
+	mux.HandleFunc("GET /admin/audit", cfg.handlerAuditList)
 
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+	mux.HandleFunc("GET /version", cfg.handlerVersion)
+
+	// Share links are opened by people, so they live outside the API
+	mux.HandleFunc("GET /share/{token}", cfg.handlerShareLinkOpen)
+
+	// Recovery sits inside compression so a panic's 500 isn't preceded by
+	// whatever the compressor had buffered
+	var handler http.Handler = recoverMiddleware(cfg.apiKeyMiddleware(mux))
+	if cfg.enableCompression {
+		handler = compressionMiddleware(cfg.compressionMinSize, handler)
 	}
+	handler = requestIDMiddleware(handler)
+
+	srv := cfg.newServer(handler)
 
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
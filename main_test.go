@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const testBucket = "tubely-test"
+
+// An apiConfig backed by a fresh database in a temp directory. S3 clients have
+// static credentials, so URLs can be presigned without reaching AWS.
+func newTestAPIConfig(t testing.TB) *apiConfig {
+	t.Helper()
+
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't create database: %v", err)
+	}
+
+	s3Client := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKIDTEST", "secret", ""),
+	})
+	return &apiConfig{
+		db:               db,
+		jwtKeys:          auth.JWTKeys{Current: auth.NewJWTKey("test-secret")},
+		s3Bucket:         testBucket,
+		s3Region:         "us-east-1",
+		s3Client:         s3Client,
+		s3Presign:        s3.NewPresignClient(s3Client),
+		shareLinkLimiter: newRateLimiter(100, time.Minute),
+		videoDelivery:    videoDeliveryRedirect,
+		views:            newViewTracker(db, 30*time.Minute),
+		signedURLs:       newSignedURLCache(100),
+	}
+}
+
+// Creates a user owning an uploaded video with the given visibility. Returns
+// the video and an access token for its owner.
+func createTestVideo(t testing.TB, cfg *apiConfig, visibility string) (database.Video, string) {
+	t.Helper()
+
+	user, err := cfg.db.CreateUser(database.CreateUserParams{
+		Email:    uuid.NewString() + "@example.com",
+		Password: "hash",
+	})
+	if err != nil {
+		t.Fatalf("couldn't create user: %v", err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "Test video",
+		UserID:     user.ID,
+		Visibility: visibility,
+	})
+	if err != nil {
+		t.Fatalf("couldn't create video: %v", err)
+	}
+	videoURL := testBucket + ",landscape/" + video.ID.String() + ".mp4"
+	video.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatalf("couldn't update video: %v", err)
+	}
+
+	token, err := auth.MakeJWT(user.ID, cfg.jwtKeys, time.Hour)
+	if err != nil {
+		t.Fatalf("couldn't make JWT: %v", err)
+	}
+	return video, token
+}
+
+// Routes the requests a test makes, with the same patterns as main
+func newTestMux(routes map[string]http.HandlerFunc) *http.ServeMux {
+	mux := http.NewServeMux()
+	for pattern, handler := range routes {
+		mux.HandleFunc(pattern, handler)
+	}
+	return mux
+}
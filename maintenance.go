@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// While maintenance mode is on, requests that write to storage (uploads, copies,
+// generated assets) are turned away with a 503 so S3 can be migrated or worked on;
+// everything that only reads keeps working. It's toggled with PUT
+// /admin/maintenance and held in memory, so each instance has to be told.
+type maintenanceMode struct {
+	retryAfter time.Duration
+
+	mu    sync.RWMutex
+	state maintenanceState
+}
+
+type maintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message"`
+	Since   *time.Time `json:"since"`
+}
+
+const defaultMaintenanceMessage = "Uploads are paused for maintenance, try again later"
+
+func newMaintenanceMode(enabled bool, retryAfter time.Duration) *maintenanceMode {
+	m := &maintenanceMode{retryAfter: retryAfter}
+	m.set(enabled, "")
+	return m
+}
+
+func (m *maintenanceMode) get() maintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+func (m *maintenanceMode) set(enabled bool, message string) maintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !enabled {
+		m.state = maintenanceState{}
+		return m.state
+	}
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	since := time.Now().UTC()
+	if m.state.Enabled {
+		since = *m.state.Since
+	}
+	m.state = maintenanceState{Enabled: true, Message: message, Since: &since}
+	return m.state
+}
+
+// Wraps a handler that writes to storage so it's refused during maintenance
+func (cfg *apiConfig) pausedDuringMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := cfg.maintenanceMode.get()
+		if state.Enabled {
+			w.Header().Set("Retry-After", strconv.Itoa(int(cfg.maintenanceMode.retryAfter.Seconds())))
+			respondWithError(w, http.StatusServiceUnavailable, state.Message, nil)
+			return
+		}
+		next(w, r)
+	}
+}
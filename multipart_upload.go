@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+)
+
+/*
+Direct multipart uploads
+
+Large files can go straight to S3 instead of through the server:
+
+1. POST /videos/{videoID}/upload/multipart starts an S3 multipart upload of a
+   staging object and returns presigned URLs for the number of parts asked for.
+2. The client PUTs each part (at least 5MB, except the last) to its URL.
+3. POST /videos/{videoID}/upload/multipart/complete assembles the parts, and the
+   staging object goes through the regular processing pipeline.
+
+A client that crashed and lost its upload ID asks
+GET /videos/{videoID}/upload/resume, which finds the upload in progress for the
+video's staging key and lists the parts S3 already has, along with fresh URLs
+for the rest. Uploads that aren't completed within MULTIPART_UPLOAD_EXPIRY are
+aborted so their parts stop taking up storage.
+*/
+
+const (
+	multipartStagingPrefix = "multipart"
+	// S3 allows at most 10,000 parts
+	maxMultipartParts      = 10000
+	multipartPartURLExpiry = time.Hour
+)
+
+// Where a video's direct upload is assembled before it's processed
+func multipartStagingKey(videoID uuid.UUID) (string, error) {
+	return buildObjectKey(multipartStagingPrefix, videoID.String()+".mp4")
+}
+
+type multipartPartURL struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+// Presigns PUTs for the given part numbers of an upload of key
+func (cfg *apiConfig) presignUploadParts(ctx context.Context, key, uploadID string, partNumbers []int32) ([]multipartPartURL, error) {
+	urls := make([]multipartPartURL, 0, len(partNumbers))
+	for _, partNumber := range partNumbers {
+		request, err := cfg.s3Presign.PresignUploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(cfg.s3Bucket),
+			Key:        aws.String(key),
+			UploadId:   aws.String(uploadID),
+			PartNumber: aws.Int32(partNumber),
+		}, s3.WithPresignExpires(multipartPartURLExpiry))
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign part %d: %w", partNumber, err)
+		}
+		urls = append(urls, multipartPartURL{PartNumber: partNumber, URL: request.URL})
+	}
+	return urls, nil
+}
+
+// Finds the most recently started multipart upload of key. Returns "" if there
+// is none.
+func (cfg *apiConfig) findMultipartUpload(ctx context.Context, key string) (string, error) {
+	var latest types.MultipartUpload
+	paginator := s3.NewListMultipartUploadsPaginator(cfg.s3Client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(cfg.s3Bucket),
+		Prefix: aws.String(key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+		for _, upload := range page.Uploads {
+			if aws.ToString(upload.Key) != key {
+				continue
+			}
+			if latest.Initiated == nil || aws.ToTime(upload.Initiated).After(aws.ToTime(latest.Initiated)) {
+				latest = upload
+			}
+		}
+	}
+	return aws.ToString(latest.UploadId), nil
+}
+
+// Lists the parts S3 has received for an upload, in order
+func (cfg *apiConfig) listUploadedParts(ctx context.Context, key, uploadID string) ([]types.Part, error) {
+	var parts []types.Part
+	paginator := s3.NewListPartsPaginator(cfg.s3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(cfg.s3Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list parts: %w", err)
+		}
+		parts = append(parts, page.Parts...)
+	}
+	return parts, nil
+}
+
+// Downloads a staging object to a temp file for processing. The caller removes it.
+func (cfg *apiConfig) downloadStagingObject(ctx context.Context, key string) (string, error) {
+	output, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.s3Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	defer output.Body.Close()
+
+	tempFile, err := os.CreateTemp(cfg.uploadTempDir, "tubely-multipart-*")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+	_, err = io.Copy(tempFile, output.Body)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return tempFile.Name(), nil
+}
+
+// Periodically aborts direct uploads started more than expiry ago
+func (cfg *apiConfig) startMultipartJanitor(interval, expiry time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			ctx := context.Background()
+			paginator := s3.NewListMultipartUploadsPaginator(cfg.s3Client, &s3.ListMultipartUploadsInput{
+				Bucket: aws.String(cfg.s3Bucket),
+				Prefix: aws.String(multipartStagingPrefix + "/"),
+			})
+			aborted := 0
+			for paginator.HasMorePages() {
+				page, err := paginator.NextPage(ctx)
+				if err != nil {
+					log.Printf("Couldn't list multipart uploads: %v", err)
+					break
+				}
+				for _, upload := range page.Uploads {
+					if time.Since(aws.ToTime(upload.Initiated)) < expiry {
+						continue
+					}
+					_, err := cfg.s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+						Bucket:   aws.String(cfg.s3Bucket),
+						Key:      upload.Key,
+						UploadId: upload.UploadId,
+					})
+					if err != nil {
+						log.Printf("Couldn't abort multipart upload of %s: %v", aws.ToString(upload.Key), err)
+						continue
+					}
+					aborted++
+				}
+			}
+			if aborted > 0 {
+				log.Printf("Aborted %d abandoned multipart uploads", aborted)
+			}
+		}
+	}()
+}
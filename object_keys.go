@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+/*
+Object keys
+
+Every key we write goes through buildObjectKey. Today keys are made of a
+configured prefix, random names and fixed file names, but anything that ever
+comes from a user (an original filename, a per-user prefix) must not be able to
+add segments, climb out of its prefix with "..", or smuggle in control
+characters or anything else S3 and CloudFront URLs treat specially. Components
+are sanitized down to letters, digits, '-', '_' and '.', and can't start with a
+dot.
+*/
+
+const (
+	// S3 keys are at most 1024 bytes
+	maxObjectKeyLen = 1024
+	// Longer components are cut, so one can't use up the whole key
+	maxKeyComponentLen = 255
+)
+
+// Makes s safe as a single key segment: characters other than ASCII letters,
+// digits, '-', '_' and '.' become '-', leading dots are dropped (so there are no
+// "." or ".." segments) and it's cut to maxKeyComponentLen bytes. Returns ""
+// if nothing is left.
+func sanitizeKeyComponent(s string) string {
+	var b strings.Builder
+	for _, c := range strings.TrimLeft(s, ".") {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('-')
+		}
+		if b.Len() >= maxKeyComponentLen {
+			break
+		}
+	}
+	return b.String()
+}
+
+// Builds an object key under prefix. The prefix is one we built before (an
+// aspect ratio directory, a video's asset prefix, ...) and may have several
+// segments, which have to be safe already. Each component is sanitized into
+// exactly one more segment.
+func buildObjectKey(prefix string, components ...string) (string, error) {
+	var segments []string
+	if prefix != "" {
+		for _, segment := range strings.Split(prefix, "/") {
+			if segment == "" || sanitizeKeyComponent(segment) != segment {
+				return "", fmt.Errorf("unsafe key prefix %q", prefix)
+			}
+			segments = append(segments, segment)
+		}
+	}
+	for _, component := range components {
+		segment := sanitizeKeyComponent(component)
+		if segment == "" {
+			return "", fmt.Errorf("key component %q is empty once sanitized", component)
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "", errors.New("empty object key")
+	}
+
+	key := strings.Join(segments, "/")
+	if len(key) > maxObjectKeyLen {
+		return "", fmt.Errorf("object key is %d bytes, more than the %d S3 allows", len(key), maxObjectKeyLen)
+	}
+	return key, nil
+}
+
+// Where the files derived from a video are stored: its key without the
+// extension, e.g. landscape/abc for landscape/abc.mp4
+func assetPrefix(videoKey string) string {
+	return strings.TrimSuffix(videoKey, path.Ext(videoKey))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+/*
+Object metadata
+
+Uploads can carry a language and custom key-value metadata that is stored on
+the S3 object itself (Content-Language and x-amz-meta-* headers), so it travels
+with the file to whatever else reads the bucket.
+
+S3 limits user metadata to 2KB in total, counted as the UTF-8 bytes of every
+key and value. Keys are sent as header names, which S3 lowercases, so they're
+restricted to lowercase letters, digits and dashes. Values are restricted to
+printable ASCII, since anything else comes back RFC 2047 encoded.
+*/
+
+const maxObjectMetadataSize = 2 << 10
+
+// Language tags like "en", "pt-BR" or "zh-Hant-TW"
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+var metadataKeyPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+type objectMetadata struct {
+	Language string            `json:"language,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Builds and validates object metadata from a language tag and a JSON object of
+// string values, either of which may be empty
+func parseObjectMetadata(language, metadataJSON string) (objectMetadata, error) {
+	meta := objectMetadata{Language: language}
+	if metadataJSON != "" {
+		err := json.Unmarshal([]byte(metadataJSON), &meta.Metadata)
+		if err != nil {
+			return objectMetadata{}, fmt.Errorf("metadata must be a JSON object of strings: %w", err)
+		}
+	}
+	return meta, meta.validate()
+}
+
+func (m objectMetadata) validate() error {
+	if m.Language != "" && !languageTagPattern.MatchString(m.Language) {
+		return fmt.Errorf("invalid language %q, expected a tag like en or pt-BR", m.Language)
+	}
+
+	size := 0
+	for key, value := range m.Metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid metadata key %q, use lowercase letters, digits and dashes", key)
+		}
+		for _, c := range value {
+			if c < ' ' || c > '~' {
+				return fmt.Errorf("metadata value for %q must be printable ASCII", key)
+			}
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxObjectMetadataSize {
+		return fmt.Errorf("metadata is %d bytes, S3 allows at most %d", size, maxObjectMetadataSize)
+	}
+	return nil
+}
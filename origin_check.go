@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+Allowed origins
+
+Endpoints that hand out signed URLs can be limited to pages on approved sites,
+so another site can't pull signed links for its own player with XHR. The
+requesting page is taken from the Origin header, or from the Referer when a
+browser doesn't send an Origin. Requests from our own origin are always
+allowed, and requests with neither header (curl, server-side clients) are let
+through: the check can only hold browsers to it.
+
+SIGNED_URL_ALLOWED_ORIGINS lists origins like "https://example.com"; an entry
+like "https://*.example.com" also allows any subdomain. The check is off when
+the list is empty.
+*/
+
+// Whether origin ("scheme://host[:port]") is one of the allowed ones
+func originAllowed(origin string, allowed []string) bool {
+	for _, pattern := range allowed {
+		if strings.EqualFold(origin, pattern) {
+			return true
+		}
+		scheme, domain, ok := strings.Cut(pattern, "://*.")
+		if ok && strings.HasPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://") &&
+			strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// The origin of the page a request came from, and whether it said
+func requestOrigin(r *http.Request) (string, bool) {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin, true
+	}
+	referer, err := url.Parse(r.Header.Get("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return "", false
+	}
+	return referer.Scheme + "://" + referer.Host, true
+}
+
+// Wraps a handler that returns signed URLs so pages on sites that aren't allowed
+// get a 403 instead
+func (cfg *apiConfig) requireAllowedOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(cfg.signedURLAllowedOrigins) == 0 {
+			next(w, r)
+			return
+		}
+		origin, ok := requestOrigin(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			next(w, r)
+			return
+		}
+		if !originAllowed(origin, cfg.signedURLAllowedOrigins) {
+			respondWithError(w, http.StatusForbidden, "Origin not allowed", nil)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+/*
+Perceptual hashes
+
+A byte hash only matches identical files, so a re-encode of a video already on
+the site looks brand new. A perceptual hash (pHash) describes what a frame looks
+like instead: the frame is shrunk to 32x32 grayscale, a DCT is taken and each of
+the 64 lowest frequencies becomes one bit, set if it's above their median (the
+DC term, only the overall brightness, is left out of the median). Re-encoding,
+rescaling or recompressing a frame barely changes its low frequencies, so
+near-duplicates end up a few bits apart.
+
+A video's hash is the hashes of perceptualHashFrames frames sampled evenly
+through it, stored as hex. Two videos are compared by the total Hamming
+distance between their frames; GET /videos/{videoID}/similar lists the videos
+within SIMILAR_VIDEO_MAX_DISTANCE of one.
+*/
+
+const (
+	perceptualHashFrames = 4
+	// Side of the grayscale image the DCT is taken of
+	perceptualHashImageSize = 32
+	// Side of the block of low frequencies kept, one bit each
+	perceptualHashBlockSize = 8
+	// The most two videos' hashes can differ by
+	maxPerceptualHashDistance = perceptualHashFrames * perceptualHashBlockSize * perceptualHashBlockSize
+)
+
+// Hashes frames sampled evenly through the video (at 1/5, 2/5, ... of its length
+// for 4 frames), skipping the very start and end where intros and credits look
+// alike across videos
+func (cfg *apiConfig) videoPerceptualHash(videoPath string) (string, error) {
+	duration, err := getVideoDuration(videoPath)
+	if err != nil {
+		return "", err
+	}
+	framesDir, err := os.MkdirTemp(cfg.uploadTempDir, "tubely-phash-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(framesDir)
+
+	hash := make([]byte, 0, perceptualHashFrames*8)
+	for i := range perceptualHashFrames {
+		at := duration * float64(i+1) / float64(perceptualHashFrames+1)
+		framePath := filepath.Join(framesDir, "frame_"+strconv.Itoa(i)+".jpg")
+		err := extractFrame(videoPath, at, framePath)
+		if err != nil {
+			return "", err
+		}
+		img, err := decodeJPEGFile(framePath)
+		if err != nil {
+			return "", err
+		}
+		frameHash := imagePerceptualHash(img)
+		for shift := 56; shift >= 0; shift -= 8 {
+			hash = append(hash, byte(frameHash>>shift))
+		}
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// The 64-bit pHash of an image
+func imagePerceptualHash(img image.Image) uint64 {
+	const n = perceptualHashImageSize
+	const k = perceptualHashBlockSize
+
+	// Average the source pixels falling in each cell of an n x n grayscale grid
+	var luma, counts [n * n]float64
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := (y - bounds.Min.Y) * n / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cx := (x - bounds.Min.X) * n / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			// Rec. 601 luma from 16-bit channels
+			luma[cy*n+cx] += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			counts[cy*n+cx]++
+		}
+	}
+	for i := range luma {
+		if counts[i] > 0 {
+			luma[i] /= counts[i]
+		}
+	}
+
+	// Only the k x k lowest frequencies of the 2D DCT-II are needed
+	var cosines [k][n]float64
+	for u := range k {
+		for x := range n {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+		}
+	}
+	var coefficients [k * k]float64
+	for v := range k {
+		for u := range k {
+			var sum float64
+			for y := range n {
+				for x := range n {
+					sum += luma[y*n+x] * cosines[u][x] * cosines[v][y]
+				}
+			}
+			coefficients[v*k+u] = sum
+		}
+	}
+
+	// The DC term is the average brightness, which says nothing about content
+	sorted := slices.Clone(coefficients[1:])
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << (63 - i)
+		}
+	}
+	return hash
+}
+
+// The number of bits two video hashes differ in
+func perceptualHashDistance(a, b string) (int, error) {
+	aBytes, err := hex.DecodeString(a)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash: %w", err)
+	}
+	bBytes, err := hex.DecodeString(b)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash: %w", err)
+	}
+	if len(aBytes) != len(bBytes) {
+		return 0, errors.New("perceptual hashes are of different lengths")
+	}
+	distance := 0
+	for i := range aBytes {
+		distance += bits.OnesCount8(aBytes[i] ^ bBytes[i])
+	}
+	return distance, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// How long signed URLs we hand out stay valid
+const signedURLExpiry = 15 * time.Minute
+
+// Cached URLs are reused only while they have at least this much life left, so a
+// client never gets one that expires before it can start playing
+const signedURLMinRemaining = 2 * time.Minute
+
+// Remembers the URLs signStoredURL produced so repeated requests for the same
+// object skip the signing work. Entries are keyed by everything that goes into
+// the signature and are bounded in number; when full, expired entries are
+// dropped first and then arbitrary ones.
+type signedURLCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[signedURLCacheKey]signedURL
+}
+
+type signedURLCacheKey struct {
+	stored   string
+	clientIP string
+}
+
+type signedURL struct {
+	url       string
+	expiresAt time.Time
+}
+
+func newSignedURLCache(maxEntries int) *signedURLCache {
+	return &signedURLCache{
+		maxEntries: maxEntries,
+		entries:    make(map[signedURLCacheKey]signedURL),
+	}
+}
+
+// Returns the cached URL for key if it's still fresh enough to hand out
+func (c *signedURLCache) get(key signedURLCacheKey) (signedURL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Until(entry.expiresAt) < signedURLMinRemaining {
+		return signedURL{}, false
+	}
+	return entry, true
+}
+
+func (c *signedURLCache) put(key signedURLCacheKey, entry signedURL) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		for k, e := range c.entries {
+			if e.expiresAt.Sub(now) < signedURLMinRemaining {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignedURLCacheHit(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	stored := testBucket + ",landscape/cached.mp4"
+	key := signedURLCacheKey{stored: stored}
+
+	first, err := cfg.signStoredURLWithExpiry(context.Background(), stored, signingOptions{})
+	if err != nil {
+		t.Fatalf("couldn't sign URL: %v", err)
+	}
+	cached, ok := cfg.signedURLs.get(key)
+	if !ok || cached != first {
+		t.Fatal("signed URL wasn't cached")
+	}
+
+	// A URL only the cache could return shows the second request didn't sign
+	marker := signedURL{url: "https://example.com/cached", expiresAt: first.expiresAt}
+	cfg.signedURLs.put(key, marker)
+	second, err := cfg.signStoredURLWithExpiry(context.Background(), stored, signingOptions{})
+	if err != nil {
+		t.Fatalf("couldn't sign URL: %v", err)
+	}
+	if second != marker {
+		t.Errorf("got %q within the cache window, want the cached %q", second.url, marker.url)
+	}
+	if cached, _ := cfg.signedURLs.get(key); cached != marker {
+		t.Error("cache entry was replaced by a hit")
+	}
+}
+
+func TestSignedURLCacheRegeneratesNearExpiry(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	stored := testBucket + ",landscape/expiring.mp4"
+
+	stale := signedURL{url: "https://example.com/stale", expiresAt: time.Now().Add(signedURLMinRemaining - time.Second)}
+	cfg.signedURLs.put(signedURLCacheKey{stored: stored}, stale)
+
+	signed, err := cfg.signStoredURLWithExpiry(context.Background(), stored, signingOptions{})
+	if err != nil {
+		t.Fatalf("couldn't sign URL: %v", err)
+	}
+	if signed.url == stale.url {
+		t.Error("got a cached URL with less than signedURLMinRemaining left")
+	}
+	if time.Until(signed.expiresAt) < signedURLExpiry-time.Minute {
+		t.Errorf("regenerated URL expires at %v, want about %v from now", signed.expiresAt, signedURLExpiry)
+	}
+
+	cached, ok := cfg.signedURLs.get(signedURLCacheKey{stored: stored})
+	if !ok || cached != signed {
+		t.Error("regenerated URL wasn't cached in place of the stale one")
+	}
+}
+
+func TestSignedURLCacheEviction(t *testing.T) {
+	cache := newSignedURLCache(2)
+	fresh := time.Now().Add(signedURLExpiry)
+
+	cache.put(signedURLCacheKey{stored: "a"}, signedURL{url: "a", expiresAt: fresh})
+	cache.put(signedURLCacheKey{stored: "expired"}, signedURL{url: "expired", expiresAt: time.Now()})
+	cache.put(signedURLCacheKey{stored: "b"}, signedURL{url: "b", expiresAt: fresh})
+
+	if len(cache.entries) != 2 {
+		t.Fatalf("cache holds %d entries, want at most 2", len(cache.entries))
+	}
+	// The expired entry goes first, so both fresh ones are kept
+	for _, stored := range []string{"a", "b"} {
+		if _, ok := cache.get(signedURLCacheKey{stored: stored}); !ok {
+			t.Errorf("fresh entry %q was evicted", stored)
+		}
+	}
+
+	cache.put(signedURLCacheKey{stored: "c"}, signedURL{url: "c", expiresAt: fresh})
+	if len(cache.entries) != 2 {
+		t.Errorf("cache holds %d entries, want at most 2", len(cache.entries))
+	}
+	if _, ok := cache.get(signedURLCacheKey{stored: "c"}); !ok {
+		t.Error("newest entry wasn't cached")
+	}
+}
+
+// Run with -race
+func TestSignedURLCacheConcurrentAccess(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	cfg.signedURLs = newSignedURLCache(8)
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				stored := fmt.Sprintf("%s,landscape/%d.mp4", testBucket, (i+j)%12)
+				_, err := cfg.signStoredURLWithExpiry(context.Background(), stored, signingOptions{})
+				if err != nil {
+					t.Errorf("couldn't sign URL: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	cfg.signedURLs.mu.Lock()
+	defer cfg.signedURLs.mu.Unlock()
+	if len(cfg.signedURLs.entries) > 8 {
+		t.Errorf("cache holds %d entries, want at most 8", len(cfg.signedURLs.entries))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+/*
+Preview loops
+
+A preview loop is a few seconds of the middle of a video, silent and small,
+that a video grid plays while the pointer is over a video. Loops are MP4 (H.264)
+by default, or WebM (VP8) for players that prefer it; each format is its own
+asset, named after its file.
+
+Like contact sheets, loops are generated on request from the stored video, with
+ffmpeg reading only the part it needs through a presigned URL.
+*/
+
+const assetKindPreviewLoop = "preview_loop"
+
+type previewLoopFormat struct {
+	fileName    string
+	contentType string
+	args        []string
+}
+
+var previewLoopFormats = map[string]previewLoopFormat{
+	"mp4": {"preview.mp4", "video/mp4", []string{
+		"-c:v", "libx264", "-profile:v", "baseline", "-pix_fmt", "yuv420p",
+		"-preset", "veryfast", "-crf", "28",
+		"-movflags", "+faststart",
+	}},
+	"webm": {"preview.webm", "video/webm", []string{
+		"-c:v", "libvpx", "-crf", "30", "-b:v", "500k",
+	}},
+}
+
+// Cuts length of the video at source (a path or URL) from around the middle of
+// its duration seconds, without audio, scaled to width pixels wide
+func generatePreviewLoop(source string, duration float64, length time.Duration, width int, format previewLoopFormat, outputDir string) (string, error) {
+	seconds := min(length.Seconds(), duration)
+	start := max(0, (duration-seconds)/2)
+
+	outputPath := filepath.Join(outputDir, format.fileName)
+	args := []string{
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", source,
+		"-t", strconv.FormatFloat(seconds, 'f', 3, 64),
+		"-an",
+		"-vf", fmt.Sprintf("scale=%d:-2,fps=15", width),
+	}
+	args = append(args, format.args...)
+	args = append(args, outputPath)
+	err := runCommand(exec.Command("ffmpeg", args...))
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg preview loop failed: %w", err)
+	}
+	return outputPath, nil
+}
+
+// Builds a preview loop for a stored video, uploads it next to the video and
+// records it as an asset. Returns the stored "bucket,key" reference.
+func (cfg *apiConfig) createPreviewLoop(ctx context.Context, video database.Video, format previewLoopFormat) (string, error) {
+	_, key, err := parseStoredURL(*video.VideoURL)
+	if err != nil {
+		return "", err
+	}
+	source, err := cfg.videoSourceURL(ctx, video)
+	if err != nil {
+		return "", err
+	}
+
+	duration, err := getVideoDuration(source)
+	if err != nil {
+		return "", err
+	}
+
+	workDir, err := os.MkdirTemp("", "tubely-preview-loop-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(workDir)
+
+	loopPath, err := generatePreviewLoop(source, duration, cfg.previewLoopLength, cfg.previewLoopWidth, format, workDir)
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := cfg.uploadAssetFile(ctx, assetKindPreviewLoop, assetPrefix(key), format.fileName, loopPath, format.contentType)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload preview loop: %w", err)
+	}
+
+	err = cfg.db.UpsertVideoAsset(database.VideoAsset{
+		VideoID: video.ID,
+		Kind:    assetKindPreviewLoop,
+		Name:    format.fileName,
+		URL:     stored,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save preview loop: %w", err)
+	}
+	return stored, nil
+}
+
+// Checks that the loop settings can produce a preview loop
+func validatePreviewLoop(length time.Duration, width int) error {
+	if length <= 0 {
+		return fmt.Errorf("preview loop length must be positive, got %s", length)
+	}
+	if width <= 0 || width%2 != 0 {
+		return fmt.Errorf("preview loop width must be a positive even number, got %d", width)
+	}
+	return nil
+}
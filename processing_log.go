@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Processing logs hold more than this many bytes are cut off
+const maxProcessingLogSize = 64 << 10
+
+// What happened while a video was processed, kept so its owner can find out
+// why an upload failed
+type processingLog struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Lines     []string  `json:"lines"`
+	Truncated bool      `json:"truncated"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	size int
+}
+
+// Keeps the most recent processing log of each video in memory for ttl
+type processingLogStore struct {
+	ttl time.Duration
+	// Directories whose paths are left out of the logs
+	tempDirs []string
+
+	mu   sync.Mutex
+	logs map[uuid.UUID]*processingLog
+}
+
+func newProcessingLogStore(ttl time.Duration, uploadTempDir string) *processingLogStore {
+	tempDirs := []string{os.TempDir()}
+	if uploadTempDir != "" {
+		tempDirs = append(tempDirs, uploadTempDir)
+	}
+	return &processingLogStore{
+		ttl:      ttl,
+		tempDirs: tempDirs,
+		logs:     make(map[uuid.UUID]*processingLog),
+	}
+}
+
+// Starts a fresh log for videoID, discarding the one from any earlier upload
+func (s *processingLogStore) start(videoID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.logs[videoID] = &processingLog{
+		VideoID:   videoID,
+		Lines:     []string{},
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Writes a line to the server log and, if videoID is being processed, to its
+// processing log
+func (s *processingLogStore) printf(videoID uuid.UUID, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("video %s: %s", videoID, msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.logs[videoID]
+	if !ok || entry.Truncated {
+		return
+	}
+	line := time.Now().UTC().Format("15:04:05.000") + " " + sanitizeLogLine(msg, s.tempDirs)
+	if entry.size+len(line) > maxProcessingLogSize {
+		entry.Truncated = true
+		return
+	}
+	entry.Lines = append(entry.Lines, line)
+	entry.size += len(line)
+	entry.UpdatedAt = time.Now()
+}
+
+func (s *processingLogStore) get(videoID uuid.UUID) (processingLog, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.logs[videoID]
+	if !ok {
+		return processingLog{}, false
+	}
+	copied := *entry
+	copied.Lines = append([]string{}, entry.Lines...)
+	return copied, true
+}
+
+// Periodically drops logs that haven't been written to for ttl
+func (s *processingLogStore) startJanitor(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.mu.Lock()
+			for videoID, entry := range s.logs {
+				if time.Since(entry.UpdatedAt) > s.ttl {
+					delete(s.logs, videoID)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// Hides server paths and strips control characters from a message before a
+// user gets to see it
+func sanitizeLogLine(msg string, tempDirs []string) string {
+	for _, tempDir := range tempDirs {
+		msg = strings.ReplaceAll(msg, filepath.Clean(tempDir)+string(filepath.Separator), "")
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, msg)
+}
+
+// Moves an upload to the next pipeline stage, for both the status and the log
+func (cfg *apiConfig) setProcessingStage(videoID uuid.UUID, stage string) {
+	cfg.uploads.setStage(videoID, stage)
+	cfg.processingLogs.printf(videoID, "Stage: %s", stage)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Processing profiles
+
+A profile names the optional pipeline steps an upload goes through, so
+different kinds of content can be processed differently without code changes:
+
+	PROCESSING_PROFILES="quick=faststart; podcast=transcode,loudnorm,faststart"
+
+Uploads pick one with the "profile" form field (tus uploads with the "profile"
+metadata key). Without one they get DEFAULT_PROCESSING_PROFILE, which is the
+"default" profile unless configured otherwise. Unless PROCESSING_PROFILES
+redefines it, "default" is built from the individual settings
+(TRANSCODE_INCOMPATIBLE_CODECS, MAX_VIDEO_RESOLUTION, SCRUB_PREVIEW_ENABLED,
+...), so uploads are processed exactly as they were before profiles existed.
+
+Validation (blocked hashes, the polyglot scan, container and codec checks)
+isn't a step and always runs. Steps that need settings still need them:
+"downscale" does nothing without MAX_VIDEO_RESOLUTION and "renditions" nothing
+without RENDITIONS.
+*/
+
+const (
+	stepTranscode    = "transcode"
+	stepDownscale    = "downscale"
+	stepLoudnorm     = "loudnorm"
+	stepFastStart    = "faststart"
+	stepScrubPreview = "scrub_preview"
+	stepThumbnail    = "thumbnail"
+	stepRenditions   = "renditions"
+	stepKeyframes    = "keyframes"
+	stepPHash        = "phash"
+)
+
+var processingSteps = []string{
+	stepTranscode,
+	stepDownscale,
+	stepLoudnorm,
+	stepFastStart,
+	stepScrubPreview,
+	stepThumbnail,
+	stepRenditions,
+	stepKeyframes,
+	stepPHash,
+}
+
+const defaultProcessingProfile = "default"
+
+type processingProfile struct {
+	name  string
+	steps map[string]bool
+}
+
+// Whether the profile runs the step
+func (p processingProfile) has(step string) bool {
+	return p.steps[step]
+}
+
+func (p processingProfile) String() string {
+	var steps []string
+	for _, step := range processingSteps {
+		if p.has(step) {
+			steps = append(steps, step)
+		}
+	}
+	return fmt.Sprintf("%s (%s)", p.name, strings.Join(steps, ", "))
+}
+
+// Parses "name=step,step; name=step" into profiles by name
+func parseProcessingProfiles(value string) (map[string]processingProfile, error) {
+	profiles := make(map[string]processingProfile)
+	for _, definition := range strings.Split(value, ";") {
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+		name, stepList, ok := strings.Cut(definition, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid profile %q, expected name=step,step", definition)
+		}
+		if _, exists := profiles[name]; exists {
+			return nil, fmt.Errorf("profile %q is defined twice", name)
+		}
+
+		profile := processingProfile{name: name, steps: make(map[string]bool)}
+		for _, step := range strings.Split(stepList, ",") {
+			step = strings.TrimSpace(step)
+			if step == "" {
+				continue
+			}
+			if !isProcessingStep(step) {
+				return nil, fmt.Errorf("profile %q has unknown step %q, expected one of %s", name, step, strings.Join(processingSteps, ", "))
+			}
+			profile.steps[step] = true
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+func isProcessingStep(step string) bool {
+	for _, known := range processingSteps {
+		if step == known {
+			return true
+		}
+	}
+	return false
+}
+
+// The profile an upload asked for by name, or the default profile for "".
+// Returns false for unknown names.
+func (cfg *apiConfig) processingProfile(name string) (processingProfile, bool) {
+	if name == "" {
+		name = cfg.defaultProcessingProfile
+	}
+	if profile, ok := cfg.processingProfiles[name]; ok {
+		return profile, true
+	}
+	if name == defaultProcessingProfile {
+		return cfg.settingsProcessingProfile(), true
+	}
+	return processingProfile{}, false
+}
+
+// The "default" profile as implied by the individual step settings
+func (cfg *apiConfig) settingsProcessingProfile() processingProfile {
+	return processingProfile{
+		name: defaultProcessingProfile,
+		steps: map[string]bool{
+			stepTranscode:    cfg.transcodeIncompatibleCodecs,
+			stepDownscale:    cfg.maxVideoResolution > 0,
+			stepFastStart:    true,
+			stepScrubPreview: cfg.enableScrubPreviews,
+			stepThumbnail:    cfg.enableAutoThumbnails,
+			stepRenditions:   len(cfg.renditions) > 0,
+			stepKeyframes:    cfg.enableKeyframeIndex,
+			stepPHash:        cfg.enablePerceptualHash,
+		},
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Periodically makes scheduled videos public once their publish time has come
+func (cfg *apiConfig) startPublishScheduler(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			n, err := cfg.db.PublishDueVideos(time.Now())
+			if err != nil {
+				log.Printf("Couldn't publish scheduled videos: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Published %d scheduled videos", n)
+			}
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Allows each key (e.g. a client IP) at most limit requests per window. Counts
+// reset at the start of each window rather than sliding, which is good enough
+// to stop scraping and brute forcing.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Counts a request for key. Returns false, and how long until the key may try
+// again, if it's over the limit. A limit of 0 or less disables limiting.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// Periodically forgets keys whose window has ended
+func (l *rateLimiter) startJanitor(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			l.mu.Lock()
+			for key, w := range l.windows {
+				if time.Since(w.start) >= l.window {
+					delete(l.windows, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+}
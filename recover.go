@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Turns a panic in a handler into a 500 response instead of a crashed process.
+// The stack trace is logged with the request ID so the failure can be traced.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &headerTrackingWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// net/http uses this to abort a response on purpose; let it through
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			log.Printf("panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID(r), rec, debug.Stack())
+			if rw.wroteHeader {
+				// Too late for an error response; the client gets a truncated body
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Internal server error", nil)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Remembers whether the response headers have been sent
+type headerTrackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *headerTrackingWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerTrackingWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Lets http.ResponseController reach the underlying writer (deadlines, flushing)
+func (w *headerTrackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+Renditions
+
+Besides the original, a video can be stored at lower resolutions ("720p",
+"480p", ...) so players on slow connections or small screens don't have to
+fetch the full-size file. Each rendition is a video asset of kind "rendition"
+named after its height, stored next to the original:
+
+	landscape/<name>.mp4
+	landscape/<name>/720p.mp4
+
+Renditions at or above the source height are skipped; upscaling only wastes
+storage.
+*/
+
+const assetKindRendition = "rendition"
+
+// Renditions returned for ?rendition=auto: the smallest and the largest, so
+// the player can start small and switch up
+const autoRenditionCount = 2
+
+var errRenditionNotFound = errors.New("rendition not found")
+
+// Parses a rendition name like "720p" into its height in pixels
+func parseRenditionHeight(name string) (int, error) {
+	height, err := strconv.Atoi(strings.TrimSuffix(name, "p"))
+	if err != nil || !strings.HasSuffix(name, "p") || height <= 0 || height%2 != 0 {
+		return 0, fmt.Errorf("invalid rendition %q, expected an even height like 720p", name)
+	}
+	return height, nil
+}
+
+// Scales a video down to height (keeping the aspect ratio) as a fast start
+// H.264/AAC MP4 in outputDir
+func generateRendition(videoPath, outputDir string, height int) (string, error) {
+	outputPath := fmt.Sprintf("%s/%dp.mp4", outputDir, height)
+
+	cmd := exec.Command("ffmpeg",
+		"-i", videoPath,
+		"-vf", fmt.Sprintf("scale=-2:%d", height), // -2 keeps the width even
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-movflags", "faststart",
+		"-f", "mp4",
+		outputPath,
+	)
+	err := runCommand(cmd)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg rendition %dp failed: %w", height, err)
+	}
+	return outputPath, nil
+}
+
+// A rendition that couldn't be generated or stored
+type renditionError struct {
+	name string
+	err  error
+}
+
+func (e *renditionError) Error() string {
+	return fmt.Sprintf("%s rendition: %v", e.name, e.err)
+}
+
+func (e *renditionError) Unwrap() error {
+	return e.err
+}
+
+// Finds why the named rendition failed in an error from generateRenditions
+func renditionFailure(err error, name string) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return err
+	}
+	for _, e := range joined.Unwrap() {
+		var renditionErr *renditionError
+		if errors.As(e, &renditionErr) && renditionErr.name == name {
+			return renditionErr.err
+		}
+	}
+	return nil
+}
+
+// Generates and uploads the named renditions that are smaller than the source,
+// under keyPrefix, and returns the names of those stored. Renditions are
+// generated concurrently, at most RENDITION_CONCURRENCY at a time across all
+// videos. Each one succeeds or fails on its own, so a partial set is still
+// stored; the failures come back joined in the error.
+func (cfg *apiConfig) generateRenditions(ctx context.Context, videoID uuid.UUID, videoPath, keyPrefix string, names []string) ([]string, error) {
+	_, sourceHeight, err := getVideoDimensions(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	heights := make([]int, len(names))
+	for i, name := range names {
+		heights[i], err = parseRenditionHeight(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workDir, err := os.MkdirTemp(cfg.uploadTempDir, "tubely-renditions-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		if heights[i] >= sourceHeight {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.renditionSlots <- struct{}{}
+			defer func() { <-cfg.renditionSlots }()
+
+			err := cfg.storeRendition(ctx, videoID, videoPath, workDir, keyPrefix, name, heights[i])
+			if err != nil {
+				errs[i] = &renditionError{name: name, err: err}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// In the order asked for, regardless of which finished first
+	var stored []string
+	for i, name := range names {
+		if heights[i] < sourceHeight && errs[i] == nil {
+			stored = append(stored, name)
+		}
+	}
+	return stored, errors.Join(errs...)
+}
+
+// Generates one rendition, uploads it and records it as an asset of the video
+func (cfg *apiConfig) storeRendition(ctx context.Context, videoID uuid.UUID, videoPath, workDir, keyPrefix, name string, height int) error {
+	renditionPath, err := generateRendition(videoPath, workDir, height)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(renditionPath)
+
+	stored, err := cfg.uploadAssetFile(ctx, assetKindRendition, keyPrefix, name+".mp4", renditionPath, "video/mp4")
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	err = cfg.db.UpsertVideoAsset(database.VideoAsset{
+		VideoID: videoID,
+		Kind:    assetKindRendition,
+		Name:    name,
+		URL:     stored,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save: %w", err)
+	}
+	cfg.processingLogs.printf(videoID, "Stored %s rendition", name)
+	return nil
+}
+
+// The configured renditions a source height tall should have
+func (cfg *apiConfig) expectedRenditions(sourceHeight int) []string {
+	var names []string
+	for _, name := range cfg.renditions {
+		height, err := parseRenditionHeight(name)
+		if err == nil && height < sourceHeight {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Narrows the renditions among assets to what the client asked for: "" keeps
+// all of them, "auto" a small representative set, anything else exactly that
+// rendition (errRenditionNotFound if the video doesn't have it). Other assets
+// are always kept.
+func selectRenditions(assets []database.VideoAsset, selector string) ([]database.VideoAsset, error) {
+	if selector == "" {
+		return assets, nil
+	}
+
+	var others, renditions []database.VideoAsset
+	for _, asset := range assets {
+		if asset.Kind == assetKindRendition {
+			renditions = append(renditions, asset)
+		} else {
+			others = append(others, asset)
+		}
+	}
+
+	if selector != "auto" {
+		for _, rendition := range renditions {
+			if rendition.Name == selector {
+				return append(others, rendition), nil
+			}
+		}
+		return nil, errRenditionNotFound
+	}
+
+	if len(renditions) > autoRenditionCount {
+		sort.Slice(renditions, func(i, j int) bool {
+			hi, _ := parseRenditionHeight(renditions[i].Name)
+			hj, _ := parseRenditionHeight(renditions[j].Name)
+			return hi < hj
+		})
+		renditions = []database.VideoAsset{renditions[0], renditions[len(renditions)-1]}
+	}
+	return append(others, renditions...), nil
+}
@@ -2,26 +2,223 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
-func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-	// Create presign client
-	presignClient := s3.NewPresignClient(s3Client)
-	
+// The longest S3 accepts for a SigV4 presigned URL
+const sigV4MaxPresignExpiry = 7 * 24 * time.Hour
+
+// The longest presigned URLs are made to last, which may be less than S3 allows
+// (e.g. when signing with temporary credentials). Set with setMaxPresignExpiry.
+var maxPresignExpiry = sigV4MaxPresignExpiry
+
+func setMaxPresignExpiry(expiry time.Duration) error {
+	if expiry <= 0 || expiry > sigV4MaxPresignExpiry {
+		return fmt.Errorf("presign expiry must be between 0 and %s, got %s", sigV4MaxPresignExpiry, expiry)
+	}
+	maxPresignExpiry = expiry
+	return nil
+}
+
+// Caps an expiry at maxPresignExpiry, since S3 would otherwise refuse the URL
+// or cut it short without telling the client
+func clampPresignExpiry(key string, expireTime time.Duration) (time.Duration, error) {
+	if expireTime <= 0 {
+		return 0, fmt.Errorf("invalid presign expiry %s for %s", expireTime, key)
+	}
+	if expireTime > maxPresignExpiry {
+		log.Printf("Clamping presign expiry for %s from %s to %s", key, expireTime, maxPresignExpiry)
+		return maxPresignExpiry, nil
+	}
+	return expireTime, nil
+}
+
+// Presigns a GET of an object. Returns the URL and how long it's valid for, which
+// is less than expireTime if that's over maxPresignExpiry.
+func generatePresignedURL(ctx context.Context, presignClient *s3.PresignClient, bucket, key, versionID string, expireTime time.Duration) (string, time.Duration, error) {
+	expireTime, err := clampPresignExpiry(key, expireTime)
+	if err != nil {
+		return "", 0, err
+	}
+
 	// Generate presigned URL
-	presignedRequest, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
-	}, s3.WithPresignExpires(expireTime))
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	// Have S3 serve the type we stored rather than whatever the object metadata says
+	if contentType := contentTypeForKey(key); contentType != "" {
+		input.ResponseContentType = aws.String(contentType)
+	}
+	presignedRequest, err := presignClient.PresignGetObject(ctx, input, s3.WithPresignExpires(expireTime))
 	
 	if err != nil {
-		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
+		return "", 0, fmt.Errorf("failed to generate presigned URL: %w", err)
 	}
 	
+	return presignedRequest.URL, expireTime, nil
+}
+
+// Presigns a PUT of key in the configured bucket. The content type is part of the
+// signature, so the upload is refused unless the client sends exactly that type.
+func (cfg *apiConfig) generatePresignedPutURL(ctx context.Context, key, contentType string, expireTime time.Duration) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.s3Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	presignedRequest, err := cfg.s3Presign.PresignPutObject(ctx, input, s3.WithPresignExpires(expireTime))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned upload URL: %w", err)
+	}
 	return presignedRequest.URL, nil
-}
\ No newline at end of file
+}
+
+// Content types of the files we store, by extension
+var storedContentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".vtt":  "text/vtt",
+}
+
+// The content type a stored object should be served with, or "" if unknown
+func contentTypeForKey(key string) string {
+	return storedContentTypes[strings.ToLower(path.Ext(key))]
+}
+
+// Uploads body to the target bucket, retrying transient failures. Returns the
+// version ID S3 assigned, which is empty unless the bucket has versioning enabled.
+// S3 checks the bytes it receives against our SHA-256 and rejects the upload if
+// they were corrupted on the way, which is retried like any other failure. Retries
+// stop once ctx is done.
+func (cfg *apiConfig) uploadToS3(ctx context.Context, target storageTarget, key string, body io.ReadSeeker, contentType string, meta objectMetadata) (string, error) {
+	maxRetries := 3
+	var uploadErr error
+
+	checksum, err := sha256Base64(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum %s: %w", key, err)
+	}
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		// Reset file pointer to beginning for each retry
+		_, seekErr := body.Seek(0, io.SeekStart)
+		if seekErr != nil {
+			return "", seekErr
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket:      aws.String(target.bucket),
+			Key:         aws.String(key),
+			Body:        body,
+			ContentType: aws.String(contentType),
+			// Sent as x-amz-checksum-sha256, which S3 verifies before storing
+			ChecksumSHA256: aws.String(checksum),
+		}
+		if cfg.s3CacheControl != "" {
+			input.CacheControl = aws.String(cfg.s3CacheControl)
+		}
+		if target.storageClass != "" {
+			input.StorageClass = target.storageClass
+		}
+		if meta.Language != "" {
+			input.ContentLanguage = aws.String(meta.Language)
+		}
+		if len(meta.Metadata) > 0 {
+			input.Metadata = meta.Metadata
+		}
+		output, err := target.client.PutObject(ctx, input)
+		if err == nil && output.ChecksumSHA256 != nil && aws.ToString(output.ChecksumSHA256) != checksum {
+			err = fmt.Errorf("checksum mismatch: sent %s, S3 stored %s", checksum, aws.ToString(output.ChecksumSHA256))
+		}
+
+		if err == nil {
+			// Success!
+			return aws.ToString(output.VersionId), nil
+		}
+		uploadErr = err
+
+		fmt.Printf("S3 upload attempt %d failed: %v\n", attempt, uploadErr)
+
+		// If not the last attempt, wait before retrying
+		if attempt < maxRetries {
+			backoffTime := time.Second * time.Duration(attempt) // 1s, 2s, 3s
+			select {
+			case <-time.After(backoffTime):
+			case <-ctx.Done():
+				return "", fmt.Errorf("upload abandoned after %d attempts: %w", attempt, errors.Join(ctx.Err(), uploadErr))
+			}
+		}
+	}
+
+	return "", fmt.Errorf("upload failed after %d attempts: %w", maxRetries, uploadErr)
+}
+
+// Base64 SHA-256 of everything in body, the encoding S3 checksums use
+func sha256Base64(body io.ReadSeeker) (string, error) {
+	_, err := body.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, body)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Copies an object within S3 without downloading it. The destination is always
+// the configured bucket.
+func (cfg *apiConfig) copyS3Object(ctx context.Context, srcBucket, srcKey, dstKey string) error {
+	return cfg.copyS3ObjectTo(ctx, srcBucket, srcKey, cfg.defaultStorage(), dstKey)
+}
+
+// Like copyS3Object, into a target of ours in its storage class
+func (cfg *apiConfig) copyS3ObjectTo(ctx context.Context, srcBucket, srcKey string, dst storageTarget, dstKey string) error {
+	// CopySource is "bucket/key" and must be URL-encoded
+	segments := strings.Split(srcKey, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	copySource := url.PathEscape(srcBucket) + "/" + strings.Join(segments, "/")
+
+	_, err := dst.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:       aws.String(dst.bucket),
+		Key:          aws.String(dstKey),
+		CopySource:   aws.String(copySource),
+		StorageClass: dst.storageClass,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s/%s to %s: %w", srcBucket, srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// Returns 32 random bytes as a URL-safe string, used to name stored files
+func generateRandomName() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Compares presigning with the client shared on apiConfig against building a
+// presign client for every URL, as was done before
+func BenchmarkGeneratePresignedURL(b *testing.B) {
+	cfg := newTestAPIConfig(b)
+	key := "landscape/benchmark.mp4"
+
+	b.Run("shared client", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			_, _, err := generatePresignedURL(context.Background(), cfg.s3Presign, testBucket, key, "", signedURLExpiry)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("client per call", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			presign := s3.NewPresignClient(cfg.s3Client)
+			_, _, err := generatePresignedURL(context.Background(), presign, testBucket, key, "", signedURLExpiry)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
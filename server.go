@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Defaults favour large uploads: the whole request body has to arrive within
+// readTimeout and the response (written after ffmpeg and the S3 upload finish)
+// within writeTimeout, so both are generous. Headers and idle keep-alive
+// connections are kept on a much shorter leash.
+const (
+	defaultReadTimeout       = 30 * time.Minute
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultMaxHeaderBytes    = 1 << 20 // 1MB
+)
+
+func (cfg *apiConfig) newServer(handler http.Handler) *http.Server {
+	// HTTP/2 is negotiated automatically over TLS; enabling unencrypted
+	// HTTP/2 (h2c) as well lets it work behind a TLS-terminating proxy.
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if cfg.enableHTTP2 {
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &http.Server{
+		Addr:              ":" + cfg.port,
+		Handler:           handler,
+		ReadTimeout:       cfg.readTimeout,
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+		MaxHeaderBytes:    cfg.maxHeaderBytes,
+		Protocols:         protocols,
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+Scrub previews
+
+When the user hovers over the player's progress bar we want to show a small
+frame from that point in the video. Instead of one image per frame, every
+frame is packed into a single "sprite sheet" (a grid of tiles) and a WebVTT
+file maps each time range to one tile using a media fragment:
+
+	00:00:10.000 --> 00:00:20.000
+	sprite.jpg#xywh=160,0,160,90
+
+The sprite and the VTT file are uploaded next to each other, so the relative
+reference resolves to the right object wherever it can be fetched without a
+signature of its own. Presigned URLs can't, so players are handed the track
+through GET /videos/{videoID}/thumbnails.vtt, which signs each sprite reference
+(see handler_video_thumbnail_track.go).
+
+Long videos have too many frames for one image, so the sprite is split into
+pages of at most maxSpriteHeight pixels (sprite_001.jpg, sprite_002.jpg, ...)
+and each cue points at the page holding its tile. Videos that fit on one page
+keep a single sprite.jpg.
+*/
+
+const (
+	spriteFileName         = "sprite.jpg"
+	spritePageFileName     = "sprite_%03d.jpg"
+	thumbnailTrackFileName = "thumbnails.vtt"
+)
+
+// Samples one frame every interval seconds, scales each to tileWidth and packs them
+// into sprite sheets in outputDir, cols tiles per row and no taller than maxHeight
+// pixels (but at least one row). Returns the sprite paths in order, the number of
+// tiles across all of them and the number of tiles per page.
+func generateThumbnailSprite(videoPath, outputDir string, interval float64, cols, tileWidth, maxHeight int) ([]string, int, int, error) {
+	framesDir, err := os.MkdirTemp("", "tubely-frames-*")
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to create frames dir: %w", err)
+	}
+	defer os.RemoveAll(framesDir)
+
+	// Extract scaled frames (-2 keeps the height even while preserving aspect ratio)
+	cmd := exec.Command("ffmpeg",
+		"-i", videoPath,
+		"-vf", fmt.Sprintf("fps=1/%g,scale=%d:-2", interval, tileWidth),
+		"-q:v", "5",
+		filepath.Join(framesDir, "frame_%05d.jpg"),
+	)
+	err = runCommand(cmd)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("ffmpeg frame extraction failed: %w", err)
+	}
+
+	// Glob returns names sorted, which matches the zero-padded frame order
+	framePaths, err := filepath.Glob(filepath.Join(framesDir, "frame_*.jpg"))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(framePaths) == 0 {
+		return nil, 0, 0, fmt.Errorf("no frames extracted from %s", videoPath)
+	}
+
+	// Every frame is scaled identically, so the first one sizes the pages
+	_, tileHeight, err := jpegDimensions(framePaths[0])
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	tilesPerPage := max(maxHeight/tileHeight, 1) * cols
+
+	var spritePaths []string
+	for start := 0; start < len(framePaths); start += tilesPerPage {
+		spritePath := filepath.Join(outputDir, spriteFileName)
+		if len(framePaths) > tilesPerPage {
+			spritePath = filepath.Join(outputDir, fmt.Sprintf(spritePageFileName, len(spritePaths)+1))
+		}
+		err = tileImages(framePaths[start:min(start+tilesPerPage, len(framePaths))], spritePath, cols)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		spritePaths = append(spritePaths, spritePath)
+	}
+
+	return spritePaths, len(framePaths), tilesPerPage, nil
+}
+
+// Draws same-sized JPEG images into a grid, left to right then top to bottom
+func tileImages(imagePaths []string, outputPath string, cols int) error {
+	var sheet *image.RGBA
+	var tileWidth, tileHeight int
+
+	for i, path := range imagePaths {
+		tile, err := decodeJPEGFile(path)
+		if err != nil {
+			return err
+		}
+
+		// Size the sheet from the first tile; every frame is scaled identically
+		if sheet == nil {
+			tileWidth = tile.Bounds().Dx()
+			tileHeight = tile.Bounds().Dy()
+			rows := (len(imagePaths) + cols - 1) / cols
+			sheet = image.NewRGBA(image.Rect(0, 0, tileWidth*cols, tileHeight*rows))
+		}
+
+		x := (i % cols) * tileWidth
+		y := (i / cols) * tileHeight
+		draw.Draw(sheet, image.Rect(x, y, x+tileWidth, y+tileHeight), tile, tile.Bounds().Min, draw.Src)
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create sprite: %w", err)
+	}
+	defer out.Close()
+
+	return jpeg.Encode(out, sheet, &jpeg.Options{Quality: 80})
+}
+
+func jpegDimensions(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read dimensions of %s: %w", path, err)
+	}
+	return config.Width, config.Height, nil
+}
+
+func decodeJPEGFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// Writes a WebVTT thumbnail track next to the sprites with one cue per tile, in
+// the page holding it. Tile dimensions are read back from the first sprite so the
+// #xywh coordinates always match what generateThumbnailSprite produced.
+func generateThumbnailVTT(spritePaths []string, interval float64, count, cols, tilesPerPage int) (string, error) {
+	if len(spritePaths) == 0 || count <= 0 || cols <= 0 || tilesPerPage < cols {
+		return "", fmt.Errorf("invalid sprite layout: %d pages, %d tiles, %d columns, %d tiles per page", len(spritePaths), count, cols, tilesPerPage)
+	}
+
+	width, height, err := jpegDimensions(spritePaths[0])
+	if err != nil {
+		return "", err
+	}
+	firstPageRows := (min(count, tilesPerPage) + cols - 1) / cols
+	tileWidth := width / cols
+	tileHeight := height / firstPageRows
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n")
+	for i := 0; i < count; i++ {
+		// Cue times come from the tile index alone, so consecutive cues meet
+		// exactly, including across pages
+		start := time.Duration(float64(i) * interval * float64(time.Second))
+		end := time.Duration(float64(i+1) * interval * float64(time.Second))
+		page, tile := i/tilesPerPage, i%tilesPerPage
+		x := (tile % cols) * tileWidth
+		y := (tile / cols) * tileHeight
+
+		fmt.Fprintf(&vtt, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTimestamp(start), formatVTTTimestamp(end),
+			filepath.Base(spritePaths[page]), x, y, tileWidth, tileHeight,
+		)
+	}
+
+	vttPath := filepath.Join(filepath.Dir(spritePaths[0]), thumbnailTrackFileName)
+	err = os.WriteFile(vttPath, []byte(vtt.String()), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write thumbnail track: %w", err)
+	}
+
+	return vttPath, nil
+}
+
+// Formats a duration as a WebVTT timestamp (HH:MM:SS.mmm)
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		ms/3600000,
+		(ms/60000)%60,
+		(ms/1000)%60,
+		ms%1000,
+	)
+}
+
+// Builds the sprite sheet and thumbnail track for a processed video and uploads both
+// under keyPrefix, recording them as assets of the video
+func (cfg *apiConfig) generateScrubPreview(ctx context.Context, videoID uuid.UUID, videoPath, keyPrefix string) error {
+	workDir, err := os.MkdirTemp("", "tubely-sprite-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	interval := cfg.scrubPreviewInterval.Seconds()
+	spritePaths, count, tilesPerPage, err := generateThumbnailSprite(videoPath, workDir, interval, cfg.scrubPreviewColumns, cfg.scrubPreviewTileWidth, cfg.scrubPreviewMaxHeight)
+	if err != nil {
+		return err
+	}
+
+	vttPath, err := generateThumbnailVTT(spritePaths, interval, count, cfg.scrubPreviewColumns, tilesPerPage)
+	if err != nil {
+		return err
+	}
+
+	// Pages from an earlier upload of the video would otherwise stay listed
+	err = cfg.forgetVideoAssets(videoID, "sprite")
+	if err != nil {
+		return err
+	}
+
+	type spriteUpload struct {
+		kind        string
+		path        string
+		contentType string
+	}
+	var uploads []spriteUpload
+	for _, spritePath := range spritePaths {
+		uploads = append(uploads, spriteUpload{"sprite", spritePath, "image/jpeg"})
+	}
+	uploads = append(uploads, spriteUpload{"thumbnail_track", vttPath, "text/vtt"})
+	for _, upload := range uploads {
+		name := filepath.Base(upload.path)
+		stored, err := cfg.uploadAssetFile(ctx, upload.kind, keyPrefix, name, upload.path, upload.contentType)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+
+		err = cfg.db.UpsertVideoAsset(database.VideoAsset{
+			VideoID: videoID,
+			Kind:    upload.kind,
+			Name:    name,
+			URL:     stored,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %w", name, err)
+		}
+	}
+
+	cfg.processingLogs.printf(videoID, "Generated scrub preview (%d tiles on %d sprites)", count, len(spritePaths))
+	return nil
+}
+
+// Drops the records of a video's assets of one kind. The objects are left alone;
+// they're either overwritten or cleaned up with the video.
+func (cfg *apiConfig) forgetVideoAssets(videoID uuid.UUID, kind string) error {
+	assets, err := cfg.db.GetVideoAssets(videoID)
+	if err != nil {
+		return err
+	}
+	for _, asset := range assets {
+		if asset.Kind != kind {
+			continue
+		}
+		err = cfg.db.DeleteVideoAsset(videoID, asset.Kind, asset.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
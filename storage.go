@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+Bring your own storage
+
+A user can have their videos stored in a bucket of their own (set by an admin
+with PUT /admin/users/{userID}/storage). Uploaded videos go there, and URLs for
+them are presigned with credentials for that bucket: our own, or those of an IAM
+role in the customer's account assumed through STS when one is configured. Role
+credentials are cached and refreshed before they expire.
+
+Only the video object itself moves; derived files (scrub previews, renditions,
+contact sheets) stay in our buckets (see asset_storage.go), and CloudFront
+signing and clean public URLs only apply to our main bucket.
+*/
+
+// A bucket and the clients to reach it with
+type storageTarget struct {
+	bucket  string
+	client  *s3.Client
+	presign *s3.PresignClient
+	// Storage class new objects are written with, empty for the bucket's default
+	storageClass types.StorageClass
+}
+
+// Clients for users' own buckets, shared by everyone with the same configuration
+type storageClients struct {
+	base aws.Config
+
+	mu      sync.Mutex
+	targets map[storageClientKey]storageTarget
+}
+
+type storageClientKey struct {
+	bucket, region, roleARN, externalID string
+}
+
+func newStorageClients(base aws.Config) *storageClients {
+	return &storageClients{
+		base:    base,
+		targets: make(map[storageClientKey]storageTarget),
+	}
+}
+
+func (c *storageClients) get(storage database.UserStorage) storageTarget {
+	key := storageClientKey{storage.Bucket, storage.Region, storage.RoleARN, storage.ExternalID}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if target, ok := c.targets[key]; ok {
+		return target
+	}
+
+	awsConfig := c.base.Copy()
+	awsConfig.Region = storage.Region
+	if storage.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(c.base), storage.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "tubely"
+			if storage.ExternalID != "" {
+				o.ExternalID = aws.String(storage.ExternalID)
+			}
+		})
+		awsConfig.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	client := s3.NewFromConfig(awsConfig)
+	target := storageTarget{
+		bucket:  storage.Bucket,
+		client:  client,
+		presign: s3.NewPresignClient(client),
+	}
+	c.targets[key] = target
+	return target
+}
+
+// Our own bucket
+func (cfg *apiConfig) defaultStorage() storageTarget {
+	return storageTarget{
+		bucket:  cfg.s3Bucket,
+		client:  cfg.s3Client,
+		presign: cfg.s3Presign,
+	}
+}
+
+// Where a user's new videos are stored
+func (cfg *apiConfig) storageForUser(userID uuid.UUID) (storageTarget, error) {
+	storage, err := cfg.db.GetUserStorage(userID)
+	if err != nil {
+		return storageTarget{}, fmt.Errorf("failed to get storage settings: %w", err)
+	}
+	if storage.Bucket == "" {
+		return cfg.defaultStorage(), nil
+	}
+	return cfg.storageClients.get(storage), nil
+}
+
+// The clients to reach an object of userID's in bucket with: the user's own
+// storage if that's where it is, otherwise ours
+func (cfg *apiConfig) storageForObject(userID uuid.UUID, bucket string) (storageTarget, error) {
+	if bucket == cfg.s3Bucket {
+		return cfg.defaultStorage(), nil
+	}
+	// Buckets of ours that videos are routed to by aspect ratio, or assets by kind
+	ours := cfg.isAssetBucket(bucket)
+	for _, rule := range cfg.aspectStorage {
+		ours = ours || rule.bucket == bucket
+	}
+	if ours {
+		target := cfg.defaultStorage()
+		target.bucket = bucket
+		return target, nil
+	}
+	target, err := cfg.storageForUser(userID)
+	if err != nil {
+		return storageTarget{}, err
+	}
+	if target.bucket != bucket {
+		return cfg.defaultStorage(), nil
+	}
+	return target, nil
+}
+
+// Checks the bucket exists and we can reach it with the configured credentials
+func (target storageTarget) check(ctx context.Context) error {
+	_, err := target.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(target.bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("can't access bucket %s: %w", target.bucket, err)
+	}
+	return nil
+}
+
+// How long ffmpeg or ffprobe may keep reading a stored video through its
+// presigned URL
+const videoSourceExpiry = 15 * time.Minute
+
+// A presigned URL of a video's stored file for ffmpeg or ffprobe, which seek
+// through it and download only the parts they need
+func (cfg *apiConfig) videoSourceURL(ctx context.Context, video database.Video) (string, error) {
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		return "", err
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		return "", err
+	}
+	source, _, err := generatePresignedURL(ctx, storage.presign, bucket, key, versionID, videoSourceExpiry)
+	return source, err
+}
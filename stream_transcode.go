@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+/*
+On the fly transcoding
+
+Some old clients can only play one particular format. Rather than storing every
+format of every video, GET /api/v1/videos/{id}/transcode?format=... runs ffmpeg
+against the stored video and streams its output straight into the response.
+
+That costs a CPU core or more per viewer, so the endpoint only exists when
+STREAM_TRANSCODE_ENABLED is set, at most STREAM_TRANSCODE_MAX_CONCURRENT
+transcodes run at once (further requests get a 503 with Retry-After), and each
+is killed after STREAM_TRANSCODE_TIMEOUT or as soon as the client goes away.
+
+The output is written front to back as ffmpeg produces it and can't be seeked,
+so MP4 output is fragmented: the moov atom goes first, followed by
+self-contained fragments.
+*/
+
+type streamFormat struct {
+	contentType string
+	// ffmpeg output options
+	args []string
+}
+
+var streamFormats = map[string]streamFormat{
+	// H.264 Baseline 3.0, the profile the oldest mobile devices can decode
+	"mp4-baseline": {"video/mp4", []string{
+		"-c:v", "libx264", "-profile:v", "baseline", "-level", "3.0", "-pix_fmt", "yuv420p",
+		"-preset", "veryfast", "-crf", "23",
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+	}},
+	"webm": {"video/webm", []string{
+		"-c:v", "libvpx", "-b:v", "1M", "-deadline", "realtime", "-cpu-used", "8",
+		"-c:a", "libvorbis",
+		"-f", "webm",
+	}},
+	"mpegts": {"video/mp2t", []string{
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k",
+		"-f", "mpegts",
+	}},
+}
+
+func streamFormatNames() []string {
+	names := make([]string, 0, len(streamFormats))
+	for name := range streamFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Passes ffmpeg's output on to the client as soon as it's produced
+type streamWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	written int64
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	err = s.rc.Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		err = nil
+	}
+	return n, err
+}
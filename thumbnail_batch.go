@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+Batch thumbnail regeneration
+
+Thumbnails made before the best-frame selection (or uploaded as placeholders)
+can be regenerated in bulk by an admin. A batch is a list of video IDs whose
+jobs are fed to the background worker pool one at a time; each job picks a new
+frame from the stored video the same way uploads do and replaces the thumbnail.
+Progress is kept in memory until thumbnailBatchTTL after the batch finishes.
+*/
+
+const (
+	thumbnailBatchQueued   = "queued"
+	thumbnailBatchRunning  = "running"
+	thumbnailBatchComplete = "complete"
+)
+
+// How long a finished batch's progress can still be looked up
+const thumbnailBatchTTL = 24 * time.Hour
+
+// Errors a video can be skipped with
+var (
+	errThumbnailVideoNotFound = errors.New("video not found")
+	errThumbnailNotUploaded   = errors.New("video has not been uploaded yet")
+)
+
+type thumbnailBatch struct {
+	ID          uuid.UUID  `json:"id"`
+	State       string     `json:"state"`
+	Total       int        `json:"total"`
+	Regenerated int        `json:"regenerated"`
+	Skipped     int        `json:"skipped"`
+	Failed      int        `json:"failed"`
+	Progress    float64    `json:"progress"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	// Why each skipped or failed video wasn't regenerated, by video ID
+	Errors map[uuid.UUID]string `json:"errors"`
+}
+
+type thumbnailBatchStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	batches map[uuid.UUID]*thumbnailBatch
+}
+
+func newThumbnailBatchStore(ttl time.Duration) *thumbnailBatchStore {
+	return &thumbnailBatchStore{
+		ttl:     ttl,
+		batches: make(map[uuid.UUID]*thumbnailBatch),
+	}
+}
+
+func (s *thumbnailBatchStore) create(total int) thumbnailBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := &thumbnailBatch{
+		ID:        uuid.New(),
+		State:     thumbnailBatchQueued,
+		Total:     total,
+		CreatedAt: time.Now(),
+		Errors:    make(map[uuid.UUID]string),
+	}
+	if total == 0 {
+		batch.State = thumbnailBatchComplete
+		batch.Progress = 100
+		batch.FinishedAt = &batch.CreatedAt
+	}
+	s.batches[batch.ID] = batch
+	return batch.copy()
+}
+
+// A snapshot of the batch's progress
+func (s *thumbnailBatchStore) get(id uuid.UUID) (thumbnailBatch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return thumbnailBatch{}, false
+	}
+	return batch.copy(), true
+}
+
+// Records the outcome of one video's job
+func (s *thumbnailBatchStore) record(id, videoID uuid.UUID, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return
+	}
+	switch {
+	case err == nil:
+		batch.Regenerated++
+	case errors.Is(err, errThumbnailVideoNotFound), errors.Is(err, errThumbnailNotUploaded):
+		batch.Skipped++
+		batch.Errors[videoID] = err.Error()
+	default:
+		batch.Failed++
+		batch.Errors[videoID] = err.Error()
+	}
+
+	processed := batch.Regenerated + batch.Skipped + batch.Failed
+	batch.Progress = float64(processed) / float64(batch.Total) * 100
+	batch.State = thumbnailBatchRunning
+	if processed == batch.Total {
+		now := time.Now()
+		batch.State = thumbnailBatchComplete
+		batch.FinishedAt = &now
+	}
+}
+
+func (b *thumbnailBatch) copy() thumbnailBatch {
+	c := *b
+	c.Errors = make(map[uuid.UUID]string, len(b.Errors))
+	for id, msg := range b.Errors {
+		c.Errors[id] = msg
+	}
+	return c
+}
+
+// Periodically forgets batches that finished more than ttl ago
+func (s *thumbnailBatchStore) startJanitor(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.mu.Lock()
+			for id, batch := range s.batches {
+				if batch.FinishedAt != nil && time.Since(*batch.FinishedAt) > s.ttl {
+					delete(s.batches, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// Queues a thumbnail regeneration job for each video and returns the batch
+// tracking them. Jobs are fed to the worker pool in the background.
+func (cfg *apiConfig) queueThumbnailBatch(videoIDs []uuid.UUID) thumbnailBatch {
+	batch := cfg.thumbnailBatches.create(len(videoIDs))
+	go func() {
+		for _, videoID := range videoIDs {
+			cfg.backgroundJobs.submit(backgroundJob{
+				name: fmt.Sprintf("regenerate thumbnail of video %s", videoID),
+				run: func() error {
+					err := cfg.regenerateThumbnail(context.Background(), videoID)
+					if errors.Is(err, errThumbnailVideoNotFound) || errors.Is(err, errThumbnailNotUploaded) {
+						return permanentJobFailure(err)
+					}
+					return err
+				},
+				done: func(attempts int, err error) {
+					cfg.thumbnailBatches.record(batch.ID, videoID, err)
+				},
+			})
+		}
+	}()
+	return batch
+}
+
+// Picks a new thumbnail frame from a video's stored file, reading only the
+// parts ffmpeg needs, and replaces its current thumbnail with it
+func (cfg *apiConfig) regenerateThumbnail(ctx context.Context, videoID uuid.UUID) error {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return fmt.Errorf("failed to get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return errThumbnailVideoNotFound
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		return errThumbnailNotUploaded
+	}
+
+	sourceURL, err := cfg.videoSourceURL(ctx, video)
+	if err != nil {
+		return err
+	}
+	framePath, err := selectBestThumbnailFrame(sourceURL, cfg.autoThumbnailStart(sourceURL, ""))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(framePath)
+
+	oldThumbnailURL := video.ThumbnailURL
+	_, err = cfg.saveThumbnailFrame(video, framePath)
+	if err != nil {
+		return err
+	}
+	if oldThumbnailURL != nil {
+		if oldPath, ok := cfg.localAssetPath(*oldThumbnailURL); ok {
+			os.Remove(oldPath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+/*
+Thumbnail placeholders
+
+Alongside each thumbnail we store a tiny, low quality copy of it (a few hundred
+bytes) as a data: URI on the video record. It comes back inline with the video,
+so the frontend can show it stretched and blurred straight away while the real
+thumbnail loads.
+
+The thumbnail's dominant color is stored with it as a #rrggbb hex string, for
+backgrounds and theming around the thumbnail. It's the most common color in
+the placeholder-sized copy, with similar colors counted together.
+*/
+
+const (
+	thumbnailPlaceholderWidth   = 32
+	thumbnailPlaceholderQuality = 50
+)
+
+// Decodes the thumbnail at path and shrinks it to thumbnailPlaceholderWidth
+func smallThumbnailFromFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode thumbnail: %w", err)
+	}
+	return downscaleImage(img, thumbnailPlaceholderWidth), nil
+}
+
+// Returns a shrunk thumbnail as a base64 JPEG data: URI
+func thumbnailPlaceholder(small image.Image) (string, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, small, &jpeg.Options{Quality: thumbnailPlaceholderQuality})
+	if err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Finds the most common color of img as #rrggbb. Pixels are grouped by the top
+// 4 bits of each channel and the winning group's pixels are averaged, so
+// slightly different shades still count as one color.
+func dominantColor(img image.Image) string {
+	type sum struct{ r, g, b, n uint64 }
+	groups := make(map[uint32]*sum)
+	var best *sum
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			group := r>>12<<8 | g>>12<<4 | b>>12
+			s, ok := groups[group]
+			if !ok {
+				s = &sum{}
+				groups[group] = s
+			}
+			s.r += uint64(r)
+			s.g += uint64(g)
+			s.b += uint64(b)
+			s.n++
+			if best == nil || s.n > best.n {
+				best = s
+			}
+		}
+	}
+	if best == nil {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n>>8, best.g/best.n>>8, best.b/best.n>>8)
+}
+
+// Scales img to width pixels wide, keeping its aspect ratio, by averaging the
+// source pixels that fall in each destination pixel. Images already that
+// narrow are returned as they are.
+func downscaleImage(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	if srcWidth <= width {
+		return img
+	}
+	height := max(1, (srcHeight*width+srcWidth/2)/srcWidth)
+
+	type sum struct{ r, g, b, n uint64 }
+	sums := make([]sum, width*height)
+	for y := 0; y < srcHeight; y++ {
+		dy := y * height / srcHeight
+		for x := 0; x < srcWidth; x++ {
+			dx := x * width / srcWidth
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			s := &sums[dy*width+dx]
+			s.r += uint64(r)
+			s.g += uint64(g)
+			s.b += uint64(b)
+			s.n++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, s := range sums {
+		if s.n == 0 {
+			continue
+		}
+		// 16-bit channel averages down to 8 bits
+		dst.Pix[i*4] = uint8(s.r / s.n >> 8)
+		dst.Pix[i*4+1] = uint8(s.g / s.n >> 8)
+		dst.Pix[i*4+2] = uint8(s.b / s.n >> 8)
+		dst.Pix[i*4+3] = 0xFF
+	}
+	return dst
+}
+
+// Sets a video's thumbnail placeholder and color from its new thumbnail image at
+// path. Both are only niceties, so on failure the video is left without them.
+func (cfg *apiConfig) setThumbnailPlaceholder(video *database.Video, path string) {
+	video.ThumbnailPlaceholder = nil
+	video.ThumbnailColor = nil
+	small, err := smallThumbnailFromFile(path)
+	if err != nil {
+		log.Printf("Couldn't read thumbnail of video %s: %v", video.ID, err)
+		return
+	}
+	color := dominantColor(small)
+	video.ThumbnailColor = &color
+
+	if !cfg.enableThumbnailPlaceholders {
+		return
+	}
+	placeholder, err := thumbnailPlaceholder(small)
+	if err != nil {
+		log.Printf("Couldn't generate thumbnail placeholder for video %s: %v", video.ID, err)
+		return
+	}
+	video.ThumbnailPlaceholder = &placeholder
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+Automatic thumbnail position
+
+Automatic thumbnails are picked from a window of candidate frames. Where that
+window starts is AUTO_THUMBNAIL_AT, either a percentage of the video's length
+("15%") or a number of seconds ("3"), like ?at= on the frame thumbnail endpoint.
+It can be set per aspect ratio with AUTO_THUMBNAIL_AT_BY_ASPECT (e.g.
+"portrait=5%,landscape=15%") so shorts and long-form videos each get a frame
+that's representative of them. The window is moved back from the end when the
+position leaves too little video after it.
+*/
+
+// Where automatic thumbnails are picked from
+type autoThumbnailPositions struct {
+	fallback framePosition
+	byAspect map[string]framePosition
+}
+
+// Parses the default position and the per aspect ratio positions
+func parseAutoThumbnailPositions(fallback string, byAspect map[string]string) (autoThumbnailPositions, error) {
+	positions := autoThumbnailPositions{byAspect: make(map[string]framePosition)}
+	var err error
+	positions.fallback, err = parseFramePosition(fallback)
+	if err != nil {
+		return autoThumbnailPositions{}, err
+	}
+	for aspect, value := range byAspect {
+		if !slices.Contains(videoAspectRatios, aspect) {
+			return autoThumbnailPositions{}, fmt.Errorf("position given for unknown aspect ratio %q", aspect)
+		}
+		positions.byAspect[aspect], err = parseFramePosition(value)
+		if err != nil {
+			return autoThumbnailPositions{}, fmt.Errorf("%w for %s", err, aspect)
+		}
+	}
+	return positions, nil
+}
+
+// Parses a percentage from 0 to 100, with or without the "%" sign, into a
+// fraction
+func parsePercentage(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid percentage %q, must be between 0 and 100", value)
+	}
+	return percent / 100, nil
+}
+
+// The position for videos of the aspect ratio
+func (p autoThumbnailPositions) forAspect(aspectRatio string) framePosition {
+	if position, ok := p.byAspect[aspectRatio]; ok {
+		return position
+	}
+	return p.fallback
+}
+
+// Where in the video, in seconds, to start looking for an automatic thumbnail.
+// aspectRatio is detected from the file if it's not known. Falls back to the
+// start of the video if the file can't be probed.
+func (cfg *apiConfig) autoThumbnailStart(videoPath, aspectRatio string) float64 {
+	if aspectRatio == "" && len(cfg.autoThumbnailPositions.byAspect) > 0 {
+		aspectRatio, _ = getVideoAspectRatio(videoPath, cfg.ffprobeAttempts, cfg.ffprobeRetryDelay)
+	}
+	position := cfg.autoThumbnailPositions.forAspect(aspectRatio)
+	if position == (framePosition{}) {
+		return 0
+	}
+	duration, err := getVideoDuration(videoPath)
+	if err != nil {
+		return 0
+	}
+	// Leave room for every candidate frame before the end
+	window := float64(thumbnailCandidateCount * thumbnailCandidateInterval)
+	return max(0, min(position.resolve(duration), duration-window))
+}
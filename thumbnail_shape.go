@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+/*
+Thumbnail shape
+
+Thumbnails come in whatever aspect ratio their source has, which leaves gaps in
+grid layouts. With THUMBNAIL_ASPECT (e.g. "16:9") set, uploaded and generated
+thumbnails are fitted to that aspect ratio before they're saved, either by
+cropping the middle out of them (THUMBNAIL_FIT=crop) or by padding them with
+black bars (THUMBNAIL_FIT=letterbox).
+*/
+
+const (
+	thumbnailFitCrop      = "crop"
+	thumbnailFitLetterbox = "letterbox"
+)
+
+// The aspect ratio thumbnails are fitted to, and how. The zero value leaves them
+// as they are.
+type thumbnailShape struct {
+	aspectW, aspectH int
+	fit              string
+}
+
+// Parses a "W:H" aspect ratio ("" for none) and a fit strategy
+func parseThumbnailShape(aspect, fit string) (thumbnailShape, error) {
+	if aspect == "" {
+		return thumbnailShape{}, nil
+	}
+	if fit != thumbnailFitCrop && fit != thumbnailFitLetterbox {
+		return thumbnailShape{}, fmt.Errorf("fit must be %s or %s, got %q", thumbnailFitCrop, thumbnailFitLetterbox, fit)
+	}
+	wString, hString, ok := strings.Cut(aspect, ":")
+	w, wErr := strconv.Atoi(wString)
+	h, hErr := strconv.Atoi(hString)
+	if !ok || wErr != nil || hErr != nil || w <= 0 || h <= 0 {
+		return thumbnailShape{}, fmt.Errorf("aspect ratio must look like 16:9, got %q", aspect)
+	}
+	return thumbnailShape{aspectW: w, aspectH: h, fit: fit}, nil
+}
+
+// Whether thumbnails are reshaped at all
+func (s thumbnailShape) enabled() bool {
+	return s.aspectW > 0 && s.aspectH > 0
+}
+
+// Crops or letterboxes img, centered, to the target aspect ratio. Images
+// already that shape are returned as they are.
+func (s thumbnailShape) apply(img image.Image) image.Image {
+	if !s.enabled() {
+		return img
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return img
+	}
+
+	// Size of the target shape that fits inside the image, and the one that fits around it
+	tooWide := w*s.aspectH > h*s.aspectW
+	innerW, innerH := w, h
+	outerW, outerH := w, h
+	if tooWide {
+		innerW = max(1, h*s.aspectW/s.aspectH)
+		outerH = w * s.aspectH / s.aspectW
+	} else {
+		innerH = max(1, w*s.aspectH/s.aspectW)
+		outerW = h * s.aspectW / s.aspectH
+	}
+	if innerW == w && innerH == h {
+		return img
+	}
+
+	if s.fit == thumbnailFitLetterbox {
+		out := image.NewRGBA(image.Rect(0, 0, outerW, outerH))
+		draw.Draw(out, out.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		offset := image.Pt((outerW-w)/2, (outerH-h)/2)
+		draw.Draw(out, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(w, h))}, img, bounds.Min, draw.Over)
+		return out
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, innerW, innerH))
+	origin := bounds.Min.Add(image.Pt((w-innerW)/2, (h-innerH)/2))
+	draw.Draw(out, out.Bounds(), img, origin, draw.Src)
+	return out
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Candidate frames considered for an automatic thumbnail: one every
+// thumbnailCandidateInterval seconds from where the search starts, up to
+// thumbnailCandidateCount
+const (
+	thumbnailCandidateCount    = 8
+	thumbnailCandidateInterval = 2
+	thumbnailMaxWidth          = 1280
+)
+
+// Frames darker/brighter than this (average luma, 0-255) or flatter than
+// thumbnailMinContrast (luma standard deviation) count as blank
+const (
+	thumbnailMinBrightness = 24
+	thumbnailMaxBrightness = 235
+	thumbnailMinContrast   = 12
+)
+
+// Samples several frames from startSeconds into the video and returns the path
+// of the one with the most detail, skipping black, white and flat frames (intros,
+// fades, title cards). Falls back to the least blank candidate if they're all
+// blank. The caller owns (and removes) the returned JPEG.
+func selectBestThumbnailFrame(videoPath string, startSeconds float64) (string, error) {
+	candidatesDir, err := os.MkdirTemp("", "tubely-thumbs-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create candidates dir: %w", err)
+	}
+	defer os.RemoveAll(candidatesDir)
+
+	cmd := exec.Command("ffmpeg",
+		"-ss", strconv.FormatFloat(startSeconds, 'f', 3, 64),
+		"-i", videoPath,
+		"-vf", fmt.Sprintf("fps=1/%d,scale='min(%d,iw)':-2", thumbnailCandidateInterval, thumbnailMaxWidth),
+		"-frames:v", fmt.Sprint(thumbnailCandidateCount),
+		"-q:v", "2",
+		filepath.Join(candidatesDir, "candidate_%02d.jpg"),
+	)
+	err = runCommand(cmd)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg candidate extraction failed: %w", err)
+	}
+
+	candidatePaths, err := filepath.Glob(filepath.Join(candidatesDir, "candidate_*.jpg"))
+	if err != nil {
+		return "", err
+	}
+	if len(candidatePaths) == 0 {
+		return "", fmt.Errorf("no frames extracted from %s", videoPath)
+	}
+
+	bestPath := ""
+	bestScore := math.Inf(-1)
+	for _, path := range candidatePaths {
+		img, err := decodeJPEGFile(path)
+		if err != nil {
+			return "", err
+		}
+		score := thumbnailScore(img)
+		if score > bestScore {
+			bestPath = path
+			bestScore = score
+		}
+	}
+
+	// Move the winner out of the candidates dir before it's removed
+	out, err := os.CreateTemp("", "tubely-thumbnail-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+	err = os.Rename(bestPath, out.Name())
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// Scores a frame by its contrast (luma standard deviation). Blank frames score
+// below every non-blank one, ordered by how far they are from usable.
+func thumbnailScore(img image.Image) float64 {
+	mean, stddev := lumaStats(img)
+	if mean < thumbnailMinBrightness || mean > thumbnailMaxBrightness || stddev < thumbnailMinContrast {
+		distance := math.Max(thumbnailMinBrightness-mean, 0) + math.Max(mean-thumbnailMaxBrightness, 0)
+		return stddev - distance - 1000
+	}
+	return stddev
+}
+
+// Mean and standard deviation of the luma (0-255) over a grid of sampled pixels
+func lumaStats(img image.Image) (float64, float64) {
+	bounds := img.Bounds()
+	step := max(1, min(bounds.Dx(), bounds.Dy())/64)
+
+	var sum, sumSquares, n float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, _ := img.At(x, y).RGBA()
+			// Rec. 601 luma from 16-bit channels
+			luma := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			sum += luma
+			sumSquares += luma * luma
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	mean := sum / n
+	variance := math.Max(sumSquares/n-mean*mean, 0)
+	return mean, math.Sqrt(variance)
+}
+
+// Picks a frame from the processed video, at the position configured for its
+// aspect ratio (detected if it's ""), and saves it as the video's thumbnail in
+// the assets directory
+func (cfg *apiConfig) generateAutoThumbnail(video database.Video, videoPath, aspectRatio string) (database.Video, error) {
+	framePath, err := selectBestThumbnailFrame(videoPath, cfg.autoThumbnailStart(videoPath, aspectRatio))
+	if err != nil {
+		return video, err
+	}
+	defer os.Remove(framePath)
+
+	return cfg.saveThumbnailFrame(video, framePath)
+}
+
+// Copies a JPEG frame into the assets directory, fitted to the configured
+// thumbnail shape, and makes it the video's thumbnail
+func (cfg *apiConfig) saveThumbnailFrame(video database.Video, framePath string) (database.Video, error) {
+	randomString, err := generateRandomName()
+	if err != nil {
+		return video, err
+	}
+	filename := randomString + ".jpg"
+	thumbnailPath := filepath.Join(cfg.assetsRoot, filename)
+
+	dat, err := os.ReadFile(framePath)
+	if err != nil {
+		return video, err
+	}
+	if cfg.thumbnailShape.enabled() {
+		img, err := jpeg.Decode(bytes.NewReader(dat))
+		if err != nil {
+			return video, fmt.Errorf("failed to decode frame: %w", err)
+		}
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, cfg.thumbnailShape.apply(img), &jpeg.Options{Quality: 90})
+		if err != nil {
+			return video, err
+		}
+		dat = buf.Bytes()
+	}
+	if cfg.progressiveThumbnails {
+		dat = progressiveJPEG(dat)
+	}
+	err = os.WriteFile(thumbnailPath, dat, 0644)
+	if err != nil {
+		return video, fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+
+	// Only the thumbnail is ours to change: the row is read again so whatever
+	// else changed while the frame was picked (visibility, title, ...) stays
+	current, err := cfg.db.GetVideo(video.ID)
+	if err == nil && current.ID == uuid.Nil {
+		err = fmt.Errorf("video %s no longer exists", video.ID)
+	}
+	if err != nil {
+		os.Remove(thumbnailPath)
+		return video, err
+	}
+
+	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
+	current.ThumbnailURL = &thumbnailURL
+	cfg.setThumbnailPlaceholder(&current, thumbnailPath)
+	current.UpdatedAt = time.Now()
+	err = cfg.db.UpdateVideo(current)
+	if err != nil {
+		return video, err
+	}
+	return current, nil
+}
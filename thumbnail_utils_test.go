@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// A thumbnail saved from a copy of the video loaded before processing mustn't
+// undo changes made to the video in the meantime
+func TestSaveThumbnailFrameKeepsConcurrentChanges(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	cfg.assetsRoot = t.TempDir()
+	stale, _ := createTestVideo(t, cfg, database.VisibilityPublic)
+
+	changed := stale
+	changed.Visibility = database.VisibilityPrivate
+	changed.Title = "Renamed"
+	if err := cfg.db.UpdateVideo(changed); err != nil {
+		t.Fatalf("couldn't update video: %v", err)
+	}
+
+	framePath := filepath.Join(t.TempDir(), "frame.jpg")
+	frame, err := os.Create(framePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jpeg.Encode(frame, image.NewGray(image.Rect(0, 0, 16, 9)), nil)
+	frame.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cfg.saveThumbnailFrame(stale, framePath); err != nil {
+		t.Fatalf("couldn't save thumbnail: %v", err)
+	}
+
+	video, err := cfg.db.GetVideo(stale.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ThumbnailURL == nil {
+		t.Error("thumbnail wasn't saved")
+	}
+	if video.Visibility != database.VisibilityPrivate || video.Title != "Renamed" {
+		t.Errorf("got visibility %q and title %q, want the changes made meanwhile kept", video.Visibility, video.Title)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+Upload recovery
+
+Upload states are saved to the database as they change (see upload_status.go),
+so a restart can tell which uploads it cut off. At startup:
+
+- Uploads still receiving their file are marked failed. Their partial files
+  and resumable upload sessions only lived in this process.
+- Uploads that were processing start over from the beginning if their
+  complete file is still on disk under UPLOAD_TEMP_DIR, as a background job
+  that's retried if it fails (see worker_pool.go). The file is removed once
+  they're done.
+- Other uploads that were processing are marked failed.
+
+Finished uploads are loaded back too, so their outcome stays visible for
+UPLOAD_STATUS_TTL.
+*/
+
+const (
+	uploadInterruptedReason     = "Upload was interrupted by a server restart"
+	processingInterruptedReason = "Processing was interrupted by a server restart"
+)
+
+// The upload options saved with a processing upload, enough to run it again
+type savedUploadOptions struct {
+	AliasedType    bool           `json:"aliased_type,omitempty"`
+	SkipProcessing bool           `json:"skip_processing,omitempty"`
+	NoFastStart    bool           `json:"no_fast_start,omitempty"`
+	Profile        string         `json:"profile"`
+	Metadata       objectMetadata `json:"metadata"`
+	IfMatch        string         `json:"if_match,omitempty"`
+	Draft          bool           `json:"draft,omitempty"`
+	ClientIP       string         `json:"client_ip,omitempty"`
+}
+
+func (opts videoUploadOptions) saved() string {
+	dat, err := json.Marshal(savedUploadOptions{
+		AliasedType:    opts.aliasedType,
+		SkipProcessing: opts.skipProcessing,
+		NoFastStart:    opts.noFastStart,
+		Profile:        opts.profile.name,
+		Metadata:       opts.metadata,
+		IfMatch:        opts.ifMatch,
+		Draft:          opts.draft,
+		ClientIP:       opts.clientIP,
+	})
+	if err != nil {
+		return ""
+	}
+	return string(dat)
+}
+
+// Rebuilds upload options from what was saved. Fails if the processing profile
+// no longer exists.
+func (cfg *apiConfig) savedUploadOptions(saved string) (videoUploadOptions, error) {
+	var opts savedUploadOptions
+	err := json.Unmarshal([]byte(saved), &opts)
+	if err != nil {
+		return videoUploadOptions{}, fmt.Errorf("invalid saved options: %w", err)
+	}
+	profile, ok := cfg.processingProfile(opts.Profile)
+	if !ok {
+		return videoUploadOptions{}, fmt.Errorf("unknown processing profile %q", opts.Profile)
+	}
+	return videoUploadOptions{
+		aliasedType:    opts.AliasedType,
+		skipProcessing: opts.SkipProcessing,
+		noFastStart:    opts.NoFastStart,
+		profile:        profile,
+		metadata:       opts.Metadata,
+		ifMatch:        opts.IfMatch,
+		draft:          opts.Draft,
+		clientIP:       opts.ClientIP,
+	}, nil
+}
+
+// Resumes or fails the uploads a previous run left unfinished; see above
+func (cfg *apiConfig) recoverUploads() {
+	states, err := cfg.db.GetUploadStates()
+	if err != nil {
+		log.Printf("Couldn't load saved upload states: %v", err)
+		return
+	}
+
+	resumed, failed := 0, 0
+	for _, state := range states {
+		video, err := cfg.db.GetVideo(state.VideoID)
+		if err != nil {
+			log.Printf("Couldn't get video %s of saved upload: %v", state.VideoID, err)
+			continue
+		}
+		if video.ID == uuid.Nil {
+			cfg.db.DeleteUploadState(state.VideoID)
+			continue
+		}
+
+		cfg.uploads.restore(state)
+		switch state.State {
+		case uploadStateUploading:
+			cfg.uploads.abandon(state.VideoID, uploadInterruptedReason)
+			failed++
+		case uploadStateProcessing:
+			err := cfg.resumeProcessing(video, state)
+			if err != nil {
+				log.Printf("Couldn't resume processing of video %s: %v", video.ID, err)
+				cfg.uploads.finish(video.ID, &uploadError{msg: processingInterruptedReason, err: err})
+				if state.SourcePath != "" {
+					os.Remove(state.SourcePath)
+				}
+				failed++
+				continue
+			}
+			resumed++
+		}
+	}
+	if resumed > 0 || failed > 0 {
+		log.Printf("Recovered interrupted uploads: %d resumed, %d failed", resumed, failed)
+	}
+}
+
+// Runs an interrupted upload through the pipeline again in the background, if
+// its file survived the restart
+func (cfg *apiConfig) resumeProcessing(video database.Video, state database.UploadState) error {
+	if state.SourcePath == "" {
+		return fmt.Errorf("no source file was saved")
+	}
+	_, err := os.Stat(state.SourcePath)
+	if err != nil {
+		return fmt.Errorf("source file is gone: %w", err)
+	}
+	opts, err := cfg.savedUploadOptions(state.Options)
+	if err != nil {
+		return err
+	}
+
+	opts.finishedByCaller = true
+
+	log.Printf("Resuming processing of video %s from %s", video.ID, state.SourcePath)
+	// Don't hold up startup waiting for a free worker
+	go cfg.backgroundJobs.submit(backgroundJob{
+		name: fmt.Sprintf("resume processing of video %s", video.ID),
+		run: func() error {
+			// The video may have changed since the last attempt
+			current, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				return err
+			}
+			if current.ID == uuid.Nil {
+				return permanentJobFailure(errors.New("video was deleted"))
+			}
+			_, err = cfg.processVideoUpload(context.Background(), current, state.SourcePath, opts)
+			// Rejected uploads would only be rejected again
+			var uploadErr *uploadError
+			if errors.As(err, &uploadErr) && uploadErr.status < http.StatusInternalServerError {
+				return permanentJobFailure(err)
+			}
+			return err
+		},
+		retrying: func(attempts int, err error) {
+			cfg.uploads.retrying(video.ID, attempts)
+		},
+		done: func(attempts int, err error) {
+			os.Remove(state.SourcePath)
+			cfg.uploads.setAttempts(video.ID, attempts)
+			cfg.uploads.finish(video.ID, err)
+		},
+	})
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+/*
+Polyglot detection
+
+A polyglot is a file that is valid in two formats at once, e.g. a JPEG with a
+ZIP archive appended (most image decoders stop at the end of the image, most
+ZIP readers start from the end of the file) or an MP4 carrying an HTML page in
+a free box. Content type checks only look at the start of the file, so these
+pass them.
+
+The scan looks for the signatures of formats that have no business inside an
+image or video: archives, executables, PDFs and markup/script that a browser
+or server might execute. How much of the file is scanned depends on the
+configured strictness:
+
+	off       no scanning
+	standard  images in full; videos in their first and last megabyte, where
+	          appended or prepended payloads end up
+	strict    every byte of every file
+*/
+
+const (
+	polyglotCheckOff      = "off"
+	polyglotCheckStandard = "standard"
+	polyglotCheckStrict   = "strict"
+)
+
+// Bytes scanned at each end of a video in standard mode
+const polyglotVideoScanWindow = 1 << 20
+
+type embeddedSignature struct {
+	name  string
+	magic []byte
+	// Markup is matched case-insensitively; magic must be lowercase
+	caseInsensitive bool
+}
+
+var embeddedSignatures = []embeddedSignature{
+	{"ZIP archive", []byte("PK\x03\x04"), false},
+	{"ZIP archive", []byte("PK\x05\x06"), false},
+	{"RAR archive", []byte("Rar!\x1a\x07"), false},
+	{"7z archive", []byte("7z\xbc\xaf\x27\x1c"), false},
+	{"ELF executable", []byte("\x7fELF"), false},
+	{"PDF document", []byte("%PDF-"), false},
+	{"PHP script", []byte("<?php"), true},
+	{"HTML script", []byte("<script"), true},
+	{"HTML document", []byte("<html"), true},
+	{"HTML document", []byte("<!doctype html"), true},
+}
+
+func isValidPolyglotCheck(mode string) bool {
+	switch mode {
+	case polyglotCheckOff, polyglotCheckStandard, polyglotCheckStrict:
+		return true
+	}
+	return false
+}
+
+// Rejects uploads that embed another format, scanning as much of the file as the
+// configured strictness calls for. isVideo selects the windowed scan for large files.
+func (cfg *apiConfig) checkPolyglot(r io.ReaderAt, size int64, isVideo bool) error {
+	var found string
+	var err error
+	switch {
+	case cfg.polyglotCheck == polyglotCheckOff:
+		return nil
+	case cfg.polyglotCheck == polyglotCheckStandard && isVideo && size > 2*polyglotVideoScanWindow:
+		found, err = scanForSignatures(r, 0, polyglotVideoScanWindow)
+		if err == nil && found == "" {
+			found, err = scanForSignatures(r, size-polyglotVideoScanWindow, size)
+		}
+	default:
+		found, err = scanForSignatures(r, 0, size)
+	}
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "Failed to scan upload", err}
+	}
+	if found != "" {
+		return &uploadError{http.StatusBadRequest, fmt.Sprintf("File contains an embedded %s and was rejected", found), nil}
+	}
+	return nil
+}
+
+// Returns the name of the first embedded signature found in [start, end), or ""
+func scanForSignatures(r io.ReaderAt, start, end int64) (string, error) {
+	longest := 0
+	for _, sig := range embeddedSignatures {
+		longest = max(longest, len(sig.magic))
+	}
+
+	const chunkSize = 1 << 20
+	buf := make([]byte, chunkSize+longest-1)
+	for offset := start; offset < end; offset += chunkSize {
+		// Overlap chunks so a signature straddling the boundary is still seen
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), end-offset)], offset)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		chunk := buf[:n]
+		lower := bytes.ToLower(chunk)
+		for _, sig := range embeddedSignatures {
+			haystack := chunk
+			if sig.caseInsensitive {
+				haystack = lower
+			}
+			if bytes.Contains(haystack, sig.magic) {
+				return sig.name, nil
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return "", nil
+}
+
+// checkPolyglot for a video saved on disk
+func (cfg *apiConfig) checkPolyglotFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "Failed to scan upload", err}
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "Failed to scan upload", err}
+	}
+	return cfg.checkPolyglot(f, info.Size(), true)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	uploadStateUploading  = "uploading"
+	uploadStateProcessing = "processing"
+	uploadStateComplete   = "complete"
+	uploadStateFailed     = "failed"
+)
+
+// Where a video upload is at, from the first byte received until it's stored
+type uploadStatus struct {
+	VideoID       uuid.UUID `json:"video_id"`
+	UserID        uuid.UUID `json:"-"`
+	State         string    `json:"state"`
+	Stage         string    `json:"stage,omitempty"`
+	BytesReceived int64     `json:"bytes_received"`
+	// 0 if the client didn't say how big the upload is
+	BytesTotal int64   `json:"bytes_total"`
+	Progress   float64 `json:"progress"`
+	Error      string  `json:"error,omitempty"`
+	// How many times processing was tried, when it's retried in the background
+	Attempts  int       `json:"attempts,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// While processing: the complete upload on disk and how to process it (JSON),
+	// so processing can start over after a restart; see upload_recovery.go
+	sourcePath string
+	options    string
+}
+
+// Tracks uploads, one entry per video. Finished entries are kept for ttl so
+// other sessions can see the outcome; unfinished ones are dropped once they've
+// been idle for idleTimeout (e.g. an abandoned resumable upload). Every change
+// of state is also saved to the database so a restart doesn't lose it; byte
+// counts aren't, as they change too often to be worth writing.
+type uploadTracker struct {
+	db          database.Client
+	ttl         time.Duration
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	statuses map[uuid.UUID]*uploadStatus
+}
+
+func newUploadTracker(db database.Client, ttl, idleTimeout time.Duration) *uploadTracker {
+	return &uploadTracker{
+		db:          db,
+		ttl:         ttl,
+		idleTimeout: idleTimeout,
+		statuses:    make(map[uuid.UUID]*uploadStatus),
+	}
+}
+
+// Starts tracking an upload of total bytes, replacing any earlier entry for the video
+func (t *uploadTracker) start(videoID, userID uuid.UUID, total int64) {
+	now := time.Now()
+	status := uploadStatus{
+		VideoID:    videoID,
+		UserID:     userID,
+		State:      uploadStateUploading,
+		BytesTotal: max(total, 0),
+		StartedAt:  now,
+		UpdatedAt:  now,
+	}
+	t.mu.Lock()
+	t.statuses[videoID] = &status
+	t.mu.Unlock()
+	t.save(status)
+}
+
+// Moves the upload into processing of the file at sourcePath with the given
+// options, starting an entry if the upload didn't go through the server (a
+// direct multipart upload)
+func (t *uploadTracker) beginProcessing(videoID, userID uuid.UUID, sourcePath, options string) {
+	t.mu.Lock()
+	status, ok := t.statuses[videoID]
+	if !ok {
+		status = &uploadStatus{
+			VideoID:   videoID,
+			UserID:    userID,
+			StartedAt: time.Now(),
+		}
+		t.statuses[videoID] = status
+	}
+	status.State = uploadStateProcessing
+	status.Progress = 100
+	status.Error = ""
+	status.sourcePath = sourcePath
+	status.options = options
+	status.UpdatedAt = time.Now()
+	saved := *status
+	t.mu.Unlock()
+	t.save(saved)
+}
+
+// Records how many bytes of the upload have arrived so far
+func (t *uploadTracker) received(videoID uuid.UUID, n int64) {
+	t.update(videoID, func(status *uploadStatus) bool {
+		status.BytesReceived = n
+		if status.BytesTotal > 0 {
+			status.Progress = min(float64(n)/float64(status.BytesTotal), 1) * 100
+		}
+		return false
+	})
+}
+
+// Moves the upload into processing, at the named pipeline stage
+func (t *uploadTracker) setStage(videoID uuid.UUID, stage string) {
+	t.update(videoID, func(status *uploadStatus) bool {
+		status.State = uploadStateProcessing
+		status.Stage = stage
+		status.Progress = 100
+		return true
+	})
+}
+
+// Marks the upload complete, or failed if err is set
+func (t *uploadTracker) finish(videoID uuid.UUID, err error) {
+	t.update(videoID, func(status *uploadStatus) bool {
+		status.Stage = ""
+		status.sourcePath = ""
+		status.options = ""
+		if err != nil {
+			// Only the client-facing message; the details were already logged
+			status.State = uploadStateFailed
+			status.Error = "Failed to process video"
+			var uploadErr *uploadError
+			if errors.As(err, &uploadErr) {
+				status.Error = uploadErr.msg
+			}
+			return true
+		}
+		status.State = uploadStateComplete
+		return true
+	})
+}
+
+// Records a failed processing attempt that will be retried
+func (t *uploadTracker) retrying(videoID uuid.UUID, attempts int) {
+	t.update(videoID, func(status *uploadStatus) bool {
+		status.State = uploadStateProcessing
+		status.Stage = "waiting_to_retry"
+		status.Attempts = attempts
+		return true
+	})
+}
+
+// Records how many times processing was tried in all
+func (t *uploadTracker) setAttempts(videoID uuid.UUID, attempts int) {
+	t.update(videoID, func(status *uploadStatus) bool {
+		status.Attempts = attempts
+		return false
+	})
+}
+
+// Marks the upload failed if it never made it to processing, e.g. because the
+// request was cut off or rejected before the file was complete
+func (t *uploadTracker) abandon(videoID uuid.UUID, reason string) {
+	t.update(videoID, func(status *uploadStatus) bool {
+		if status.State != uploadStateUploading {
+			return false
+		}
+		status.State = uploadStateFailed
+		status.Error = reason
+		return true
+	})
+}
+
+// Applies fn to the video's entry, if there is one, and saves the entry if fn
+// reports a change worth keeping
+func (t *uploadTracker) update(videoID uuid.UUID, fn func(*uploadStatus) bool) {
+	t.mu.Lock()
+	status, ok := t.statuses[videoID]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	persist := fn(status)
+	status.UpdatedAt = time.Now()
+	saved := *status
+	t.mu.Unlock()
+
+	if persist {
+		t.save(saved)
+	}
+}
+
+// Writes an entry to the database. Failures are only logged: the entry in
+// memory is still right, it just won't survive a restart.
+func (t *uploadTracker) save(status uploadStatus) {
+	err := t.db.SaveUploadState(database.UploadState{
+		VideoID:    status.VideoID,
+		UserID:     status.UserID,
+		State:      status.State,
+		Stage:      status.Stage,
+		BytesTotal: status.BytesTotal,
+		Error:      status.Error,
+		Attempts:   status.Attempts,
+		SourcePath: status.sourcePath,
+		Options:    status.options,
+		StartedAt:  status.StartedAt,
+		UpdatedAt:  status.UpdatedAt,
+	})
+	if err != nil {
+		log.Printf("Couldn't save upload state of video %s: %v", status.VideoID, err)
+	}
+}
+
+// Puts a saved entry back in memory, as it was when it was saved
+func (t *uploadTracker) restore(state database.UploadState) {
+	status := &uploadStatus{
+		VideoID:    state.VideoID,
+		UserID:     state.UserID,
+		State:      state.State,
+		Stage:      state.Stage,
+		BytesTotal: state.BytesTotal,
+		Error:      state.Error,
+		Attempts:   state.Attempts,
+		StartedAt:  state.StartedAt,
+		UpdatedAt:  state.UpdatedAt,
+		sourcePath: state.SourcePath,
+		options:    state.Options,
+	}
+	if state.State != uploadStateUploading {
+		status.BytesReceived = state.BytesTotal
+		status.Progress = 100
+	}
+	t.mu.Lock()
+	t.statuses[state.VideoID] = status
+	t.mu.Unlock()
+}
+
+// The user's uploads, newest first
+func (t *uploadTracker) forUser(userID uuid.UUID) []uploadStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	statuses := []uploadStatus{}
+	for _, status := range t.statuses {
+		if status.UserID == userID {
+			statuses = append(statuses, *status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].StartedAt.After(statuses[j].StartedAt)
+	})
+	return statuses
+}
+
+// Periodically forgets finished and idle entries
+func (t *uploadTracker) startJanitor(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			var forgotten []uuid.UUID
+			t.mu.Lock()
+			for videoID, status := range t.statuses {
+				finished := status.State == uploadStateComplete || status.State == uploadStateFailed
+				idle := time.Since(status.UpdatedAt)
+				if (finished && idle > t.ttl) || (!finished && idle > t.idleTimeout) {
+					delete(t.statuses, videoID)
+					forgotten = append(forgotten, videoID)
+				}
+			}
+			t.mu.Unlock()
+
+			for _, videoID := range forgotten {
+				err := t.db.DeleteUploadState(videoID)
+				if err != nil {
+					log.Printf("Couldn't delete upload state of video %s: %v", videoID, err)
+				}
+			}
+		}
+	}()
+}
+
+// Reports bytes read from the request body to the tracker as they arrive
+type progressReader struct {
+	io.ReadCloser
+	tracker *uploadTracker
+	videoID uuid.UUID
+	read    int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	r.tracker.received(r.videoID, r.read)
+	return n, err
+}
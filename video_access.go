@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Loads the video named by the videoID path value and checks the request may
+// watch it, responding with an error if not. Public videos are open to
+// everyone; private and scheduled ones only to their owner, by JWT. Everyone
+// else gets the same 404 as for a video that doesn't exist, so private videos
+// can't be found by probing IDs.
+func (cfg *apiConfig) authorizeVideoViewer(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil || !cfg.canViewVideo(r, video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// Whether the request may watch the video: it's public, or the request carries
+// its owner's JWT. Scheduled videos stay private until the publish scheduler
+// makes them public.
+func (cfg *apiConfig) canViewVideo(r *http.Request, video database.Video) bool {
+	if video.Visibility == database.VisibilityPublic {
+		return true
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	return err == nil && userID == video.UserID
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Scheduled videos are private until the publish scheduler makes them public:
+// before published_at, only their owner can get or play them
+func TestScheduledVideoHiddenUntilPublished(t *testing.T) {
+	cfg := newTestAPIConfig(t)
+	video, ownerToken := createTestVideo(t, cfg, database.VisibilityPrivate)
+	_, otherToken := createTestVideo(t, cfg, database.VisibilityPublic)
+
+	publishedAt := time.Now().Add(time.Hour)
+	video.Visibility = database.VisibilityScheduled
+	video.PublishedAt = &publishedAt
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatalf("couldn't schedule video: %v", err)
+	}
+
+	mux := newTestMux(map[string]http.HandlerFunc{
+		"GET /api/v1/videos/{videoID}":      cfg.handlerVideoGet,
+		"GET /api/v1/videos/{videoID}/play": cfg.handlerVideoPlay,
+	})
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"get without token", "", "", http.StatusNotFound},
+		{"get as another user", "", otherToken, http.StatusNotFound},
+		{"get as owner", "", ownerToken, http.StatusOK},
+		{"play without token", "/play", "", http.StatusNotFound},
+		{"play as another user", "/play", otherToken, http.StatusNotFound},
+		{"play as owner", "/play", ownerToken, http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/videos/"+video.ID.String()+tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
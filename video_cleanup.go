@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Removes everything stored for a deleted video: its S3 object (in its owner's
+// own bucket if that's where it is), every object under its asset prefixes (renditions, sprites, contact sheets, ...) whether or
+// not it's still recorded, recorded assets stored elsewhere in our buckets, and a
+// thumbnail in the local assets directory. Returns how many S3 objects were deleted.
+func (cfg *apiConfig) deleteVideoFiles(ctx context.Context, video database.Video, assets []database.VideoAsset) (int, error) {
+	var errs []error
+
+	objects := make(map[s3Object]bool)
+	ownDeleted := 0
+	addKey := func(stored string) {
+		bucket, key, err := parseStoredURL(stored)
+		if err == nil && (bucket == cfg.s3Bucket || cfg.isAssetBucket(bucket)) {
+			objects[s3Object{bucket, key}] = true
+		}
+	}
+	for _, asset := range assets {
+		addKey(asset.URL)
+	}
+
+	if video.VideoURL != nil && *video.VideoURL != "" {
+		addKey(*video.VideoURL)
+
+		bucket, key, err := parseStoredURL(*video.VideoURL)
+		ownBucket := false
+		if err == nil && bucket != cfg.s3Bucket {
+			// Stored in the owner's own bucket, with its derived files in ours
+			var deleteErr error
+			ownBucket, deleteErr = cfg.deleteFromUserStorage(ctx, video.UserID, bucket, key)
+			if deleteErr != nil {
+				errs = append(errs, deleteErr)
+			} else if ownBucket {
+				ownDeleted++
+			}
+		}
+		if err == nil && (bucket == cfg.s3Bucket || ownBucket) {
+			err = cfg.listAssetObjects(ctx, key, objects)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	deleted, err := cfg.deleteObjects(ctx, objects)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// The thumbnail goes last: if anything in S3 couldn't be deleted the video
+	// is kept, and it should still have its thumbnail
+	if len(errs) == 0 && video.ThumbnailURL != nil {
+		if thumbnailPath, ok := cfg.localAssetPath(*video.ThumbnailURL); ok {
+			err := os.Remove(thumbnailPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return deleted + ownDeleted, errors.Join(errs...)
+}
+
+// An object in one of our buckets
+type s3Object struct {
+	bucket string
+	key    string
+}
+
+// Adds every object that may have been derived from the video at videoKey to
+// found, wherever assets are routed
+func (cfg *apiConfig) listAssetObjects(ctx context.Context, videoKey string, found map[s3Object]bool) error {
+	var errs []error
+	for _, location := range cfg.assetLocations(videoKey) {
+		paginator := s3.NewListObjectsV2Paginator(cfg.s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(location.bucket),
+			Prefix: aws.String(location.prefix),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to list %s in %s: %w", location.prefix, location.bucket, err))
+				break
+			}
+			for _, object := range page.Contents {
+				found[s3Object{location.bucket, aws.ToString(object.Key)}] = true
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Deletes objects from our buckets, a bucket at a time. Returns how many were
+// deleted.
+func (cfg *apiConfig) deleteObjects(ctx context.Context, objects map[s3Object]bool) (int, error) {
+	byBucket := make(map[string][]types.ObjectIdentifier)
+	for object := range objects {
+		byBucket[object.bucket] = append(byBucket[object.bucket], types.ObjectIdentifier{Key: aws.String(object.key)})
+	}
+	deleted := 0
+	var errs []error
+	for bucket, identifiers := range byBucket {
+		n, err := cfg.deleteS3ObjectsIn(ctx, bucket, identifiers)
+		deleted += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// Deletes key from userID's own bucket if that's bucket. Returns whether it was.
+func (cfg *apiConfig) deleteFromUserStorage(ctx context.Context, userID uuid.UUID, bucket, key string) (bool, error) {
+	storage, err := cfg.storageForObject(userID, bucket)
+	if err != nil || storage.bucket != bucket {
+		return false, err
+	}
+	_, err = storage.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to delete %s from %s: %w", key, bucket, err)
+	}
+	return true, nil
+}
+
+// Asset kinds generated from a video's file, which go stale when the file is
+// replaced. Chapters are written by the owner and outlive the file.
+var derivedAssetKinds = []string{assetKindRendition, "sprite", "thumbnail_track", assetKindContactSheet, assetKindPreviewLoop}
+
+// Removes what was generated from a video's previous file once it's been
+// replaced: the records of its derived assets, and every object under the
+// previous file's asset prefix that isn't still recorded or the video's current
+// file. Returns how many S3 objects were deleted.
+func (cfg *apiConfig) deleteStaleAssets(ctx context.Context, video database.Video, previousVideoURL string) (int, error) {
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	keep := make(map[s3Object]bool)
+	if video.VideoURL != nil {
+		if bucket, key, err := parseStoredURL(*video.VideoURL); err == nil {
+			keep[s3Object{bucket, key}] = true
+		}
+	}
+	stale := make(map[s3Object]bool)
+	for _, asset := range assets {
+		bucket, key, err := parseStoredURL(asset.URL)
+		if !slices.Contains(derivedAssetKinds, asset.Kind) {
+			if err == nil {
+				keep[s3Object{bucket, key}] = true
+			}
+			continue
+		}
+		if err == nil && (bucket == cfg.s3Bucket || cfg.isAssetBucket(bucket)) {
+			stale[s3Object{bucket, key}] = true
+		}
+		err = cfg.db.DeleteVideoAsset(video.ID, asset.Kind, asset.Name)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Derived files are always in our buckets, even for videos stored elsewhere
+	var errs []error
+	if _, previousKey, err := parseStoredURL(previousVideoURL); err == nil {
+		err = cfg.listAssetObjects(ctx, previousKey, stale)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for object := range keep {
+		delete(stale, object)
+	}
+	deleted, err := cfg.deleteObjects(ctx, stale)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return deleted, errors.Join(errs...)
+}
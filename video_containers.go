@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+/*
+Upload containers
+
+Besides MP4, videos can be uploaded as QuickTime (MOV, what phones record) or
+WebM (what browsers record). ALLOWED_VIDEO_CONTAINERS limits which of these are
+accepted. Whatever comes in is stored as MP4: MOV files with browser-friendly
+codecs are only remuxed, anything else is transcoded to H.264/AAC first, so
+the stored key still ends in .mp4 and is served as video/mp4.
+
+The content type the client declares only decides whether an upload is
+accepted at all; ffprobe decides what the file really is.
+*/
+
+var supportedVideoContainers = []string{"mp4", "mov", "webm"}
+
+// The container each accepted content type declares
+var videoContainerTypes = map[string]string{
+	"video/mp4":       "mp4",
+	"video/quicktime": "mov",
+	"video/webm":      "webm",
+}
+
+// Checks every configured container is one we know how to convert to MP4
+func validateVideoContainers(containers []string) error {
+	if len(containers) == 0 {
+		return fmt.Errorf("no video containers allowed")
+	}
+	for _, container := range containers {
+		if !slices.Contains(supportedVideoContainers, container) {
+			return fmt.Errorf("unknown video container %q, expected one of %s", container, strings.Join(supportedVideoContainers, ", "))
+		}
+	}
+	return nil
+}
+
+// The content types uploads can be declared as, given the allowed containers
+func (cfg *apiConfig) allowedVideoContentTypes() []string {
+	var contentTypes []string
+	for contentType, container := range videoContainerTypes {
+		if slices.Contains(cfg.allowedVideoContainers, container) {
+			contentTypes = append(contentTypes, contentType)
+		}
+	}
+	slices.Sort(contentTypes)
+	return contentTypes
+}
+
+// The error for an upload in a container that isn't allowed
+func (cfg *apiConfig) unsupportedContainerError() *uploadError {
+	names := strings.ToUpper(strings.Join(cfg.allowedVideoContainers, ", "))
+	return &uploadError{http.StatusBadRequest, fmt.Sprintf("Only %s videos are allowed", names), nil}
+}
+
+// Works out which of the supported containers a file is in. Returns "" for
+// anything else. ffprobe reports MP4 and MOV as the same format, so they're told
+// apart by the major brand; it also can't tell WebM from other Matroska files,
+// which are accepted alike.
+func detectVideoContainer(filePath string) (string, error) {
+	probeOutput, err := probeVideo(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	formats := strings.Split(probeOutput.Format.FormatName, ",")
+	switch {
+	case slices.Contains(formats, "mp4"):
+		if strings.TrimSpace(probeOutput.Format.Tags["major_brand"]) == "qt" {
+			return "mov", nil
+		}
+		return "mp4", nil
+	case slices.Contains(formats, "webm"):
+		return "webm", nil
+	}
+	return "", nil
+}
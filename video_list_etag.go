@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+Video list ETags
+
+Polling clients can send the ETag of the last video list they got as
+If-None-Match and get a 304 if nothing changed. The ETag comes from a cheap
+aggregate over the user's videos and assets (counts and latest update times),
+not from the response itself, so a 304 costs one query instead of signing
+every URL.
+
+A list also holds signed URLs, which a client keeps using while its copy is
+current. Cached URLs are only handed out with at least signedURLMinRemaining
+left, so the ETag also changes every signedURLMinRemaining and a client never
+holds on to a copy whose URLs have expired. View counts aren't part of the
+aggregate and can lag by as much.
+*/
+
+// The ETag of a user's video list as requested with query, signed with opts
+func videoListETag(userID uuid.UUID, version database.VideoListVersion, query string, opts signingOptions) string {
+	window := time.Now().Unix() / int64(signedURLMinRemaining.Seconds())
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%s|%d|%s|%s|%s|%d",
+		userID,
+		version.Videos, version.VideosUpdatedAt,
+		version.Assets, version.AssetsUpdatedAt,
+		query, opts.clientIP, window,
+	))
+	// Weak, since the same list can be serialized differently
+	return fmt.Sprintf(`W/"%x"`, sum[:16])
+}
+
+// Whether an If-None-Match header value matches etag, using weak comparison:
+// the W/ prefix is ignored on both sides
+func etagNoneMatchHit(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// An error from the upload pipeline along with the response it should produce
+type uploadError struct {
+	status int
+	msg    string
+	err    error
+}
+
+func (e *uploadError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: %v", e.msg, e.err)
+	}
+	return e.msg
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+func respondWithUploadError(w http.ResponseWriter, err error) {
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		respondWithError(w, uploadErr.status, uploadErr.msg, uploadErr.err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Failed to process video", err)
+}
+
+type videoUploadOptions struct {
+	// The client sent an MP4 alias content type, so the container must be confirmed
+	aliasedType bool
+	// The client asked to skip fast start processing for an already optimized file
+	skipProcessing bool
+	// The client turned fast start off (fast_start=false), so the file is stored
+	// as it is whether or not it's optimized
+	noFastStart bool
+	// Which optional steps to run; see processing_profiles.go
+	profile processingProfile
+	// Stored on the S3 object along with the video
+	metadata objectMetadata
+	// ETag(s) from If-Match the current video file must still have when it's
+	// replaced; see video_precondition.go
+	ifMatch string
+	// Store the file as a draft; see draft.go
+	draft bool
+	// Where the upload came from, for the audit log
+	clientIP string
+	// The caller marks the upload finished itself, e.g. a background job that
+	// may still retry it
+	finishedByCaller bool
+}
+
+// Takes an uploaded video saved at tempPath through validation, processing and storage,
+// and returns the updated video record. Used by every upload entry point once the
+// file is fully on disk. The caller owns (and removes) tempPath. Canceling ctx, e.g.
+// when the client goes away, abandons storing the file.
+func (cfg *apiConfig) processVideoUpload(ctx context.Context, video database.Video, tempPath string, opts videoUploadOptions) (_ database.Video, err error) {
+	videoID := video.ID
+	cfg.uploads.beginProcessing(videoID, video.UserID, tempPath, opts.saved())
+	cfg.processingLogs.start(videoID)
+	// Intermediate files are all named after tempPath (tempPath.transcoded,
+	// tempPath.transcoded.processing, ...), including partial output left by a
+	// failed ffmpeg run. Failed uploads can be kept for debugging instead.
+	defer func() {
+		if err != nil && cfg.debugFailedUploadsDir != "" {
+			cfg.preserveFailedUpload(videoID, tempPath)
+		}
+		intermediates, _ := filepath.Glob(tempPath + ".*")
+		for _, path := range intermediates {
+			os.Remove(path)
+		}
+	}()
+
+	// Runs before the cleanup above, so a kept processing log has the outcome
+	defer func() {
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Processing failed: %v", err)
+		} else {
+			cfg.processingLogs.printf(videoID, "Processing complete")
+		}
+		if !opts.finishedByCaller {
+			cfg.uploads.finish(videoID, err)
+		}
+	}()
+
+	cfg.processingLogs.printf(videoID, "Processing profile: %s", opts.profile)
+	cfg.setProcessingStage(videoID, "validating")
+
+	// Step 7a: Refuse files that were removed before (e.g. for policy violations)
+	contentHash, err := hashFile(tempPath)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to hash video", err}
+	}
+	blocked, err := cfg.db.IsHashBlocked(contentHash)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to check video", err}
+	}
+	if blocked {
+		cfg.processingLogs.printf(videoID, "Blocked upload of denylisted content %s by user %s", contentHash, video.UserID)
+		return database.Video{}, &uploadError{http.StatusForbidden, "This content has been removed and can't be uploaded again", nil}
+	}
+
+	// Step 7b: Refuse polyglot files that hide another format inside the video
+	err = cfg.checkPolyglotFile(tempPath)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	// Step 7c: Confirm aliased uploads really are MP4 before processing them as such
+	if opts.aliasedType {
+		compatible, err := isMP4Compatible(tempPath)
+		if err != nil {
+			return database.Video{}, &uploadError{http.StatusBadRequest, "Unable to read video file", err}
+		}
+		if !compatible {
+			return database.Video{}, &uploadError{http.StatusBadRequest, "Only MP4 videos are allowed", nil}
+		}
+	}
+	// Whatever the file was labelled, check what it really is. Other containers
+	// than MP4 are converted on the way; see video_containers.go
+	container, err := detectVideoContainer(tempPath)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusBadRequest, "Unable to read video file", err}
+	}
+	if !slices.Contains(cfg.allowedVideoContainers, container) {
+		return database.Video{}, cfg.unsupportedContainerError()
+	}
+	if container != "mp4" {
+		cfg.processingLogs.printf(videoID, "Converting %s upload to MP4", container)
+	}
+
+	// Step 7d: Make sure the codecs will play in browsers, re-encoding them if configured to
+	sourcePath := tempPath
+	incompatibleCodecs, err := findIncompatibleCodecs(sourcePath, cfg.allowedVideoCodecs, cfg.allowedAudioCodecs)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusBadRequest, "Unable to read video file", err}
+	}
+	if len(incompatibleCodecs) > 0 {
+		// A file that has to be converted anyway is re-encoded whatever the profile
+		if !opts.profile.has(stepTranscode) && container == "mp4" {
+			msg := fmt.Sprintf("Unsupported codecs: %s. Please upload H.264/AAC video", strings.Join(incompatibleCodecs, ", "))
+			return database.Video{}, &uploadError{http.StatusBadRequest, msg, nil}
+		}
+
+		cfg.processingLogs.printf(videoID, "Transcoding video with incompatible codecs %v", incompatibleCodecs)
+		cfg.setProcessingStage(videoID, "transcoding")
+		sourcePath, err = transcodeToH264AAC(sourcePath)
+		if err != nil {
+			return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to transcode video", err}
+		}
+	}
+
+	// Step 7e: Deal with HDR video, which SDR screens show washed out; see hdr.go
+	colorInfo, err := getVideoColorInfo(sourcePath)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusBadRequest, "Unable to read video file", err}
+	}
+	if colorInfo.isHDR() {
+		cfg.processingLogs.printf(videoID, "Detected HDR video (%s transfer, %s primaries)", colorInfo.transfer, colorInfo.primaries)
+		switch cfg.hdrHandling {
+		case hdrHandlingReject:
+			return database.Video{}, &uploadError{http.StatusBadRequest, "HDR videos aren't supported. Please upload SDR (BT.709) video", nil}
+		case hdrHandlingTonemap:
+			cfg.setProcessingStage(videoID, "tonemapping")
+			sourcePath, err = tonemapToSDR(sourcePath)
+			if err != nil {
+				return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to convert HDR video", err}
+			}
+			colorInfo = videoColorInfo{primaries: "bt709", transfer: "bt709", space: "bt709"}
+		}
+	}
+
+	// Step 7f: Scale oversized videos down to the configured maximum resolution
+	if opts.profile.has(stepDownscale) && cfg.maxVideoResolution > 0 {
+		width, height, err := getVideoDimensions(sourcePath)
+		if err != nil {
+			return database.Video{}, &uploadError{http.StatusBadRequest, "Unable to read video file", err}
+		}
+		targetWidth, targetHeight := fitResolution(width, height, cfg.maxVideoResolution)
+		if targetWidth != width || targetHeight != height {
+			cfg.processingLogs.printf(videoID, "Downscaling video from %dx%d to %dx%d", width, height, targetWidth, targetHeight)
+			cfg.setProcessingStage(videoID, "downscaling")
+			sourcePath, err = downscaleVideo(sourcePath, targetWidth, targetHeight)
+			if err != nil {
+				return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to downscale video", err}
+			}
+		}
+	}
+
+	// Step 7g: Bring the audio to a consistent loudness
+	if opts.profile.has(stepLoudnorm) {
+		hasAudio, err := hasAudioStream(sourcePath)
+		if err != nil {
+			return database.Video{}, &uploadError{http.StatusBadRequest, "Unable to read video file", err}
+		}
+		if hasAudio {
+			cfg.setProcessingStage(videoID, "normalizing_audio")
+			sourcePath, err = normalizeLoudness(sourcePath)
+			if err != nil {
+				return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to normalize audio", err}
+			}
+		}
+	}
+
+	// Step 7h: Process video for fast start in-order to enable video streaming before uploading to S3.
+	// Clients can ask to skip this for files that are already optimized, which we verify first,
+	// or turn it off altogether.
+	processedPath := sourcePath
+	skipProcessing := !opts.profile.has(stepFastStart)
+	if opts.noFastStart && !skipProcessing {
+		skipProcessing = true
+		fastStart, err := isFastStart(sourcePath)
+		if err == nil && !fastStart {
+			cfg.processingLogs.printf(videoID, "Warning: fast start turned off for a video that isn't fast start; it can't play until fully downloaded")
+		} else {
+			cfg.processingLogs.printf(videoID, "Fast start turned off, storing the video as uploaded")
+		}
+	}
+	if opts.skipProcessing && !skipProcessing {
+		fastStart, err := isFastStart(sourcePath)
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Warning: couldn't check fast start, processing anyway: %v", err)
+		} else if !fastStart {
+			cfg.processingLogs.printf(videoID, "Warning: skip_processing requested but the video isn't fast start, processing anyway")
+		}
+		skipProcessing = fastStart
+	}
+
+	// Every other step writes MP4, but a file that went through none of them is
+	// still in its upload container and has to be remuxed
+	if skipProcessing && container != "mp4" && sourcePath == tempPath {
+		cfg.processingLogs.printf(videoID, "Remuxing %s upload to MP4 even though fast start was skipped", container)
+		skipProcessing = false
+	}
+
+	if !skipProcessing {
+		cfg.setProcessingStage(videoID, "optimizing")
+		processedPath, err = processVideoForFastStart(sourcePath)
+		if err != nil {
+			return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to process video for fast start", err}
+		}
+	}
+
+	// Open the processed file for S3 upload
+	processedFile, err := os.Open(processedPath)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to open processed video", err}
+	}
+	defer processedFile.Close()
+
+	// Generate random filename
+	randomString, err := generateRandomName()
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to generate random filename", err}
+	}
+
+	// Detect video aspect ratio
+	aspectRatio, err := getVideoAspectRatio(processedPath, cfg.ffprobeAttempts, cfg.ffprobeRetryDelay)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to analyze video", err}
+	}
+
+	cfg.processingLogs.printf(videoID, "Detected video aspect ratio: %s", aspectRatio)
+
+	// Prefer when the video was filmed over when it was uploaded
+	recordedAt := time.Now().UTC()
+	if metadataTime, ok, err := getVideoRecordedAt(processedPath); err != nil {
+		cfg.processingLogs.printf(videoID, "Warning: couldn't read recording date: %v", err)
+	} else if ok {
+		recordedAt = metadataTime
+	}
+
+	// The length is only shown to viewers, so a file that doesn't say still goes in
+	var durationSeconds *float64
+	if duration, err := getVideoDuration(processedPath); err != nil {
+		cfg.processingLogs.printf(videoID, "Warning: couldn't read duration: %v", err)
+	} else {
+		durationSeconds = &duration
+	}
+
+	// Fingerprint what the video looks like, so re-encodes of it can be found;
+	// see perceptual_hash.go
+	var perceptualHash *string
+	if opts.profile.has(stepPHash) {
+		cfg.setProcessingStage(videoID, "fingerprinting")
+		hash, err := cfg.videoPerceptualHash(processedPath)
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Warning: couldn't compute perceptual hash: %v", err)
+		} else {
+			perceptualHash = &hash
+		}
+	}
+
+	// The owner's own bucket if they have one, otherwise ours
+	storage, err := cfg.storageForUser(video.UserID)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to get storage settings", err}
+	}
+
+	// Create S3 key with aspect ratio prefix, or the prefix (and bucket and storage
+	// class) configured for it. With version tracking a replacement overwrites the
+	// existing object instead, so S3 keeps the old upload as a version.
+	storage, keyPrefix := cfg.routeByAspect(storage, aspectRatio)
+	if opts.draft {
+		// Kept apart until published; see draft.go
+		storage = cfg.draftStorage()
+		keyPrefix = draftKeyPrefix + "/" + keyPrefix
+	}
+	fileKey, err := buildObjectKey(keyPrefix, randomString+".mp4")
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to build object key", err}
+	}
+	if !opts.draft && cfg.trackObjectVersions {
+		if existingKey, ok := replaceableVideoKey(video, storage.bucket, keyPrefix); ok {
+			fileKey = existingKey
+		}
+	}
+
+	// A conditional replace checks the current file again right before storing,
+	// since another upload may have replaced it while this one was processing
+	if opts.ifMatch != "" {
+		current, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to get video", err}
+		}
+		err = cfg.checkVideoIfMatch(ctx, current, opts.ifMatch)
+		if err != nil {
+			return database.Video{}, err
+		}
+	}
+
+	// Step 8: Upload to S3 with retry logic
+	cfg.setProcessingStage(videoID, "storing")
+	// A stuck upload gives up eventually rather than holding on to the request
+	uploadCtx, cancel := context.WithTimeout(ctx, cfg.s3UploadTimeout)
+	defer cancel()
+	versionID, err := cfg.uploadToS3(uploadCtx, storage, fileKey, processedFile, "video/mp4", opts.metadata)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to upload to S3 after retries", err}
+	}
+
+	// Step 9: Update DB with S3 URL, pinning the version we just wrote if tracked
+	videoURL := fmt.Sprintf("%s,%s", storage.bucket, fileKey)
+	if cfg.trackObjectVersions && versionID != "" {
+		videoURL += "," + versionID
+	}
+
+	// Update the video with the S3 URL. Processing can take minutes, so the row
+	// is read again rather than writing back the copy loaded at the start, which
+	// would undo changes made meanwhile, such as the video being made private.
+	current, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to get video", err}
+	}
+	if current.ID == uuid.Nil {
+		return database.Video{}, &uploadError{http.StatusNotFound, "Video was deleted while processing", nil}
+	}
+	updatedVideo := current
+	updatedVideo.UpdatedAt = time.Now()
+	updatedVideo.VideoURL = &videoURL
+	updatedVideo.RecordedAt = &recordedAt
+	updatedVideo.ColorPrimaries = nilIfEmpty(colorInfo.primaries)
+	updatedVideo.ColorTransfer = nilIfEmpty(colorInfo.transfer)
+	updatedVideo.ColorSpace = nilIfEmpty(colorInfo.space)
+	updatedVideo.HDR = colorInfo.isHDR()
+	updatedVideo.PerceptualHash = perceptualHash
+	updatedVideo.DurationSeconds = durationSeconds
+	updatedVideo.DraftExpiresAt = nil
+	if opts.draft {
+		draftExpiresAt := time.Now().UTC().Add(cfg.draftTTL)
+		updatedVideo.DraftExpiresAt = &draftExpiresAt
+	}
+
+	// Update video in database
+	err = cfg.db.UpdateVideo(updatedVideo)
+	if err != nil {
+		return database.Video{}, &uploadError{http.StatusInternalServerError, "Failed to update video", err}
+	}
+	if current.VideoURL != nil && *current.VideoURL != "" {
+		cfg.recordAudit(auditVideoReplace, video.UserID, videoID, opts.clientIP, map[string]any{
+			"previous_video_url": *current.VideoURL,
+			"video_url":          videoURL,
+		})
+
+		// Renditions, sprites and the like show the previous file; new ones are
+		// generated below. The new file is in place, so this goes ahead even if the
+		// client has gone.
+		deleted, err := cfg.deleteStaleAssets(context.WithoutCancel(ctx), updatedVideo, *current.VideoURL)
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Warning: couldn't remove all assets of the previous file: %v", err)
+		} else if deleted > 0 {
+			cfg.processingLogs.printf(videoID, "Removed %d assets of the previous file", deleted)
+		}
+	}
+
+	// Step 10: Generate scrub preview assets. The video itself is already stored,
+	// so a failure here is logged rather than failing the upload.
+	if opts.profile.has(stepScrubPreview) {
+		cfg.setProcessingStage(videoID, "generating_previews")
+		err = cfg.generateScrubPreview(ctx, videoID, processedPath, assetPrefix(fileKey))
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Failed to generate scrub preview: %v", err)
+		}
+	}
+
+	// Step 11: Give videos without a thumbnail one picked from the video itself
+	if opts.profile.has(stepThumbnail) && updatedVideo.ThumbnailURL == nil {
+		cfg.setProcessingStage(videoID, "generating_thumbnail")
+		withThumbnail, err := cfg.generateAutoThumbnail(updatedVideo, processedPath, aspectRatio)
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Failed to generate thumbnail: %v", err)
+		} else {
+			updatedVideo = withThumbnail
+		}
+	}
+
+	// Step 12: Store lower resolution renditions
+	if opts.profile.has(stepRenditions) && len(cfg.renditions) > 0 {
+		cfg.setProcessingStage(videoID, "generating_renditions")
+		_, err = cfg.generateRenditions(ctx, videoID, processedPath, assetPrefix(fileKey), cfg.renditions)
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Failed to generate renditions: %v", err)
+		}
+	}
+
+	// Step 13: Index keyframes for editors and segmenting
+	if opts.profile.has(stepKeyframes) {
+		cfg.setProcessingStage(videoID, "indexing_keyframes")
+		_, err = cfg.indexKeyframes(videoID, processedPath)
+		if err != nil {
+			cfg.processingLogs.printf(videoID, "Failed to index keyframes: %v", err)
+		}
+	}
+
+	return updatedVideo, nil
+}
+
+// Returns the key of the video's current object if a replacement can overwrite it:
+// it has to live in the bucket the replacement goes to, under the same aspect
+// ratio (or configured) prefix
+func replaceableVideoKey(video database.Video, targetBucket, keyPrefix string) (string, bool) {
+	if video.VideoURL == nil {
+		return "", false
+	}
+	bucket, key, err := parseStoredURL(*video.VideoURL)
+	if err != nil || bucket != targetBucket {
+		return "", false
+	}
+	if !strings.HasPrefix(key, keyPrefix+"/") || !strings.HasSuffix(key, ".mp4") {
+		return "", false
+	}
+	return key, true
+}
+
+// Copies a failed upload and its intermediate files, along with the processing
+// log, into a directory of their own under debugFailedUploadsDir
+func (cfg *apiConfig) preserveFailedUpload(videoID uuid.UUID, tempPath string) {
+	dir := filepath.Join(cfg.debugFailedUploadsDir, fmt.Sprintf("%s-%s", videoID, time.Now().UTC().Format("20060102T150405")))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Printf("Couldn't keep failed upload of video %s: %v", videoID, err)
+		return
+	}
+
+	paths, _ := filepath.Glob(tempPath + ".*")
+	for _, path := range append([]string{tempPath}, paths...) {
+		err = copyFile(path, filepath.Join(dir, filepath.Base(path)))
+		if err != nil {
+			log.Printf("Couldn't keep %s for video %s: %v", path, videoID, err)
+		}
+	}
+
+	if processingLog, ok := cfg.processingLogs.get(videoID); ok {
+		logText := strings.Join(processingLog.Lines, "\n") + "\n"
+		os.WriteFile(filepath.Join(dir, "processing.log"), []byte(logText), 0644)
+	}
+
+	log.Printf("Kept failed upload of video %s in %s", videoID, dir)
+}
+
+// Copies the file at src to dst, replacing dst if it exists
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+/*
+Conditional replaces
+
+A replace upload can carry If-Match with the ETag of the video's S3 object (as
+returned by GET /videos/{videoID}/object), so it only goes through if nobody
+else replaced the video since the client last looked. The ETag is checked with
+a HeadObject when the upload starts, so a stale client finds out before sending
+the file, and again right before the processed video is stored, in case another
+replace finished while this one was processing. A mismatch is a 412.
+*/
+
+// Whether an If-Match header value matches an S3 ETag. Clients may send ETags
+// with or without their quotes, several of them separated by commas, or "*" for
+// any. Weak ETags never match, as If-Match uses strong comparison.
+func etagMatches(ifMatch, etag string) bool {
+	etag = strings.Trim(etag, `"`)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks an If-Match precondition against the ETag of the video's current S3
+// object. An empty ifMatch always passes; anything else fails if the video has
+// no file yet.
+func (cfg *apiConfig) checkVideoIfMatch(ctx context.Context, video database.Video, ifMatch string) error {
+	if ifMatch == "" {
+		return nil
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		return &uploadError{http.StatusPreconditionFailed, "If-Match was given but the video has no file yet", nil}
+	}
+
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "Couldn't read video location", err}
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		return &uploadError{http.StatusInternalServerError, "Couldn't get video storage", err}
+	}
+	output, err := storage.client.HeadObject(ctx, input)
+	if err != nil {
+		return &uploadError{http.StatusBadGateway, "Couldn't get video object", err}
+	}
+
+	if !etagMatches(ifMatch, aws.ToString(output.ETag)) {
+		return &uploadError{http.StatusPreconditionFailed, "Video has changed since the ETag in If-Match", nil}
+	}
+	return nil
+}
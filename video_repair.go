@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+/*
+Repairing derived assets
+
+When a step after the video itself was stored fails (a rendition, the scrub
+preview, the thumbnail), the upload still succeeds and the video is left without
+that asset. A repair works out which assets the default processing profile
+should have produced, checks each one exists (recorded in video_assets and
+present in S3, or for the thumbnail, present in the assets directory), and
+regenerates only what's missing from the stored video.
+*/
+
+// Names used in repair reports
+const (
+	repairAssetScrubPreview = "scrub_preview"
+	repairAssetThumbnail    = "thumbnail"
+)
+
+type repairReport struct {
+	Missing  []string          `json:"missing"`
+	Repaired []string          `json:"repaired"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+func (r *repairReport) fail(asset string, err error) {
+	if r.Failed == nil {
+		r.Failed = make(map[string]string)
+	}
+	r.Failed[asset] = err.Error()
+}
+
+// Checks a video's derived assets and regenerates the missing ones
+func (cfg *apiConfig) repairVideoAssets(ctx context.Context, video database.Video) (repairReport, error) {
+	report := repairReport{Missing: []string{}, Repaired: []string{}}
+
+	bucket, key, versionID, err := parseStoredObject(*video.VideoURL)
+	if err != nil {
+		return report, err
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		return report, err
+	}
+	assets, err := cfg.db.GetVideoAssets(video.ID)
+	if err != nil {
+		return report, err
+	}
+	profile, _ := cfg.processingProfile("")
+
+	// Scrub preview: every sprite page and the thumbnail track
+	missingScrubPreview := false
+	if profile.has(stepScrubPreview) {
+		var sprites, tracks int
+		for _, asset := range assets {
+			if asset.Kind != "sprite" && asset.Kind != "thumbnail_track" {
+				continue
+			}
+			if asset.Kind == "sprite" {
+				sprites++
+			} else {
+				tracks++
+			}
+			exists, err := cfg.storedObjectExists(ctx, video, asset.URL)
+			if err != nil {
+				return report, err
+			}
+			if !exists {
+				missingScrubPreview = true
+			}
+		}
+		if sprites == 0 || tracks == 0 {
+			missingScrubPreview = true
+		}
+		if missingScrubPreview {
+			report.Missing = append(report.Missing, repairAssetScrubPreview)
+		}
+	}
+
+	// Renditions: each configured one smaller than the source
+	var missingRenditions []string
+	if profile.has(stepRenditions) && len(cfg.renditions) > 0 {
+		// ffprobe only needs the head of the file, not all of it
+		sourceURL, err := cfg.videoSourceURL(ctx, video)
+		if err != nil {
+			return report, err
+		}
+		_, sourceHeight, err := getVideoDimensions(sourceURL)
+		if err != nil {
+			return report, err
+		}
+		for _, name := range cfg.expectedRenditions(sourceHeight) {
+			exists := false
+			for _, asset := range assets {
+				if asset.Kind == assetKindRendition && asset.Name == name {
+					exists, err = cfg.storedObjectExists(ctx, video, asset.URL)
+					if err != nil {
+						return report, err
+					}
+					break
+				}
+			}
+			if !exists {
+				missingRenditions = append(missingRenditions, name)
+				report.Missing = append(report.Missing, "rendition:"+name)
+			}
+		}
+	}
+
+	// Thumbnail: only ones we store locally can be checked
+	missingThumbnail := false
+	if profile.has(stepThumbnail) {
+		if video.ThumbnailURL == nil {
+			missingThumbnail = true
+		} else if thumbnailPath, ok := cfg.localAssetPath(*video.ThumbnailURL); ok {
+			_, err := os.Stat(thumbnailPath)
+			missingThumbnail = errors.Is(err, os.ErrNotExist)
+		}
+		if missingThumbnail {
+			report.Missing = append(report.Missing, repairAssetThumbnail)
+		}
+	}
+
+	if len(report.Missing) == 0 {
+		return report, nil
+	}
+
+	cfg.processingLogs.start(video.ID)
+	cfg.processingLogs.printf(video.ID, "Repairing missing assets: %s", strings.Join(report.Missing, ", "))
+
+	source, err := os.CreateTemp(cfg.uploadTempDir, "tubely-repair-*.mp4")
+	if err != nil {
+		return report, err
+	}
+	defer os.Remove(source.Name())
+	defer source.Close()
+	_, err = fetchS3Range(ctx, storage.client, source, bucket, key, versionID, 0, 0)
+	if err != nil {
+		return report, err
+	}
+	source.Close()
+
+	keyPrefix := assetPrefix(key)
+	if missingScrubPreview {
+		err = cfg.generateScrubPreview(ctx, video.ID, source.Name(), keyPrefix)
+		if err != nil {
+			report.fail(repairAssetScrubPreview, err)
+		} else {
+			report.Repaired = append(report.Repaired, repairAssetScrubPreview)
+		}
+	}
+
+	if len(missingRenditions) > 0 {
+		stored, err := cfg.generateRenditions(ctx, video.ID, source.Name(), keyPrefix, missingRenditions)
+		for _, name := range missingRenditions {
+			switch {
+			case slices.Contains(stored, name):
+				report.Repaired = append(report.Repaired, "rendition:"+name)
+			case renditionFailure(err, name) != nil:
+				report.fail("rendition:"+name, renditionFailure(err, name))
+			default:
+				report.fail("rendition:"+name, errors.New("source is too small for this rendition"))
+			}
+		}
+	}
+
+	if missingThumbnail {
+		_, err = cfg.generateAutoThumbnail(video, source.Name(), "")
+		if err != nil {
+			report.fail(repairAssetThumbnail, err)
+		} else {
+			report.Repaired = append(report.Repaired, repairAssetThumbnail)
+		}
+	}
+
+	cfg.processingLogs.printf(video.ID, "Repair complete: repaired %d of %d", len(report.Repaired), len(report.Missing))
+	return report, nil
+}
+
+// Whether a stored "bucket,key" object of the video's is still in S3
+func (cfg *apiConfig) storedObjectExists(ctx context.Context, video database.Video, stored string) (bool, error) {
+	bucket, key, versionID, err := parseStoredObject(stored)
+	if err != nil {
+		return false, err
+	}
+	storage, err := cfg.storageForObject(video.UserID, bucket)
+	if err != nil {
+		return false, err
+	}
+
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	_, err = storage.client.HeadObject(ctx, input)
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", key, err)
+	}
+	return true, nil
+}
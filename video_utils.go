@@ -2,25 +2,153 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Struct to parse ffprobe JSON output
 type FFProbeOutput struct {
-	Streams []struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"streams"`
+	Streams []FFProbeStream `json:"streams"`
+	Format  struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
+type FFProbeStream struct {
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Duration  string `json:"duration"`
+	// Color characteristics; see hdr.go
+	ColorPrimaries string `json:"color_primaries"`
+	ColorTransfer  string `json:"color_transfer"`
+	ColorSpace     string `json:"color_space"`
+	Disposition    struct {
+		// Cover art embedded in the file, which ffprobe lists as a video stream
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+// Whether the stream is actual video, as opposed to audio or embedded cover art
+func (s FFProbeStream) isVideo() bool {
+	return s.CodecType == "video" && s.Disposition.AttachedPic == 0
+}
+
+// Picks the stream that is the video itself: the largest video stream that isn't
+// cover art. Cover art and secondary streams (e.g. a picture-in-picture angle)
+// can come first, so the order of the streams says nothing.
+func (p FFProbeOutput) mainVideoStream() (FFProbeStream, bool) {
+	var best FFProbeStream
+	found := false
+	for _, stream := range p.Streams {
+		if !stream.isVideo() || stream.Width <= 0 || stream.Height <= 0 {
+			continue
+		}
+		if !found || stream.Width*stream.Height > best.Width*best.Height {
+			best = stream
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Limits how many ffmpeg processes run at once across the whole server, however
+// many uploads, renditions and transcodes want one; each can keep every core
+// busy. Nil when there's no limit. Set with setFFmpegConcurrency.
+var ffmpegSlots chan struct{}
+
+// Allows at most n ffmpeg processes at once, or any number if n is 0 or less.
+// Called once at startup, before anything runs ffmpeg.
+func setFFmpegConcurrency(n int) {
+	ffmpegSlots = nil
+	if n > 0 {
+		ffmpegSlots = make(chan struct{}, n)
+	}
+}
+
+// Waits for a free ffmpeg slot, or until ctx is done. Call the returned function
+// once ffmpeg has exited.
+func acquireFFmpegSlot(ctx context.Context) (func(), error) {
+	if ffmpegSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case ffmpegSlots <- struct{}{}:
+		return func() { <-ffmpegSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Runs an ffmpeg/ffprobe command. On failure the end of its stderr is added to the
+// error, since the exit status alone says nothing about what went wrong. ffmpeg
+// commands wait their turn for a slot first; ffprobe is quick and never waits.
+func runCommand(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if len(cmd.Args) > 0 && cmd.Args[0] == "ffmpeg" {
+		release, err := acquireFFmpegSlot(context.Background())
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) > 10 {
+		lines = lines[len(lines)-10:]
+	}
+	output := strings.TrimSpace(strings.Join(lines, "\n"))
+	if output == "" {
+		return err
+	}
+	return fmt.Errorf("%w\n%s", err, output)
+}
+
+// Runs ffprobe against a file and parses its stream and format information
+func probeVideo(filePath string) (FFProbeOutput, error) {
+	output, err := probeVideoJSON(filePath)
+	if err != nil {
+		return FFProbeOutput{}, err
+	}
+
+	// Parse JSON output
+	var probeOutput FFProbeOutput
+	err = json.Unmarshal(output, &probeOutput)
+	if err != nil {
+		return FFProbeOutput{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	return probeOutput, nil
+}
+
+// Runs ffprobe against a file (or URL) and returns its stream and format
+// information as ffprobe prints it, with every field it knows about
+func probeVideoJSON(filePath string) ([]byte, error) {
 	// Run ffprobe command
 	cmd := exec.Command("ffprobe", 
 		"-v", "error",
 		"-print_format", "json", 
 		"-show_streams", 
+		"-show_format",
 		filePath,
 	)
 	
@@ -29,56 +157,250 @@ func getVideoAspectRatio(filePath string) (string, error) {
 	cmd.Stdout = &stdout
 	
 	// Run the command
-	err := cmd.Run()
+	err := runCommand(cmd)
 	if err != nil {
-		return "", fmt.Errorf("ffprobe failed: %w", err)
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
 	}
-	
-	// Parse JSON output
-	var probeOutput FFProbeOutput
-	err = json.Unmarshal(stdout.Bytes(), &probeOutput)
+
+	return stdout.Bytes(), nil
+}
+
+// Categorizes the aspect ratio of the main video stream. Files with no video
+// stream at all are "other". ffprobe can come back empty-handed if it races the
+// file being closed, so a failed probe, or one that finds no streams or a video
+// stream without dimensions, is retried up to attempts times in all, delay apart,
+// before giving up with an error.
+func getVideoAspectRatio(filePath string, attempts int, delay time.Duration) (string, error) {
+	var lastErr error
+	for attempt := 1; attempt <= max(attempts, 1); attempt++ {
+		if attempt > 1 {
+			time.Sleep(delay)
+		}
+
+		probeOutput, err := probeVideo(filePath)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		stream, ok := probeOutput.mainVideoStream()
+		if ok {
+			return categorizeAspectRatio(stream.Width, stream.Height), nil
+		}
+		if len(probeOutput.Streams) == 0 {
+			lastErr = errors.New("ffprobe found no streams")
+			continue
+		}
+		if !slices.ContainsFunc(probeOutput.Streams, FFProbeStream.isVideo) {
+			// A genuine file without video, e.g. audio only
+			return "other", nil
+		}
+		lastErr = errors.New("ffprobe found a video stream without dimensions")
+	}
+	return "", fmt.Errorf("couldn't detect aspect ratio after %d attempts: %w", max(attempts, 1), lastErr)
+}
+
+// Returns the dimensions of the main video stream
+func getVideoDimensions(filePath string) (int, int, error) {
+	probeOutput, err := probeVideo(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse ffprobe output: %w", err)
+		return 0, 0, err
 	}
-	
-	// Check if we have video streams
-	if len(probeOutput.Streams) == 0 {
-		return "other", nil
+	if stream, ok := probeOutput.mainVideoStream(); ok {
+		return stream.Width, stream.Height, nil
 	}
-	
-	// Get first stream's dimensions
-	width := probeOutput.Streams[0].Width
-	height := probeOutput.Streams[0].Height
-	
-	if width == 0 || height == 0 {
-		return "other", nil
+	return 0, 0, fmt.Errorf("no video stream found in %s", filePath)
+}
+
+// Returns the length of the video in seconds, from the container or, if it
+// doesn't say, the main video stream
+func getVideoDuration(filePath string) (float64, error) {
+	probeOutput, err := probeVideo(filePath)
+	if err != nil {
+		return 0, err
 	}
-	
-	// Calculate aspect ratio and determine category
-	return categorizeAspectRatio(width, height), nil
+	duration, err := strconv.ParseFloat(probeOutput.Format.Duration, 64)
+	if err != nil || duration <= 0 {
+		stream, ok := probeOutput.mainVideoStream()
+		if ok {
+			duration, err = strconv.ParseFloat(stream.Duration, 64)
+		}
+		if !ok || err != nil || duration <= 0 {
+			return 0, fmt.Errorf("no duration found in %s", filePath)
+		}
+	}
+	return duration, nil
+}
+
+// Returns when the video was recorded according to its creation_time tag, which
+// phones and cameras set. False if the tag is missing or unusable.
+func getVideoRecordedAt(filePath string) (time.Time, bool, error) {
+	probeOutput, err := probeVideo(filePath)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	value := probeOutput.Format.Tags["creation_time"]
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+	recordedAt, err := time.Parse(time.RFC3339Nano, value)
+	// Files written without a clock set claim the epoch (1904 for QuickTime)
+	if err != nil || recordedAt.Year() < 1971 {
+		return time.Time{}, false, nil
+	}
+	return recordedAt.UTC(), true, nil
+}
+
+// Reports whether ffprobe sees the file as an ISO base media (MP4 family) container.
+// ffprobe reports MP4 and QuickTime files with the same demuxer ("mov,mp4,m4a,3gp,3g2,mj2"),
+// so this confirms the file can be remuxed to MP4 regardless of the label the client sent.
+func isMP4Compatible(filePath string) (bool, error) {
+	probeOutput, err := probeVideo(filePath)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range strings.Split(probeOutput.Format.FormatName, ",") {
+		if name == "mp4" {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
+// Sorts a video into landscape, portrait or other; see analyzeAspectRatio
 func categorizeAspectRatio(width, height int) string {
-	// Calculate aspect ratio as float for comparison
-	ratio := float64(width) / float64(height)
-	
-	// Define aspect ratio targets with tolerance
-	landscapeTarget := 16.0 / 9.0   // ≈1.777
-	portraitTarget := 9.0 / 16.0    // ≈0.5625
-	tolerance := 0.1                // 10% tolerance
-	
-	// Check if close to landscape (16:9)
-	if ratio >= landscapeTarget-tolerance && ratio <= landscapeTarget+tolerance {
-		return "landscape"
+	return analyzeAspectRatio(width, height).Category
+}
+
+// Lists codecs in the file that aren't in the allowed lists for their stream type.
+// Streams other than video and audio (subtitles, data, cover art) are ignored.
+func findIncompatibleCodecs(filePath string, allowedVideo, allowedAudio []string) ([]string, error) {
+	probeOutput, err := probeVideo(filePath)
+	if err != nil {
+		return nil, err
 	}
-	
-	// Check if close to portrait (9:16)  
-	if ratio >= portraitTarget-tolerance && ratio <= portraitTarget+tolerance {
-		return "portrait"
+
+	var incompatible []string
+	for _, stream := range probeOutput.Streams {
+		switch {
+		case stream.isVideo():
+			if !slices.Contains(allowedVideo, stream.CodecName) {
+				incompatible = append(incompatible, stream.CodecName)
+			}
+		case stream.CodecType == "audio":
+			if !slices.Contains(allowedAudio, stream.CodecName) {
+				incompatible = append(incompatible, stream.CodecName)
+			}
+		}
 	}
-	
-	// Everything else
-	return "other"
+	return incompatible, nil
+}
+
+// Re-encodes a video to H.264/AAC, which plays in every mainstream browser
+func transcodeToH264AAC(inputPath string) (string, error) {
+	outputPath := inputPath + ".transcoded"
+
+	cmd := exec.Command("ffmpeg",
+		"-i", inputPath,
+		"-c:v", "libx264", // H.264 video
+		"-preset", "veryfast", // Favour speed, uploads are waiting on this
+		"-crf", "23", // Default quality
+		"-pix_fmt", "yuv420p", // Widest decoder support
+		"-c:a", "aac", // AAC audio
+		"-b:a", "128k",
+		"-f", "mp4",
+		outputPath,
+	)
+
+	err := runCommand(cmd)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg transcode failed: %w", err)
+	}
+
+	return outputPath, nil
+}
+
+// Works out the size to scale a width x height video to so that its short side is at
+// most maxShortSide and its long side at most the 16:9 equivalent, preserving the
+// aspect ratio. Portrait videos get the same limits turned on their side. Returns
+// the original size if it already fits.
+func fitResolution(width, height, maxShortSide int) (int, int) {
+	maxLongSide := maxShortSide * 16 / 9
+	short, long := min(width, height), max(width, height)
+
+	scale := min(1, float64(maxShortSide)/float64(short), float64(maxLongSide)/float64(long))
+	if scale >= 1 {
+		return width, height
+	}
+
+	// H.264 with yuv420p needs even dimensions
+	even := func(n float64) int {
+		return max(2, int(n/2)*2)
+	}
+	return even(float64(width) * scale), even(float64(height) * scale)
+}
+
+// Re-encodes a video at a smaller size
+func downscaleVideo(inputPath string, width, height int) (string, error) {
+	outputPath := inputPath + ".downscaled"
+
+	cmd := exec.Command("ffmpeg",
+		"-i", inputPath,
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "copy", // Audio is unaffected by the resolution
+		"-f", "mp4",
+		outputPath,
+	)
+
+	err := runCommand(cmd)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg downscale failed: %w", err)
+	}
+
+	return outputPath, nil
+}
+
+// Whether the file has at least one audio stream
+func hasAudioStream(filePath string) (bool, error) {
+	probeOutput, err := probeVideo(filePath)
+	if err != nil {
+		return false, err
+	}
+	for _, stream := range probeOutput.Streams {
+		if stream.CodecType == "audio" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Re-encodes the audio to a consistent loudness (EBU R128, -16 LUFS like most
+// streaming services), leaving the video stream untouched
+func normalizeLoudness(inputPath string) (string, error) {
+	outputPath := inputPath + ".loudnorm"
+
+	cmd := exec.Command("ffmpeg",
+		"-i", inputPath,
+		"-c:v", "copy",
+		"-af", "loudnorm=I=-16:TP=-1.5:LRA=11",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-f", "mp4",
+		outputPath,
+	)
+
+	err := runCommand(cmd)
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg loudness normalization failed: %w", err)
+	}
+
+	return outputPath, nil
 }
 
 /*
@@ -114,6 +436,7 @@ Fast Start MP4:
 */
 
 // Function that moves the moov atom (Table of content) to the beginning of the MP4 file.
+// Streams are copied as they are, so this also remuxes a MOV upload into MP4.
 func processVideoForFastStart(inputPath string) (string, error) {
 	// Create output file path (add .processing to original)
 	outputPath := inputPath + ".processing"
@@ -128,10 +451,56 @@ func processVideoForFastStart(inputPath string) (string, error) {
 	)
 
 	// Run the command
-	err := cmd.Run()
+	err := runCommand(cmd)
 	if err != nil {
 		return "", fmt.Errorf("ffmpeg faststart failed: %w", err)
 	}
 
 	return outputPath, nil
 }
+
+// Reports whether the moov atom comes before the media data (mdat) in an MP4 file,
+// i.e. whether it is already fast start. Only the top-level box headers are read.
+func isFastStart(filePath string) (bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 16)
+	var offset int64
+	for {
+		// Each box starts with a 32-bit size and a 4 character type
+		_, err := f.ReadAt(header[:8], offset)
+		if err != nil {
+			return false, fmt.Errorf("no moov or mdat box found: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+
+		switch boxType {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		switch size {
+		case 0:
+			// Box extends to the end of the file
+			return false, fmt.Errorf("no moov box before end of file")
+		case 1:
+			// 64-bit size follows the type
+			_, err := f.ReadAt(header[8:16], offset+8)
+			if err != nil {
+				return false, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return false, fmt.Errorf("invalid box size %d at offset %d", size, offset)
+		}
+		offset += size
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Runs background jobs on a fixed number of goroutines. When interval is set, at
+// most one job starts per interval across all workers, so a large batch can't
+// hog ffmpeg, S3 or the disk.
+//
+// A job that fails is queued again after a backoff that doubles with every
+// attempt (retryBackoff, 2*retryBackoff, ...) until it has been tried
+// maxAttempts times, so a blip in S3 or a crashed ffmpeg doesn't fail it for
+// good. Jobs that still fail, or fail with a permanent error, end up on the
+// dead-letter list.
+type workerPool struct {
+	jobs chan *backgroundJob
+	// Nil when jobs aren't rate limited
+	ticks <-chan time.Time
+
+	maxAttempts  int
+	retryBackoff time.Duration
+
+	mu          sync.Mutex
+	deadLetters []deadLetter
+}
+
+// Longest wait before a retry, however many attempts came before
+const maxRetryBackoff = 10 * time.Minute
+
+// How many permanently failed jobs are remembered, newest first
+const maxDeadLetters = 1000
+
+type backgroundJob struct {
+	// What the job does, for logs and the dead-letter list
+	name string
+	run  func() error
+	// Called before the job is queued again, with the attempts made so far and
+	// the error of the last one (optional)
+	retrying func(attempts int, err error)
+	// Called once the job succeeded or gave up (optional)
+	done func(attempts int, err error)
+
+	attempts int
+}
+
+// A job that failed for good
+type deadLetter struct {
+	Name     string    `json:"name"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// An error retrying won't fix, e.g. the video is gone
+type permanentJobError struct {
+	err error
+}
+
+func (e *permanentJobError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentJobError) Unwrap() error {
+	return e.err
+}
+
+// Marks err as not worth retrying
+func permanentJobFailure(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentJobError{err: err}
+}
+
+func newWorkerPool(workers int, interval time.Duration, maxAttempts int, retryBackoff time.Duration) *workerPool {
+	p := &workerPool{
+		jobs:         make(chan *backgroundJob),
+		maxAttempts:  max(maxAttempts, 1),
+		retryBackoff: retryBackoff,
+	}
+	if interval > 0 {
+		p.ticks = time.NewTicker(interval).C
+	}
+	for range max(workers, 1) {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for job := range p.jobs {
+		if p.ticks != nil {
+			<-p.ticks
+		}
+		p.runJob(job)
+	}
+}
+
+func (p *workerPool) runJob(job *backgroundJob) {
+	job.attempts++
+	err := job.run()
+
+	var permanent *permanentJobError
+	if err != nil && !errors.As(err, &permanent) && job.attempts < p.maxAttempts {
+		delay := p.backoff(job.attempts)
+		log.Printf("Background job %q failed (attempt %d of %d), retrying in %s: %v", job.name, job.attempts, p.maxAttempts, delay, err)
+		if job.retrying != nil {
+			job.retrying(job.attempts, err)
+		}
+		time.AfterFunc(delay, func() {
+			p.jobs <- job
+		})
+		return
+	}
+
+	if err != nil {
+		log.Printf("Background job %q failed after %d attempts: %v", job.name, job.attempts, err)
+		p.addDeadLetter(deadLetter{
+			Name:     job.name,
+			Attempts: job.attempts,
+			Error:    err.Error(),
+			FailedAt: time.Now(),
+		})
+	}
+	if job.done != nil {
+		job.done(job.attempts, err)
+	}
+}
+
+// How long to wait before the retry after the given number of attempts
+func (p *workerPool) backoff(attempts int) time.Duration {
+	delay := p.retryBackoff
+	for range attempts - 1 {
+		delay *= 2
+		if delay >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+	return min(delay, maxRetryBackoff)
+}
+
+func (p *workerPool) addDeadLetter(letter deadLetter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.deadLetters = append([]deadLetter{letter}, p.deadLetters...)
+	if len(p.deadLetters) > maxDeadLetters {
+		p.deadLetters = p.deadLetters[:maxDeadLetters]
+	}
+}
+
+// The jobs that failed for good, newest first
+func (p *workerPool) failedJobs() []deadLetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]deadLetter{}, p.deadLetters...)
+}
+
+// Queues a job, waiting until a worker is free to take it
+func (p *workerPool) submit(job backgroundJob) {
+	p.jobs <- &job
+}